@@ -0,0 +1,181 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"net"
+)
+
+const maxAllocationAttempts = 10
+
+// AllocationStrategy tells which free address of the pool a peer claims.
+type AllocationStrategy string
+
+// The strategies of the allocation of the addresses from a pool.
+const (
+	// AllocateLowest claims the lowest free address
+	AllocateLowest AllocationStrategy = "lowest"
+	// AllocateHashed claims the address the public key hashes to, or the
+	// next free one, so that a peer restarted after its entry expired
+	// tends to get its address back
+	AllocateHashed AllocationStrategy = "hashed"
+)
+
+const (
+	errPoolExhausted      = "no free address left in the pool %s"
+	errAllocationFailed   = "unable to allocate an address from the pool %s after %d attempts"
+	errAllocationNotValid = "the allocation strategy must be %s or %s, got: %q"
+)
+
+func validateAllocation(strategy AllocationStrategy) error {
+	switch strategy {
+	case "", AllocateLowest, AllocateHashed:
+		return nil
+	}
+	return fmt.Errorf(errAllocationNotValid, AllocateLowest, AllocateHashed, strategy)
+}
+
+// allocateIP claims a free address of the pool, the first one after the
+// start of the Allocation strategy. Concurrent
+// allocations can pick the same address: backends implementing
+// CompareAndJoin refuse the second claim, with the others the peers are read
+// again after joining and, on a collision, the peer with the highest public
+// key backs off and tries the next free address.
+func (i *Interface) allocateIP(ctx context.Context) error {
+	// addresses claimed without a peer being visible yet
+	raced := []Peer{}
+	for attempt := 0; attempt < maxAllocationAttempts; attempt++ {
+		peers, err := i.Backend.GetPeers(ctx, i.Name)
+		if err != nil {
+			return err
+		}
+
+		ip, err := freeIP(i.Pool, append(peers, raced...), i.LocalPeer.PublicKey, i.allocationStart())
+		if err != nil {
+			return err
+		}
+
+		candidate := i.LocalPeer
+		candidate.IP = &ip
+		err = join(ctx, i.Backend, i.Name, i.signed(candidate))
+		if _, ok := err.(AddressTakenError); ok {
+			i.logger().Infof("Address %s claimed concurrently by another peer, trying the next one", ip.String())
+			raced = append(raced, Peer{IP: candidate.IP})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		peers, err = i.Backend.GetPeers(ctx, i.Name)
+		if err != nil {
+			return err
+		}
+		if !lostAddressRace(peers, candidate) {
+			i.LocalPeer.IP = &ip
+			i.logger().Infof("Allocated address %s from the pool %s", ip.String(), i.Pool.String())
+			return nil
+		}
+		i.logger().Infof("Address %s claimed concurrently by another peer, trying the next one", ip.String())
+	}
+	return fmt.Errorf(errAllocationFailed, i.Pool.String(), maxAllocationAttempts)
+}
+
+// allocationStart returns the first address the allocation considers,
+// nil for the lowest one.
+func (i *Interface) allocationStart() net.IP {
+	if i.Allocation == AllocateHashed {
+		return hashedIP(i.Pool, i.LocalPeer.PublicKey)
+	}
+	return nil
+}
+
+// hashedIP returns the address of the pool the public key hashes to.
+func hashedIP(pool *net.IPNet, publicKey []byte) net.IP {
+	network := pool.IP.Mask(pool.Mask)
+	ones, bits := pool.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	sum := sha256.Sum256(bytes.TrimSpace(publicKey))
+	offset := new(big.Int).Mod(new(big.Int).SetBytes(sum[:]), size)
+	n := new(big.Int).Add(new(big.Int).SetBytes(network), offset).Bytes()
+
+	// left pad to the length of the address
+	ip := make(net.IP, len(network))
+	copy(ip[len(ip)-len(n):], n)
+	return ip
+}
+
+// freeIP returns the address the local peer already has in the pool, if
+// nobody else took it, or the first address of the pool not used by other
+// peers from start, wrapping around, from the lowest one when start is nil.
+func freeIP(pool *net.IPNet, peers []Peer, publicKey []byte, start net.IP) (net.IP, error) {
+	taken := map[string]bool{}
+	var own net.IP
+	for _, p := range peers {
+		if p.IP == nil {
+			continue
+		}
+		if bytes.Equal(p.PublicKey, publicKey) {
+			own = *p.IP
+			continue
+		}
+		taken[p.IP.String()] = true
+	}
+	if own != nil && pool.Contains(own) && !taken[own.String()] && usableIP(pool, own) {
+		return own, nil
+	}
+
+	network := pool.IP.Mask(pool.Mask)
+	if start == nil || !pool.Contains(start) {
+		start = network
+	}
+	ip := start
+	for {
+		if usableIP(pool, ip) && !taken[ip.String()] {
+			return ip, nil
+		}
+		ip = nextIP(ip)
+		if !pool.Contains(ip) {
+			ip = network
+		}
+		if ip.Equal(start) {
+			return nil, fmt.Errorf(errPoolExhausted, pool.String())
+		}
+	}
+}
+
+// usableIP excludes the network address and, for IPv4, the broadcast address.
+func usableIP(pool *net.IPNet, ip net.IP) bool {
+	if ip.Equal(pool.IP.Mask(pool.Mask)) {
+		return false
+	}
+	if ip.To4() != nil && !pool.Contains(nextIP(ip)) {
+		return false
+	}
+	return true
+}
+
+// lostAddressRace checks if another peer with a lower public key has the same address.
+func lostAddressRace(peers []Peer, local Peer) bool {
+	for _, p := range peers {
+		if p.IP != nil && p.IP.Equal(*local.IP) && bytes.Compare(p.PublicKey, local.PublicKey) < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for j := len(next) - 1; j >= 0; j-- {
+		next[j]++
+		if next[j] != 0 {
+			break
+		}
+	}
+	return next
+}
@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPool(t *testing.T, cidr string) *net.IPNet {
+	_, pool, err := net.ParseCIDR(cidr)
+	assert.NoError(t, err)
+	return pool
+}
+
+func TestFreeIP(t *testing.T) {
+	pool := testPool(t, "10.0.0.0/30")
+	peers := []Peer{testPeer("other", "10.0.0.1", "192.168.1.2:2345")}
+
+	ip, err := freeIP(pool, peers, []byte("local"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", ip.String())
+
+	peers = append(peers, testPeer("another", "10.0.0.2", "192.168.1.3:2345"))
+	_, err = freeIP(pool, peers, []byte("local"), nil)
+	assert.EqualError(t, err, "no free address left in the pool 10.0.0.0/30")
+}
+
+func TestFreeIPReusesOwnAddress(t *testing.T) {
+	pool := testPool(t, "10.0.0.0/24")
+	peers := []Peer{testPeer("local", "10.0.0.7", "192.168.1.1:2345")}
+
+	ip, err := freeIP(pool, peers, []byte("local"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.7", ip.String())
+}
+
+// racingBackend hides the peers on the first read, like a concurrent join would
+type racingBackend struct {
+	*MemoryBackend
+	reads int
+}
+
+func (r *racingBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	r.reads++
+	if r.reads == 1 {
+		return []Peer{}, nil
+	}
+	return r.MemoryBackend.GetPeers(ctx, ifname)
+}
+
+// plainBackend only exposes the methods of Backend, hiding CompareAndJoin
+type plainBackend struct {
+	Backend
+}
+
+func TestAllocateIPResolvesCollisions(t *testing.T) {
+	b := &racingBackend{MemoryBackend: NewMemoryBackend()}
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("aaa", "10.0.0.1", "192.168.1.2:2345")))
+
+	i := &Interface{
+		Backend:   plainBackend{b},
+		Name:      "wg0",
+		Pool:      testPool(t, "10.0.0.0/24"),
+		LocalPeer: Peer{PublicKey: []byte("zzz"), Endpoint: "192.168.1.1:2345"},
+	}
+	assert.NoError(t, i.allocateIP(context.Background()))
+	assert.Equal(t, "10.0.0.2", i.LocalPeer.IP.String())
+
+	peers, err := b.MemoryBackend.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 2)
+}
+
+func TestAllocateIPCompareAndJoin(t *testing.T) {
+	b := &racingBackend{MemoryBackend: NewMemoryBackend()}
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("aaa", "10.0.0.1", "192.168.1.2:2345")))
+
+	i := &Interface{
+		Backend:   b,
+		Name:      "wg0",
+		Pool:      testPool(t, "10.0.0.0/24"),
+		LocalPeer: Peer{PublicKey: []byte("zzz"), Endpoint: "192.168.1.1:2345"},
+	}
+	assert.NoError(t, i.allocateIP(context.Background()))
+	assert.Equal(t, "10.0.0.2", i.LocalPeer.IP.String())
+
+	peers, err := b.MemoryBackend.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 2)
+}
+
+func TestHashedIP(t *testing.T) {
+	pool := testPool(t, "10.0.0.0/24")
+	ip := hashedIP(pool, []byte("local"))
+	assert.True(t, pool.Contains(ip))
+	assert.Equal(t, ip, hashedIP(pool, []byte("local\n")))
+	assert.NotEqual(t, ip, hashedIP(pool, []byte("other")))
+
+	pool6 := testPool(t, "fd00::/64")
+	assert.True(t, pool6.Contains(hashedIP(pool6, []byte("local"))))
+}
+
+func TestFreeIPFromStart(t *testing.T) {
+	pool := testPool(t, "10.0.0.0/29")
+	peers := []Peer{testPeer("other", "10.0.0.5", "192.168.1.2:2345")}
+
+	ip, err := freeIP(pool, peers, []byte("local"), net.ParseIP("10.0.0.4").To4())
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.4", ip.String())
+
+	// taken, the next free one
+	ip, err = freeIP(pool, peers, []byte("local"), net.ParseIP("10.0.0.5").To4())
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.6", ip.String())
+
+	// wraps around past the broadcast address
+	peers = append(peers, testPeer("another", "10.0.0.6", "192.168.1.3:2345"))
+	ip, err = freeIP(pool, peers, []byte("local"), net.ParseIP("10.0.0.5").To4())
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", ip.String())
+}
+
+func TestAllocateIPHashed(t *testing.T) {
+	pool := testPool(t, "10.0.0.0/24")
+	i := &Interface{
+		Backend:    NewMemoryBackend(),
+		Name:       "wg0",
+		Pool:       pool,
+		Allocation: AllocateHashed,
+		LocalPeer:  Peer{PublicKey: []byte("local"), Endpoint: "192.168.1.1:2345"},
+	}
+	// the key hashes to a usable address of the pool
+	assert.Equal(t, "10.0.0.246", hashedIP(pool, []byte("local")).String())
+	assert.NoError(t, i.allocateIP(context.Background()))
+	assert.Equal(t, "10.0.0.246", i.LocalPeer.IP.String())
+}
+
+func TestValidateAllocation(t *testing.T) {
+	assert.NoError(t, validateAllocation(""))
+	assert.NoError(t, validateAllocation(AllocateHashed))
+	assert.EqualError(t, validateAllocation("random"), `the allocation strategy must be lowest or hashed, got: "random"`)
+}
@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+)
+
+const errAuthorizedKeyNotValid = "line %d of %s is not a wireguard public key: %q"
+
+// LoadAuthorizedKeys reads the public keys of the peers allowed on the
+// link from path, one base64 key per line, like wg pubkey prints them.
+// The empty lines and the ones starting with # are ignored.
+func LoadAuthorizedKeys(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := [][]byte{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if key, err := base64.StdEncoding.DecodeString(string(line)); err != nil || len(key) != 32 {
+			return nil, fmt.Errorf(errAuthorizedKeyNotValid, n, path, line)
+		}
+		keys = append(keys, append([]byte{}, line...))
+	}
+	return keys, scanner.Err()
+}
+
+// authorizedPeers drops the peers whose public key is not in the
+// AuthorizedKeys, when set. The local peer is always kept.
+func (i *Interface) authorizedPeers(peers []Peer) []Peer {
+	if len(i.AuthorizedKeys) == 0 {
+		return peers
+	}
+	authorized := map[string]bool{string(bytes.TrimSpace(i.LocalPeer.PublicKey)): true}
+	for _, k := range i.AuthorizedKeys {
+		authorized[string(bytes.TrimSpace(k))] = true
+	}
+
+	kept := []Peer{}
+	unauthorized := map[string]bool{}
+	for _, p := range peers {
+		key := string(bytes.TrimSpace(p.PublicKey))
+		if authorized[key] {
+			kept = append(kept, p)
+			continue
+		}
+		unauthorized[key] = true
+		// log once, not on every read of the peers
+		if !i.unauthorized[key] {
+			i.logger().Errorf("Ignoring the peer %s at %s, its public key is not authorized", peerName(p), p.Endpoint)
+		}
+	}
+	i.unauthorized = unauthorized
+	return kept
+}
@@ -0,0 +1,69 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	authorizedKey1 = "3JvhOiDxSXzqvUXq0/1lSK0dVb1k8kt08CEC2QXcjF8="
+	authorizedKey2 = "lW5gh/4OI5WsN8eMZ9WbM8xKFpjvHWxZqAFbyTcNmXc="
+)
+
+func TestLoadAuthorizedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wirey")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "authorized")
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("# gateways\n"+authorizedKey1+"\n\n  "+authorizedKey2+"  \n"), 0644))
+	keys, err := LoadAuthorizedKeys(path)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte(authorizedKey1), []byte(authorizedKey2)}, keys)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(authorizedKey1+"\nnotakey\n"), 0644))
+	_, err = LoadAuthorizedKeys(path)
+	assert.EqualError(t, err, `line 2 of `+path+` is not a wireguard public key: "notakey"`)
+
+	_, err = LoadAuthorizedKeys(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestAuthorizedPeers(t *testing.T) {
+	local := testPeer("local", "10.0.0.1", "192.168.1.1:2345")
+	authorized := testPeer(authorizedKey1+"\n", "10.0.0.2", "192.168.1.2:2345")
+	other := testPeer(authorizedKey2, "10.0.0.3", "192.168.1.3:2345")
+	peers := []Peer{local, authorized, other}
+
+	i := &Interface{LocalPeer: local}
+	assert.Equal(t, peers, i.authorizedPeers(peers))
+
+	i.AuthorizedKeys = [][]byte{[]byte(authorizedKey1)}
+	assert.Equal(t, []Peer{local, authorized}, i.authorizedPeers(peers))
+	assert.True(t, i.unauthorized[authorizedKey2])
+}
+
+func TestApplyAuthorizedKeys(t *testing.T) {
+	links := newFakeLinkManager()
+	local := testPeer("local", "10.0.0.1", "192.168.1.1:2345")
+	authorized := testPeer(authorizedKey1, "10.0.0.2", "192.168.1.2:2345")
+	other := testPeer(authorizedKey2, "10.0.0.3", "192.168.1.3:2345")
+	i := &Interface{
+		Name:           "wg0",
+		PrefixLen:      24,
+		LocalPeer:      local,
+		LinkManager:    links,
+		AuthorizedKeys: [][]byte{[]byte(authorizedKey1)},
+	}
+	addr, err := i.localAddr()
+	assert.NoError(t, err)
+
+	assert.NoError(t, i.apply(addr, []Peer{local, authorized, other}, 2345))
+	assert.Equal(t, extractPeersSHA([]Peer{local, authorized}), i.appliedSHA)
+	assert.Len(t, links.confs["wg0"].Peers, 1)
+	assert.Equal(t, authorizedKey1, links.confs["wg0"].Peers[0].PublicKey)
+}
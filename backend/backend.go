@@ -1,6 +1,292 @@
 package backend
 
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backend stores the peers of every interface.
+//
+// Every call takes a context, the backends give up and return its error
+// once it is done, e.g: when Connect is stopped while the store hangs.
+// Join is an upsert on the public key of the peer: joining again replaces
+// the stored peer, so that a restarted node updates its entry without
+// first leaving and the other peers never see it missing or twice.
+// Leave removes the peer with the public key, leaving with a peer that
+// is not stored is not an error.
 type Backend interface {
-	Join(ifname string, peer Peer) error
-	GetPeers(ifname string) ([]Peer, error)
+	Join(ctx context.Context, ifname string, peer Peer) error
+	Leave(ctx context.Context, ifname string, peer Peer) error
+	GetPeers(ctx context.Context, ifname string) ([]Peer, error)
+}
+
+// Watcher is implemented by the backends that can push peer changes.
+// The full list of peers of the interface is sent on the returned channel
+// every time it changes, the channel is closed when the context is done or
+// when the watch fails.
+type Watcher interface {
+	Watch(ctx context.Context, ifname string) (<-chan []Peer, error)
+}
+
+// Pinger is implemented by the backends with a cheap check that the store
+// answers, Ping fails when it cannot be reached before the context is done.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Expirer is implemented by the backends whose entries expire unless the
+// process that joined keeps refreshing them, e.g: with a lease or a ttl.
+type Expirer interface {
+	// EntryTTL is how long the entry of a peer outlives its process
+	EntryTTL() time.Duration
+}
+
+// entryTTL returns the EntryTTL of the backend, 0 when its entries stay
+// until the peers leave.
+func entryTTL(b Backend) time.Duration {
+	if e, ok := b.(Expirer); ok {
+		return e.EntryTTL()
+	}
+	return 0
+}
+
+// closeBackend closes the backend when it is an io.Closer, e.g: to stop
+// the keepalives of the entries and the connections of its client.
+func closeBackend(b Backend) error {
+	if c, ok := b.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// pingTimeout bounds the checks of the backend made with ping.
+const pingTimeout = 10 * time.Second
+
+// pingIfname is the interface whose peers are read to check the backends
+// that are not Pingers, no peer is ever stored there.
+const pingIfname = "wirey-ping"
+
+// ping uses the backend Ping when available, falling back to reading the
+// peers of pingIfname.
+func ping(ctx context.Context, b Backend) error {
+	if p, ok := b.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	// not every backend can stop its calls with the context
+	return callWithContext(ctx, func() error {
+		_, err := b.GetPeers(ctx, pingIfname)
+		return err
+	})
+}
+
+// callWithContext returns the error of call, or the one of the context
+// when it is done first, leaving call to finish in the background: for
+// the calls that cannot be cancelled.
+func callWithContext(ctx context.Context, call func() error) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- call()
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errc:
+		return err
+	}
+}
+
+// Lister is implemented by the backends able to enumerate the interfaces
+// they store peers for, e.g: to manage several meshes sharing a store.
+type Lister interface {
+	ListInterfaces(ctx context.Context) ([]string, error)
+}
+
+// ListInterfaces returns the sorted names of the interfaces with peers in
+// the backend, it fails for the backends that are not Listers.
+func ListInterfaces(ctx context.Context, b Backend) ([]string, error) {
+	l, ok := b.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("the %T backend cannot list the interfaces", b)
+	}
+	names, err := l.ListInterfaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueNames(names), nil
+}
+
+// uniqueNames sorts the names without the duplicates and the empty ones.
+func uniqueNames(names []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, n := range names {
+		if len(n) > 0 && !seen[n] {
+			seen[n] = true
+			unique = append(unique, n)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// interfaceFromKey returns the interface of a key made of the prefix, the
+// interface name and the rest, e.g: /wirey/wg0/<publickey>.
+func interfaceFromKey(key, prefix string) string {
+	return strings.SplitN(strings.TrimPrefix(key, prefix), "/", 2)[0]
+}
+
+// CompareAndJoiner is implemented by the backends able to join atomically,
+// CompareAndJoin fails with an AddressTakenError, without joining, when
+// the address of the peer is already claimed by another public key.
+type CompareAndJoiner interface {
+	CompareAndJoin(ctx context.Context, ifname string, peer Peer) error
+}
+
+// AddressTakenError is returned by CompareAndJoin, and by Connect, when
+// the address of the peer belongs to another peer.
+type AddressTakenError struct {
+	IP string
+	// Fingerprint identifies the public key of the other peer, empty
+	// when the backend does not tell
+	Fingerprint string
+}
+
+func (e AddressTakenError) Error() string {
+	peer := e.Fingerprint
+	if len(peer) == 0 {
+		peer = "unknown"
+	}
+	return fmt.Sprintf("%s: %s by the peer %s", ErrAddressTaken, e.IP, peer)
+}
+
+// Is makes errors.Is match the error with ErrAddressTaken.
+func (e AddressTakenError) Is(target error) bool {
+	return target == ErrAddressTaken
+}
+
+// addressClaimed tells if the address of p belongs to another peer.
+func addressClaimed(peers []Peer, p Peer) bool {
+	if p.IP == nil {
+		return false
+	}
+	for _, other := range peers {
+		if other.IP != nil && other.IP.Equal(*p.IP) && !bytes.Equal(other.PublicKey, p.PublicKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// join uses the backend CompareAndJoin when available, falling back to Join.
+func join(ctx context.Context, b Backend, ifname string, p Peer) error {
+	if c, ok := b.(CompareAndJoiner); ok {
+		return c.CompareAndJoin(ctx, ifname, p)
+	}
+	return b.Join(ctx, ifname, p)
+}
+
+// PollingWatch watches the peers of the interface by calling GetPeers every ttl,
+// it can be used by backends that are not able to push changes.
+// The channel is closed when the context is done or when GetPeers fails.
+func PollingWatch(ctx context.Context, b Backend, ifname string, ttl time.Duration) <-chan []Peer {
+	return pollingWatch(ctx, b, ifname, newPollSchedule(ttl, ttl), StdLogger{})
+}
+
+// pollingWatch calls GetPeers as spaced by the schedule, the error of
+// GetPeers is logged with logger.
+func pollingWatch(ctx context.Context, b Backend, ifname string, schedule *pollSchedule, logger Logger) <-chan []Peer {
+	peersc := make(chan []Peer)
+	go func() {
+		defer close(peersc)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			peers, err := b.GetPeers(ctx, ifname)
+			if err != nil {
+				// not a problem when the read was stopped with the watch
+				if ctx.Err() == nil {
+					logger.Errorf("problem during extraction of peers from the backend: %s", err.Error())
+				}
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case peersc <- peers:
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(schedule.next(peers)):
+			}
+		}
+	}()
+	return peersc
+}
+
+// watch uses the backend Watch when available, falling back to polling.
+// The watched peers are also read every ttl, in case a change is missed.
+// While the peers do not change the reads slow down up to maxTTL.
+func watch(ctx context.Context, b Backend, ifname string, ttl, maxTTL time.Duration, logger Logger) (<-chan []Peer, error) {
+	schedule := newPollSchedule(ttl, maxTTL)
+	w, ok := b.(Watcher)
+	if !ok {
+		return pollingWatch(ctx, b, ifname, schedule, logger), nil
+	}
+	watched, err := w.Watch(ctx, ifname)
+	if err != nil {
+		return nil, err
+	}
+	return resync(ctx, b, ifname, schedule, watched, logger), nil
+}
+
+// resync forwards the watched peers and sends the ones returned by
+// GetPeers as spaced by the schedule, the channel is closed with the
+// watched one or when GetPeers fails.
+func resync(ctx context.Context, b Backend, ifname string, schedule *pollSchedule, watched <-chan []Peer, logger Logger) <-chan []Peer {
+	peersc := make(chan []Peer)
+	go func() {
+		defer close(peersc)
+		next := time.After(jitter(schedule.ttl))
+		for {
+			var peers []Peer
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case peers, ok = <-watched:
+				if !ok {
+					return
+				}
+			case <-next:
+				var err error
+				peers, err = b.GetPeers(ctx, ifname)
+				if err != nil {
+					if ctx.Err() == nil {
+						logger.Errorf("problem during extraction of peers from the backend: %s", err.Error())
+					}
+					return
+				}
+			}
+			next = time.After(schedule.next(peers))
+
+			select {
+			case <-ctx.Done():
+				return
+			case peersc <- peers:
+			}
+		}
+	}()
+	return peersc
 }
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResync(t *testing.T) {
+	b := NewMemoryBackend()
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a watch that missed the join
+	watched := make(chan []Peer)
+	peersc := resync(ctx, b, "wg0", newPollSchedule(10*time.Millisecond, 10*time.Millisecond), watched, StdLogger{})
+
+	select {
+	case peers := <-peersc:
+		assert.Len(t, peers, 1)
+	case <-time.After(time.Second):
+		t.Fatal("the peers were not read again")
+	}
+
+	close(watched)
+	for range peersc {
+	}
+}
+
+// hangingBackend blocks the reads of the peers until released.
+type hangingBackend struct {
+	failingBackend
+	release chan struct{}
+}
+
+func (b hangingBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	<-b.release
+	return nil, nil
+}
+
+func TestPingFallsBackToGetPeers(t *testing.T) {
+	assert.NoError(t, ping(context.Background(), NewMemoryBackend()))
+	assert.EqualError(t, ping(context.Background(), failingBackend{}), "unreachable")
+
+	b := hangingBackend{release: make(chan struct{})}
+	defer close(b.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, ping(ctx, b))
+}
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"encoding/json"
+)
+
+// MarshalPeer encodes the peer as all the backends store it: a json object
+// with the fields of Peer, the keys and the signature in base64, the IP as
+// a string or null and the empty optional fields left out. Backends reuse
+// it, so that the peers can be moved between stores and merged by a
+// MultiBackend.
+func MarshalPeer(p Peer) ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// UnmarshalPeer decodes a peer encoded by MarshalPeer. An empty IP is
+// decoded as nil, like a null one.
+func UnmarshalPeer(data []byte) (Peer, error) {
+	p := Peer{}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Peer{}, err
+	}
+	return normalizePeer(p), nil
+}
+
+// MarshalPeers encodes the peers as a json array of MarshalPeer objects.
+func MarshalPeers(peers []Peer) ([]byte, error) {
+	if peers == nil {
+		peers = []Peer{}
+	}
+	return json.Marshal(peers)
+}
+
+// UnmarshalPeers decodes a json array of peers encoded by MarshalPeers.
+func UnmarshalPeers(data []byte) ([]Peer, error) {
+	peers := []Peer{}
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, err
+	}
+	for n := range peers {
+		peers[n] = normalizePeer(peers[n])
+	}
+	return peers, nil
+}
+
+// normalizePeer guards against an IP decoded from "", that net.IP leaves
+// empty instead of nil.
+func normalizePeer(p Peer) Peer {
+	if p.IP != nil && len(*p.IP) == 0 {
+		p.IP = nil
+	}
+	return p
+}
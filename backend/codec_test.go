@@ -0,0 +1,58 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalPeerRoundTrip(t *testing.T) {
+	seen := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := testPeer("key1", "10.0.0.1", "192.168.1.1:2345")
+	p.AllowedIPs = []string{"192.168.2.0/24"}
+	p.Hostname = "node1"
+	p.LastSeen = &seen
+	p.Labels = map[string]string{"region": "eu"}
+
+	data, err := MarshalPeer(p)
+	assert.NoError(t, err)
+	decoded, err := UnmarshalPeer(data)
+	assert.NoError(t, err)
+	assert.Equal(t, p.PublicKey, decoded.PublicKey)
+	assert.True(t, p.IP.Equal(*decoded.IP))
+	assert.Equal(t, p.AllowedIPs, decoded.AllowedIPs)
+	assert.Equal(t, p.Hostname, decoded.Hostname)
+	assert.True(t, p.LastSeen.Equal(*decoded.LastSeen))
+	assert.Equal(t, p.Labels, decoded.Labels)
+}
+
+func TestUnmarshalPeerWithoutIP(t *testing.T) {
+	data, err := MarshalPeer(Peer{PublicKey: []byte("key1")})
+	assert.NoError(t, err)
+	decoded, err := UnmarshalPeer(data)
+	assert.NoError(t, err)
+	assert.Nil(t, decoded.IP)
+
+	decoded, err = UnmarshalPeer([]byte(`{"PublicKey":"a2V5MQ==","IP":""}`))
+	assert.NoError(t, err)
+	assert.Nil(t, decoded.IP)
+	assert.Equal(t, []byte("key1"), decoded.PublicKey)
+}
+
+func TestMarshalPeers(t *testing.T) {
+	data, err := MarshalPeers(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]", string(data))
+
+	peers := []Peer{testPeer("key1", "10.0.0.1", "192.168.1.1:2345"), testPeer("key2", "10.0.0.2", "192.168.1.2:2345")}
+	data, err = MarshalPeers(peers)
+	assert.NoError(t, err)
+	decoded, err := UnmarshalPeers(data)
+	assert.NoError(t, err)
+	assert.Len(t, decoded, 2)
+	assert.Equal(t, []byte("key2"), decoded[1].PublicKey)
+
+	_, err = UnmarshalPeers([]byte("{"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,576 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/influxdata/wirey/pkg/wireguard"
+	toml "github.com/pelletier/go-toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	errConfigField  = "invalid %s in the config: %w"
+	errConfigFormat = "the config file must be yaml or toml, got: %s"
+	errNoBackend    = "no storage backend selected, available backends: [etcd, http, redis, kubernetes, dns, consul, file]"
+)
+
+// Config holds the parameters of an Interface and of its backend,
+// the keys of the yaml and toml files are the same of the command line flags.
+// PrivateKey, when set, is used instead of the key at PrivateKeyPath.
+// StrictKeys refuses the key files accessible by other users.
+type Config struct {
+	Ifname              string   `yaml:"ifname" toml:"ifname"`
+	Endpoint            string   `yaml:"endpoint" toml:"endpoint"`
+	Endpoints           []string `yaml:"endpoints" toml:"endpoints"`
+	Hostname            string   `yaml:"hostname" toml:"hostname"`
+	IPAddr              string   `yaml:"ipaddr" toml:"ipaddr"`
+	Allocation          string   `yaml:"allocation" toml:"allocation"`
+	ManagementAddr      string   `yaml:"managementaddr" toml:"managementaddr"`
+	PrivateKeyPath      string   `yaml:"privatekeypath" toml:"privatekeypath"`
+	PrivateKey          string   `yaml:"privatekey" toml:"privatekey"`
+	PresharedKeyPath    string   `yaml:"presharedkeypath" toml:"presharedkeypath"`
+	SigningKeyPath      string   `yaml:"signingkeypath" toml:"signingkeypath"`
+	StrictKeys          bool     `yaml:"strictkeys" toml:"strictkeys"`
+	TrustedSigners      []string `yaml:"trustedsigners" toml:"trustedsigners"`
+	AuthorizedKeys      string   `yaml:"authorizedkeys" toml:"authorizedkeys"`
+	PeerDiscoveryTTL    string   `yaml:"peerdiscoveryttl" toml:"peerdiscoveryttl"`
+	PeerDiscoveryMaxTTL string   `yaml:"peerdiscoverymaxttl" toml:"peerdiscoverymaxttl"`
+	PeerStaleness       string   `yaml:"peerstaleness" toml:"peerstaleness"`
+	PeerDebounce        string   `yaml:"peerdebounce" toml:"peerdebounce"`
+	ConvergenceCycles   int      `yaml:"convergencecycles" toml:"convergencecycles"`
+	PrefixLen           int      `yaml:"prefixlen" toml:"prefixlen"`
+	PeerPrefixLen       int      `yaml:"peerprefixlen" toml:"peerprefixlen"`
+	MTU                 int      `yaml:"mtu" toml:"mtu"`
+	ListenPort          int      `yaml:"listenport" toml:"listenport"`
+	FwMark              int      `yaml:"fwmark" toml:"fwmark"`
+	LoadModule          bool     `yaml:"loadmodule" toml:"loadmodule"`
+	RouteTable          int      `yaml:"routetable" toml:"routetable"`
+	TunnelDNS           []string `yaml:"tunneldns" toml:"tunneldns"`
+	Netns               string   `yaml:"netns" toml:"netns"`
+	PersistentKeepalive int      `yaml:"persistentkeepalive" toml:"persistentkeepalive"`
+	ProbeEndpoints      bool     `yaml:"probeendpoints" toml:"probeendpoints"`
+	AllowedIPs          []string `yaml:"allowedips" toml:"allowedips"`
+	AllowedIPsPolicy    string   `yaml:"allowedipspolicy" toml:"allowedipspolicy"`
+	ExcludedIPs         []string `yaml:"excludedips" toml:"excludedips"`
+	Gateway             bool     `yaml:"gateway" toml:"gateway"`
+	Labels              []string `yaml:"labels" toml:"labels"`
+	PeerSelector        string   `yaml:"peerselector" toml:"peerselector"`
+	MaxRetries          int      `yaml:"maxretries" toml:"maxretries"`
+	RetryBackoff        string   `yaml:"retrybackoff" toml:"retrybackoff"`
+	Debug               bool     `yaml:"debug" toml:"debug"`
+	LogFormat           string   `yaml:"logformat" toml:"logformat"`
+	Observer            bool     `yaml:"observer" toml:"observer"`
+
+	Backend BackendConfig `yaml:"backend" toml:"backend"`
+}
+
+// BackendConfig selects the backends, when more than one is configured
+// the peers are stored in all of them with a MultiBackend and
+// WritePolicy, "all" or "any", tells which writes must succeed.
+type BackendConfig struct {
+	Etcd             []string `yaml:"etcd" toml:"etcd"`
+	EtcdLeaseTTL     string   `yaml:"etcdleasettl" toml:"etcdleasettl"`
+	EtcdUsername     string   `yaml:"etcdusername" toml:"etcdusername"`
+	EtcdPassword     string   `yaml:"etcdpassword" toml:"etcdpassword"`
+	EtcdCA           string   `yaml:"etcdca" toml:"etcdca"`
+	EtcdCert         string   `yaml:"etcdcert" toml:"etcdcert"`
+	EtcdKey          string   `yaml:"etcdkey" toml:"etcdkey"`
+	HTTP             string   `yaml:"http" toml:"http"`
+	HTTPBasicAuth    string   `yaml:"httpbasicauth" toml:"httpbasicauth"`
+	HTTPBearerToken  string   `yaml:"httpbearertoken" toml:"httpbearertoken"`
+	Redis            string   `yaml:"redis" toml:"redis"`
+	RedisPassword    string   `yaml:"redispassword" toml:"redispassword"`
+	RedisTTL         string   `yaml:"redisttl" toml:"redisttl"`
+	Kubernetes       string   `yaml:"kubernetes" toml:"kubernetes"`
+	Kubeconfig       string   `yaml:"kubeconfig" toml:"kubeconfig"`
+	DNS              string   `yaml:"dns" toml:"dns"`
+	DNSServer        string   `yaml:"dnsserver" toml:"dnsserver"`
+	DNSTSIGName      string   `yaml:"dnstsigname" toml:"dnstsigname"`
+	DNSTSIGSecret    string   `yaml:"dnstsigsecret" toml:"dnstsigsecret"`
+	Consul           string   `yaml:"consul" toml:"consul"`
+	ConsulDatacenter string   `yaml:"consuldatacenter" toml:"consuldatacenter"`
+	ConsulToken      string   `yaml:"consultoken" toml:"consultoken"`
+	ConsulTTL        string   `yaml:"consulttl" toml:"consulttl"`
+	ConsulCA         string   `yaml:"consulca" toml:"consulca"`
+	ConsulCert       string   `yaml:"consulcert" toml:"consulcert"`
+	ConsulKey        string   `yaml:"consulkey" toml:"consulkey"`
+	File             string   `yaml:"file" toml:"file"`
+	WritePolicy      string   `yaml:"writepolicy" toml:"writepolicy"`
+}
+
+// DefaultConfig returns the config with the defaults of the command line flags.
+func DefaultConfig() *Config {
+	return &Config{
+		Ifname:              "wg0",
+		PrivateKeyPath:      "/etc/wirey/privkey",
+		PeerDiscoveryTTL:    "30s",
+		PeerDiscoveryMaxTTL: "0s",
+		PeerStaleness:       "0s",
+		PeerDebounce:        "0s",
+		ConvergenceCycles:   defaultConvergenceCycles,
+		MTU:                 defaultMTU,
+		MaxRetries:          maxretries,
+		RetryBackoff:        retryttl.String(),
+		Backend: BackendConfig{
+			EtcdLeaseTTL: "30s",
+			RedisTTL:     "30s",
+			ConsulTTL:    "30s",
+		},
+	}
+}
+
+// LoadConfig reads and validates the yaml or toml config at path,
+// the format is chosen by the file extension.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := DefaultConfig()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, c)
+	case ".toml":
+		err = toml.NewDecoder(bytes.NewReader(data)).Strict(true).Decode(c)
+	default:
+		return nil, fmt.Errorf(errConfigFormat, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks every field of the config,
+// the errors name the field that is not valid.
+func (c *Config) Validate() error {
+	if len(c.Ifname) == 0 || len(c.Ifname) > ifnamesiz {
+		return fmt.Errorf(errConfigField, "ifname", fmt.Errorf(errInterfaceNameLength, ifnamesiz))
+	}
+
+	if err := c.validateDurations(); err != nil {
+		return err
+	}
+	if len(c.Backend.HTTPBasicAuth) > 0 && len(strings.Split(c.Backend.HTTPBasicAuth, ":")) != 2 {
+		return fmt.Errorf(errConfigField, "httpbasicauth", errors.New("the credentials are not in format username:password"))
+	}
+
+	if err := validateWritePolicy(c.Backend.WritePolicy); err != nil {
+		return fmt.Errorf(errConfigField, "writepolicy", err)
+	}
+
+	if err := validateLogFormat(c.LogFormat); err != nil {
+		return fmt.Errorf(errConfigField, "logformat", err)
+	}
+
+	if _, err := c.trustedSigners(); err != nil {
+		return fmt.Errorf(errConfigField, "trustedsigners", err)
+	}
+	if _, err := c.authorizedKeys(); err != nil {
+		return fmt.Errorf(errConfigField, "authorizedkeys", err)
+	}
+
+	// an observer does not join, it has no endpoint, address or key
+	if c.Observer {
+		return nil
+	}
+
+	if err := validateEndpoint(c.Endpoint); err != nil {
+		return fmt.Errorf(errConfigField, "endpoint", err)
+	}
+	for _, e := range c.Endpoints {
+		if err := validateEndpoint(e); err != nil {
+			return fmt.Errorf(errConfigField, "endpoints", err)
+		}
+	}
+
+	pool := strings.Contains(c.IPAddr, "/")
+	if pool {
+		if _, _, err := net.ParseCIDR(c.IPAddr); err != nil {
+			return fmt.Errorf(errConfigField, "ipaddr", err)
+		}
+	} else if net.ParseIP(c.IPAddr) == nil {
+		return fmt.Errorf(errConfigField, "ipaddr", fmt.Errorf(errIPNotValid, ErrInvalidIP, c.IPAddr))
+	}
+
+	if !pool {
+		// 0 is the default prefix length of the ip family
+		if c.PrefixLen < 0 || c.PrefixLen > hostBits(net.ParseIP(c.IPAddr)) {
+			return fmt.Errorf(errConfigField, "prefixlen", fmt.Errorf(errPrefixLenNotValid, c.IPAddr, c.PrefixLen))
+		}
+	}
+
+	// the family of the pool is the one of the allocated address
+	ip := net.ParseIP(strings.Split(c.IPAddr, "/")[0])
+	if err := validatePeerPrefixLen(Peer{IP: &ip, PrefixLen: c.PeerPrefixLen}); err != nil {
+		return fmt.Errorf(errConfigField, "peerprefixlen", err)
+	}
+
+	if err := validateManagementAddr(c.ManagementAddr); err != nil {
+		return fmt.Errorf(errConfigField, "managementaddr", err)
+	}
+
+	if err := validateAllocation(AllocationStrategy(c.Allocation)); err != nil {
+		return fmt.Errorf(errConfigField, "allocation", err)
+	}
+
+	// the custom policy needs a function, only library users can set it
+	policy := AllowedIPsPolicy(c.AllowedIPsPolicy)
+	if err := validateAllowedIPsPolicy(policy); err != nil {
+		return fmt.Errorf(errConfigField, "allowedipspolicy", err)
+	}
+	if policy == AllowedIPsCustom {
+		return fmt.Errorf(errConfigField, "allowedipspolicy", errors.New(errNoAllowedIPsFunc))
+	}
+
+	if len(c.PrivateKey) > 0 {
+		if _, err := wireguard.Pubkey(c.PrivateKey); err != nil {
+			return fmt.Errorf(errConfigField, "privatekey", err)
+		}
+	}
+
+	if c.MTU < minMTU {
+		return fmt.Errorf(errConfigField, "mtu", fmt.Errorf(errMTUNotValid, minMTU, c.MTU))
+	}
+	if err := validateRouteTable(c.RouteTable); err != nil {
+		return fmt.Errorf(errConfigField, "routetable", err)
+	}
+	if c.MaxRetries < 0 {
+		return fmt.Errorf(errConfigField, "maxretries", fmt.Errorf(errMaxRetriesNotValid, c.MaxRetries))
+	}
+	if c.ListenPort < 0 || c.ListenPort > 65535 {
+		return fmt.Errorf(errConfigField, "listenport", fmt.Errorf(errListenPortNotValid, c.ListenPort))
+	}
+	if err := validateAllowedIPs(c.AllowedIPs); err != nil {
+		return fmt.Errorf(errConfigField, "allowedips", err)
+	}
+	if err := validateAllowedIPs(c.ExcludedIPs); err != nil {
+		return fmt.Errorf(errConfigField, "excludedips", err)
+	}
+	if err := validateDNS(c.TunnelDNS); err != nil {
+		return fmt.Errorf(errConfigField, "tunneldns", err)
+	}
+	if _, err := ParseLabels(c.Labels); err != nil {
+		return fmt.Errorf(errConfigField, "labels", err)
+	}
+	if _, err := ParsePeerSelector(c.PeerSelector); err != nil {
+		return fmt.Errorf(errConfigField, "peerselector", err)
+	}
+	return nil
+}
+
+func (c *Config) validateDurations() error {
+	if d, err := time.ParseDuration(c.PeerDiscoveryTTL); err == nil && d <= 0 {
+		return fmt.Errorf(errConfigField, "peerdiscoveryttl", fmt.Errorf(errPeerCheckTTLNotValid, d))
+	}
+
+	durations := map[string]string{
+		"peerdiscoveryttl":    c.PeerDiscoveryTTL,
+		"peerdiscoverymaxttl": c.PeerDiscoveryMaxTTL,
+		"peerstaleness":       c.PeerStaleness,
+		"peerdebounce":        c.PeerDebounce,
+		"retrybackoff":        c.RetryBackoff,
+		"etcdleasettl":        c.Backend.EtcdLeaseTTL,
+		"redisttl":            c.Backend.RedisTTL,
+		"consulttl":           c.Backend.ConsulTTL,
+	}
+	for field, d := range durations {
+		if _, err := time.ParseDuration(d); err != nil {
+			return fmt.Errorf(errConfigField, field, err)
+		}
+	}
+	return nil
+}
+
+// NewBackend creates the configured backend, the version is sent by the http backend.
+func (c *BackendConfig) NewBackend(version string) (Backend, error) {
+	return c.newBackend(version, StdLogger{})
+}
+
+// NewBackend creates the backend of the config, logging in the LogFormat.
+func (c *Config) NewBackend(version string) (Backend, error) {
+	return c.Backend.newBackend(version, c.logger(c.Ifname))
+}
+
+// newBackend is NewBackend with the backends logging with logger.
+func (c *BackendConfig) newBackend(version string, logger Logger) (Backend, error) {
+	backends := []Backend{}
+
+	if len(c.Etcd) > 0 {
+		ttl, _ := time.ParseDuration(c.EtcdLeaseTTL)
+		tlsConfig := TLSConfig{CAFile: c.EtcdCA, CertFile: c.EtcdCert, KeyFile: c.EtcdKey}
+		b, err := NewEtcdBackendWithAuth(c.Etcd, c.EtcdUsername, c.EtcdPassword, tlsConfig, ttl)
+		if err != nil {
+			return nil, err
+		}
+		b.Logger = logger
+		backends = append(backends, b)
+	}
+
+	if len(c.HTTP) != 0 {
+		b, err := NewHTTPBackend(c.HTTP, version)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.HTTPBasicAuth) > 0 {
+			splitted := strings.Split(c.HTTPBasicAuth, ":")
+			b.BasicAuth = &BasicAuth{
+				Username: splitted[0],
+				Password: splitted[1],
+			}
+		}
+		b.BearerToken = c.HTTPBearerToken
+		backends = append(backends, b)
+	}
+
+	if len(c.Redis) != 0 {
+		ttl, _ := time.ParseDuration(c.RedisTTL)
+		b, err := NewRedisBackend(c.Redis, c.RedisPassword, ttl)
+		if err != nil {
+			return nil, err
+		}
+		b.Logger = logger
+		backends = append(backends, b)
+	}
+
+	if len(c.Kubernetes) != 0 {
+		b, err := NewKubernetesBackend(c.Kubeconfig, c.Kubernetes)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, b)
+	}
+
+	if len(c.DNS) != 0 {
+		b, err := NewDNSBackend(c.DNS, c.DNSServer)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.DNSTSIGName) != 0 {
+			b.TSIG = &TSIG{
+				Name:   c.DNSTSIGName,
+				Secret: c.DNSTSIGSecret,
+			}
+		}
+		backends = append(backends, b)
+	}
+
+	if len(c.Consul) != 0 {
+		ttl, _ := time.ParseDuration(c.ConsulTTL)
+		tlsConfig := TLSConfig{CAFile: c.ConsulCA, CertFile: c.ConsulCert, KeyFile: c.ConsulKey}
+		b, err := NewConsulBackendWithTLS(c.Consul, c.ConsulDatacenter, c.ConsulToken, tlsConfig, ttl)
+		if err != nil {
+			return nil, err
+		}
+		b.Logger = logger
+		backends = append(backends, b)
+	}
+
+	if len(c.File) != 0 {
+		b, err := NewFileBackend(c.File)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, b)
+	}
+
+	switch len(backends) {
+	case 0:
+		return nil, fmt.Errorf(errNoBackend)
+	case 1:
+		return backends[0], nil
+	}
+	m := NewMultiBackend(backends...)
+	m.Logger = logger
+	if len(c.WritePolicy) > 0 {
+		m.WritePolicy = c.WritePolicy
+	}
+	return m, nil
+}
+
+// NewInterfaceFromConfig creates the Interface on the backend
+// with all the parameters of the validated config.
+func NewInterfaceFromConfig(b Backend, c *Config) (*Interface, error) {
+	return newInterfaceFromConfig(b, c, loadOrGenerateKey)
+}
+
+// keyLoader reads the key file at path, see loadOrGenerateKey.
+type keyLoader func(path string, generate func() (string, error), strict bool, logger Logger) ([]byte, error)
+
+// newInterfaceFromConfig is NewInterfaceFromConfig with the key files
+// read by loadKey.
+func newInterfaceFromConfig(b Backend, c *Config, loadKey keyLoader) (*Interface, error) {
+	if c.Observer {
+		i := &Interface{
+			Backend:  b,
+			Name:     c.Ifname,
+			Observer: true,
+		}
+		i.applyConfig(c)
+		return i, nil
+	}
+
+	var err error
+	strict := c.StrictKeys
+	logger := c.logger(c.Ifname)
+	privateKey := []byte(c.PrivateKey)
+	if len(privateKey) == 0 {
+		privateKey, err = loadKey(c.PrivateKeyPath, wireguard.Genkey, strict, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var psk []byte
+	if len(c.PresharedKeyPath) > 0 {
+		psk, err = loadKey(c.PresharedKeyPath, wireguard.Genpsk, strict, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+	i, err := NewInterfaceWithKey(b, c.Ifname, c.Endpoint, c.IPAddr, privateKey, psk, defaultPeerCheckTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	i.applyConfig(c)
+	if len(c.Netns) > 0 {
+		i.LinkManager, err = NewNetnsLinkManager(c.Netns)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(c.SigningKeyPath) > 0 {
+		key, err := loadKey(c.SigningKeyPath, GenerateSigningKey, strict, logger)
+		if err != nil {
+			return nil, err
+		}
+		i.SigningKey, err = ParseSigningKey(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return i, nil
+}
+
+// applyConfig sets the fields of the interface that can change while it
+// runs, see UpdateConfig.
+func (i *Interface) applyConfig(c *Config) {
+	i.config = c
+	i.PeerCheckTTL, _ = time.ParseDuration(c.PeerDiscoveryTTL)
+	i.LocalPeer.Endpoint = c.Endpoint
+	// when allocating from a pool the prefix length is the one of the pool
+	if i.Pool == nil && i.LocalPeer.IP != nil {
+		i.PrefixLen = c.PrefixLen
+		if i.PrefixLen == 0 && i.LocalPeer.IP.To4() != nil {
+			i.PrefixLen = defaultPrefixLen
+		} else if i.PrefixLen == 0 {
+			i.PrefixLen = defaultIPv6PrefixLen
+		}
+	}
+	if len(c.Hostname) > 0 {
+		i.LocalPeer.Hostname = c.Hostname
+	}
+	i.MTU = c.MTU
+	i.ListenPort = c.ListenPort
+	i.FwMark = c.FwMark
+	i.RouteTable = c.RouteTable
+	i.Allocation = AllocationStrategy(c.Allocation)
+	i.LoadModule = c.LoadModule
+	i.DNS = c.dns()
+	i.PeerCheckMaxTTL, _ = time.ParseDuration(c.PeerDiscoveryMaxTTL)
+	i.PeerStaleness, _ = time.ParseDuration(c.PeerStaleness)
+	i.PeerDebounce, _ = time.ParseDuration(c.PeerDebounce)
+	i.ConvergenceCycles = c.ConvergenceCycles
+	i.PersistentKeepalive = c.PersistentKeepalive
+	i.ProbeEndpoints = c.ProbeEndpoints
+	i.LocalPeer.AllowedIPs = c.AllowedIPs
+	i.LocalPeer.Gateway = c.Gateway
+	i.LocalPeer.Labels, _ = ParseLabels(c.Labels)
+	i.PeerSelector, _ = ParsePeerSelector(c.PeerSelector)
+	i.AllowedIPsPolicy = AllowedIPsPolicy(c.AllowedIPsPolicy)
+	i.ExcludedIPs = c.excludedIPs()
+	i.LocalPeer.PrefixLen = c.PeerPrefixLen
+	i.LocalPeer.Endpoints = c.Endpoints
+	i.LocalPeer.ManagementAddr = c.ManagementAddr
+	i.MaxRetries = c.MaxRetries
+	i.RetryBackoff, _ = time.ParseDuration(c.RetryBackoff)
+	i.Logger = c.logger(i.Name)
+	i.TrustedSigners, _ = c.trustedSigners()
+	// keep the previous keys rather than allowing all the peers when the
+	// file cannot be read anymore
+	if keys, err := c.authorizedKeys(); err == nil {
+		i.AuthorizedKeys = keys
+	} else {
+		i.logger().Errorf("Error reading the authorized keys, keeping the previous ones: %s", err.Error())
+	}
+}
+
+// logger returns the Logger of the LogFormat for the interface ifname.
+func (c *Config) logger(ifname string) Logger {
+	if c.LogFormat == LogJSON {
+		return JSONLogger{Debug: c.Debug, Interface: ifname}
+	}
+	return StdLogger{Debug: c.Debug}
+}
+
+// authorizedKeys loads the AuthorizedKeys of the config, nil when not set.
+func (c *Config) authorizedKeys() ([][]byte, error) {
+	if len(c.AuthorizedKeys) == 0 {
+		return nil, nil
+	}
+	return LoadAuthorizedKeys(c.AuthorizedKeys)
+}
+
+// dns parses the TunnelDNS of the validated config.
+func (c *Config) dns() []net.IP {
+	var servers []net.IP
+	for _, s := range c.TunnelDNS {
+		servers = append(servers, net.ParseIP(s))
+	}
+	return servers
+}
+
+// excludedIPs parses the ExcludedIPs of the validated config.
+func (c *Config) excludedIPs() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, e := range c.ExcludedIPs {
+		_, network, _ := net.ParseCIDR(e)
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// trustedSigners decodes the TrustedSigners of the config.
+func (c *Config) trustedSigners() ([]ed25519.PublicKey, error) {
+	signers := []ed25519.PublicKey{}
+	for _, s := range c.TrustedSigners {
+		signer, err := ParseTrustedSigner(s)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+// validateEndpoint checks that the endpoint is in format <host>:<port>.
+func validateEndpoint(endpoint string) error {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil || len(host) == 0 {
+		return fmt.Errorf(errEndpointFormatNotValid, ErrInvalidEndpoint)
+	}
+	return validatePort(port)
+}
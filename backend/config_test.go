@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "wirey")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	path := writeConfig(t, "config.yaml", `
+endpoint: 192.168.1.1:2345
+ipaddr: 10.0.0.1
+mtu: 1380
+allowedips:
+  - 192.168.10.0/24
+backend:
+  etcd:
+    - 127.0.0.1:2379
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	c, err := LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "wg0", c.Ifname)
+	assert.Equal(t, "192.168.1.1:2345", c.Endpoint)
+	assert.Equal(t, 1380, c.MTU)
+	assert.Equal(t, 0, c.PrefixLen)
+	assert.Equal(t, []string{"192.168.10.0/24"}, c.AllowedIPs)
+	assert.Equal(t, []string{"127.0.0.1:2379"}, c.Backend.Etcd)
+	assert.Equal(t, "30s", c.Backend.EtcdLeaseTTL)
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	path := writeConfig(t, "config.toml", `
+endpoint = "[2001:db8::1]:2345"
+ipaddr = "10.0.0.0/24"
+
+[backend]
+redis = "127.0.0.1:6379"
+redisttl = "1m"
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	c, err := LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:2345", c.Endpoint)
+	assert.Equal(t, "10.0.0.0/24", c.IPAddr)
+	assert.Equal(t, "127.0.0.1:6379", c.Backend.Redis)
+	assert.Equal(t, "1m", c.Backend.RedisTTL)
+}
+
+func TestLoadConfigUnknownKey(t *testing.T) {
+	for name, content := range map[string]string{
+		"config.yaml": "endpoint: 192.168.1.1:2345\nipaddr: 10.0.0.1\nalowedips: [192.168.10.0/24]\n",
+		"config.toml": "endpoint = \"192.168.1.1:2345\"\nipaddr = \"10.0.0.1\"\nalowedips = [\"192.168.10.0/24\"]\n",
+	} {
+		path := writeConfig(t, name, content)
+		defer os.RemoveAll(filepath.Dir(path))
+
+		_, err := LoadConfig(path)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestConfigValidateNamesTheField(t *testing.T) {
+	valid := func() *Config {
+		c := DefaultConfig()
+		c.Endpoint = "192.168.1.1:2345"
+		c.IPAddr = "10.0.0.1"
+		return c
+	}
+	assert.NoError(t, valid().Validate())
+
+	c := valid()
+	c.Endpoint = "192.168.1.1"
+	assert.EqualError(t, c.Validate(), fmt.Errorf(errConfigField, "endpoint", fmt.Errorf(errEndpointFormatNotValid, ErrInvalidEndpoint)).Error())
+	assert.True(t, errors.Is(c.Validate(), ErrInvalidEndpoint))
+
+	c = valid()
+	c.Endpoints = []string{"10.1.0.1:2345", "10.2.0.1"}
+	assert.EqualError(t, c.Validate(), fmt.Errorf(errConfigField, "endpoints", fmt.Errorf(errEndpointFormatNotValid, ErrInvalidEndpoint)).Error())
+
+	c = valid()
+	c.TrustedSigners = []string{"c2hvcnQ="}
+	assert.EqualError(t, c.Validate(), fmt.Errorf(errConfigField, "trustedsigners", fmt.Errorf(errTrustedSignerNotValid, "c2hvcnQ=")).Error())
+
+	c = valid()
+	c.PeerPrefixLen = 33
+	assert.EqualError(t, c.Validate(), fmt.Errorf(errConfigField, "peerprefixlen", fmt.Errorf(errPrefixLenNotValid, "10.0.0.1", 33)).Error())
+
+	c = valid()
+	c.IPAddr = "10.0.0"
+	assert.EqualError(t, c.Validate(), fmt.Errorf(errConfigField, "ipaddr", fmt.Errorf(errIPNotValid, ErrInvalidIP, "10.0.0")).Error())
+	assert.True(t, errors.Is(c.Validate(), ErrInvalidIP))
+
+	c = valid()
+	c.PrefixLen = 33
+	assert.EqualError(t, c.Validate(), fmt.Errorf(errConfigField, "prefixlen", fmt.Errorf(errPrefixLenNotValid, "10.0.0.1", 33)).Error())
+
+	c = valid()
+	c.AllowedIPsPolicy = string(AllowedIPsCustom)
+	assert.EqualError(t, c.Validate(), fmt.Errorf(errConfigField, "allowedipspolicy", errors.New(errNoAllowedIPsFunc)).Error())
+
+	c = valid()
+	c.ExcludedIPs = []string{"192.168.0.0"}
+	assert.Contains(t, c.Validate().Error(), "invalid excludedips in the config")
+
+	// an observer needs no endpoint nor address
+	c = DefaultConfig()
+	c.Observer = true
+	assert.NoError(t, c.Validate())
+
+	c = valid()
+	c.PrivateKey = "not-a-key"
+	assert.Contains(t, c.Validate().Error(), "invalid privatekey in the config")
+	assert.NotContains(t, c.Validate().Error(), "not-a-key")
+
+	c = valid()
+	c.PeerDiscoveryTTL = "0s"
+	assert.EqualError(t, c.Validate(), fmt.Errorf(errConfigField, "peerdiscoveryttl", fmt.Errorf(errPeerCheckTTLNotValid, "0s")).Error())
+
+	c = valid()
+	c.Backend.RedisTTL = "soon"
+	assert.Contains(t, c.Validate().Error(), "invalid redisttl in the config")
+
+	c = valid()
+	c.MaxRetries = -1
+	assert.EqualError(t, c.Validate(), fmt.Errorf(errConfigField, "maxretries", fmt.Errorf(errMaxRetriesNotValid, -1)).Error())
+
+	c = valid()
+	c.Backend.WritePolicy = "most"
+	assert.EqualError(t, c.Validate(), fmt.Errorf(errConfigField, "writepolicy", fmt.Errorf(errWritePolicyNotValid, WriteAll, WriteAny, "most")).Error())
+}
+
+func TestLoadConfigUnknownFormat(t *testing.T) {
+	path := writeConfig(t, "config.json", "{}")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	_, err := LoadConfig(path)
+	assert.EqualError(t, err, fmt.Sprintf(errConfigFormat, path))
+}
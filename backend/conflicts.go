@@ -0,0 +1,146 @@
+package backend
+
+import (
+	"bytes"
+	"net"
+	"sort"
+)
+
+// PeerConflict is a peer left out of the link because some of the
+// networks it is allowed to send from belong to another peer already:
+// wireguard would silently route them to the last peer configured.
+type PeerConflict struct {
+	Peer Peer
+	// With is the peer that keeps the network
+	With    Peer
+	Network string
+}
+
+// allowedNetwork is a network installed for a peer, gateway tells the
+// ones coming from the default route of a gateway.
+type allowedNetwork struct {
+	*net.IPNet
+	gateway bool
+}
+
+// allowedNetworks returns the valid networks of peerAllowedIPs, the ones
+// installed for the peer after the policy and the ExcludedIPs, without
+// logging the ones not valid.
+func (i *Interface) allowedNetworks(p Peer) []allowedNetwork {
+	if i.AllowedIPsPolicy == AllowedIPsCustom {
+		return parseAllowedNetworks(i.AllowedIPsFunc(p), false)
+	}
+	allowedIPs := []string{}
+	if p.IP != nil {
+		allowedIPs = append(allowedIPs, peerNetwork(p).String())
+	}
+	if host := managementHost(p); host != nil {
+		allowedIPs = append(allowedIPs, host.String())
+	}
+	networks := parseAllowedNetworks(append(allowedIPs, i.excludeIPs(p.AllowedIPs)...), false)
+	if i.AllowedIPsPolicy == AllowedIPsGateway && p.Gateway {
+		networks = append(networks, parseAllowedNetworks(i.excludeIPs([]string{defaultRoute(p)}), true)...)
+	}
+	return networks
+}
+
+func parseAllowedNetworks(allowedIPs []string, gateway bool) []allowedNetwork {
+	networks := []allowedNetwork{}
+	for _, a := range allowedIPs {
+		if _, network, err := net.ParseCIDR(a); err == nil {
+			networks = append(networks, allowedNetwork{IPNet: network, gateway: gateway})
+		}
+	}
+	return networks
+}
+
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// conflicting tells whether wireguard would route some traffic of a to the
+// wrong peer. The default route of a gateway covers the networks of the
+// others on purpose, the more specific ones win, so it only conflicts
+// with the same network.
+func conflicting(a, b allowedNetwork) bool {
+	if a.gateway || b.gateway {
+		return a.String() == b.String()
+	}
+	return overlaps(a.IPNet, b.IPNet)
+}
+
+// withoutConflicts leaves out the remote peers whose networks overlap the
+// ones of another peer. The peers the link is configured with already keep
+// their networks, so that a new peer cannot take over the traffic of an
+// established one, then the peers are considered by public key.
+func (i *Interface) withoutConflicts(peers []Peer) []Peer {
+	applied := map[string]bool{}
+	for _, p := range i.appliedPeers {
+		applied[string(p.PublicKey)] = true
+	}
+	ordered := append([]Peer{}, peers...)
+	sort.SliceStable(ordered, func(a, b int) bool {
+		ka, kb := string(ordered[a].PublicKey), string(ordered[b].PublicKey)
+		if applied[ka] != applied[kb] {
+			return applied[ka]
+		}
+		return ka < kb
+	})
+
+	type claim struct {
+		network allowedNetwork
+		peer    Peer
+	}
+	claims := []claim{}
+	conflicts := []PeerConflict{}
+	skipped := map[string]bool{}
+	for _, p := range ordered {
+		if bytes.Equal(p.PublicKey, i.LocalPeer.PublicKey) {
+			continue
+		}
+		networks := i.allowedNetworks(p)
+		var conflict *PeerConflict
+		for _, n := range networks {
+			for _, c := range claims {
+				if conflicting(n, c.network) {
+					conflict = &PeerConflict{Peer: p, With: c.peer, Network: n.String()}
+					break
+				}
+			}
+			if conflict != nil {
+				break
+			}
+		}
+		if conflict != nil {
+			conflicts = append(conflicts, *conflict)
+			skipped[string(p.PublicKey)] = true
+			continue
+		}
+		for _, n := range networks {
+			claims = append(claims, claim{network: n, peer: p})
+		}
+	}
+
+	previous := map[string]bool{}
+	for _, c := range i.conflicts() {
+		previous[string(c.Peer.PublicKey)] = true
+	}
+	for _, c := range conflicts {
+		// log and notify once, not on every read of the peers
+		if previous[string(c.Peer.PublicKey)] {
+			continue
+		}
+		i.logger().Errorf("Skipping the peer %s at %s, its network %s overlaps the ones of the peer %s",
+			peerName(c.Peer), c.Peer.Endpoint, c.Network, peerName(c.With))
+		i.notifyPeerEvents([]PeerEvent{{Type: PeerSkipped, Peer: c.Peer}})
+	}
+	i.setConflicts(conflicts)
+
+	kept := []Peer{}
+	for _, p := range peers {
+		if !skipped[string(p.PublicKey)] {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,95 @@
+package backend
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithoutConflicts(t *testing.T) {
+	events := []PeerEvent{}
+	i := &Interface{
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		OnPeerEvent: func(e PeerEvent) { events = append(events, e) },
+	}
+	first := testPeer("key1", "10.0.0.2", "192.168.1.2:2345")
+	first.AllowedIPs = []string{"10.1.0.5/32"}
+	second := testPeer("key2", "10.0.0.3", "192.168.1.3:2345")
+	second.AllowedIPs = []string{"10.1.0.5/32"}
+	third := testPeer("key3", "10.0.0.4", "192.168.1.4:2345")
+
+	peers := i.withoutConflicts([]Peer{second, i.LocalPeer, third, first})
+	assert.Equal(t, []Peer{i.LocalPeer, third, first}, peers)
+
+	status, err := i.Status()
+	assert.NoError(t, err)
+	if assert.Len(t, status.Conflicts, 1) {
+		assert.Equal(t, "key2", string(status.Conflicts[0].Peer.PublicKey))
+		assert.Equal(t, "key1", string(status.Conflicts[0].With.PublicKey))
+		assert.Equal(t, "10.1.0.5/32", status.Conflicts[0].Network)
+	}
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, PeerSkipped, events[0].Type)
+		assert.Equal(t, "key2", string(events[0].Peer.PublicKey))
+	}
+
+	// notified once while the conflict lasts
+	i.withoutConflicts([]Peer{second, first})
+	assert.Len(t, events, 1)
+
+	// the peer configured already keeps its network
+	i.appliedPeers = []Peer{second}
+	i.setConflicts(nil)
+	peers = i.withoutConflicts([]Peer{first, second})
+	assert.Equal(t, []Peer{second}, peers)
+}
+
+func TestWithoutConflictsPrefixLen(t *testing.T) {
+	i := &Interface{LocalPeer: testPeer("local", "10.0.0.1", "192.168.1.1:2345")}
+	wide := testPeer("key1", "10.0.0.2", "192.168.1.2:2345")
+	wide.PrefixLen = 24
+	inside := testPeer("key2", "10.0.0.3", "192.168.1.3:2345")
+
+	peers := i.withoutConflicts([]Peer{wide, inside})
+	assert.Equal(t, []Peer{wide}, peers)
+}
+
+func TestWithoutConflictsGateways(t *testing.T) {
+	i := &Interface{
+		LocalPeer:        testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		AllowedIPsPolicy: AllowedIPsGateway,
+	}
+	first := testPeer("key1", "10.0.0.2", "192.168.1.2:2345")
+	first.Gateway = true
+	second := testPeer("key2", "10.0.0.3", "192.168.1.3:2345")
+	second.Gateway = true
+	third := testPeer("key3", "10.0.0.4", "192.168.1.4:2345")
+
+	// the default route covers the others, but only one gateway can get it
+	peers := i.withoutConflicts([]Peer{first, second, third})
+	assert.Equal(t, []Peer{first, third}, peers)
+	status, err := i.Status()
+	assert.NoError(t, err)
+	if assert.Len(t, status.Conflicts, 1) {
+		assert.Equal(t, "key2", string(status.Conflicts[0].Peer.PublicKey))
+		assert.Equal(t, "key1", string(status.Conflicts[0].With.PublicKey))
+		assert.Equal(t, "0.0.0.0/0", status.Conflicts[0].Network)
+	}
+}
+
+func TestWithoutConflictsExcludedIPs(t *testing.T) {
+	_, excluded, _ := net.ParseCIDR("10.1.0.0/24")
+	i := &Interface{
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		ExcludedIPs: []*net.IPNet{excluded},
+	}
+	first := testPeer("key1", "10.0.0.2", "192.168.1.2:2345")
+	first.AllowedIPs = []string{"10.1.0.0/24"}
+	second := testPeer("key2", "10.0.0.3", "192.168.1.3:2345")
+	second.AllowedIPs = []string{"10.1.0.0/24"}
+
+	// the overlapping network is not installed for any of them
+	peers := i.withoutConflicts([]Peer{first, second})
+	assert.Equal(t, []Peer{first, second}, peers)
+}
@@ -0,0 +1,348 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+const (
+	consulWireyPrefix   = "wirey"
+	consulAddressPrefix = "wirey-addresses"
+	// consul refuses session ttls lower than 10 seconds
+	consulMinTTL = 10 * time.Second
+)
+
+// ConsulBackend stores every peer under wirey/<ifname>/<publickeysha>,
+// each key is acquired by a session with a ttl that is renewed until
+// the peer leaves, when the session expires consul deletes the key.
+type ConsulBackend struct {
+	client   *consul.Client
+	ttl      time.Duration
+	mutex    *sync.Mutex
+	sessions map[string]consulSession
+	// Logger reports the failed renewals of the sessions, StdLogger when nil
+	Logger Logger
+}
+
+type consulSession struct {
+	id   string
+	stop chan struct{}
+	// addressKey is the address claimed by CompareAndJoin
+	addressKey string
+}
+
+// NewConsulBackend connects to the consul agent at address, datacenter and token are optional.
+func NewConsulBackend(address, datacenter, token string, ttl time.Duration) (*ConsulBackend, error) {
+	return NewConsulBackendWithTLS(address, datacenter, token, TLSConfig{}, ttl)
+}
+
+// NewConsulBackendWithTLS connects to the consul agent like NewConsulBackend,
+// the tls files are optional too, they are checked before connecting and
+// make the agent be reached over https.
+func NewConsulBackendWithTLS(address, datacenter, token string, tlsConfig TLSConfig, ttl time.Duration) (*ConsulBackend, error) {
+	if ttl < consulMinTTL {
+		return nil, fmt.Errorf("the consul session ttl must be at least %s, got: %s", consulMinTTL, ttl)
+	}
+	if _, err := tlsConfig.Load(); err != nil {
+		return nil, fmt.Errorf("error configuring the consul tls: %w", err)
+	}
+	config := consul.DefaultConfig()
+	config.Address = address
+	config.Datacenter = datacenter
+	config.Token = token
+	if tlsConfig.Enabled() {
+		config.Scheme = "https"
+		config.TLSConfig = consul.TLSConfig{
+			CAFile:   tlsConfig.CAFile,
+			CertFile: tlsConfig.CertFile,
+			KeyFile:  tlsConfig.KeyFile,
+		}
+	}
+	cli, err := consul.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &ConsulBackend{
+		client:   cli,
+		ttl:      ttl,
+		mutex:    &sync.Mutex{},
+		sessions: map[string]consulSession{},
+	}, nil
+}
+
+// Ping asks the leader of the cluster to the agent, it fails without one.
+func (c *ConsulBackend) Ping(ctx context.Context) error {
+	var leader string
+	// the status endpoint of the client takes no context
+	err := callWithContext(ctx, func() error {
+		var err error
+		leader, err = c.client.Status().Leader()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error checking the consul status: %w", err)
+	}
+	if len(leader) == 0 {
+		return fmt.Errorf("the consul cluster has no leader")
+	}
+	return nil
+}
+
+func consulPrefix(ifname string) string {
+	return fmt.Sprintf("%s/%s/", consulWireyPrefix, ifname)
+}
+
+func consulPeerKey(ifname string, p Peer) string {
+	return fmt.Sprintf("%s%s", consulPrefix(ifname), publicKeySHA256(p.PublicKey))
+}
+
+func consulAddressKey(ifname string, p Peer) string {
+	return fmt.Sprintf("%s/%s/%s", consulAddressPrefix, ifname, p.IP.String())
+}
+
+// consulQuery returns the options of a read stopped with ctx.
+func consulQuery(ctx context.Context) *consul.QueryOptions {
+	return (&consul.QueryOptions{}).WithContext(ctx)
+}
+
+// consulWrite returns the options of a write stopped with ctx.
+func consulWrite(ctx context.Context) *consul.WriteOptions {
+	return (&consul.WriteOptions{}).WithContext(ctx)
+}
+
+// EntryTTL is the ttl of the session of the peers.
+func (c *ConsulBackend) EntryTTL() time.Duration {
+	return c.ttl
+}
+
+func (c *ConsulBackend) Join(ctx context.Context, ifname string, p Peer) error {
+	pj, err := MarshalPeer(p)
+	if err != nil {
+		return err
+	}
+
+	key := consulPeerKey(ifname, p)
+	s, err := c.session(ctx, ifname, key)
+	if err != nil {
+		return err
+	}
+	// the key is derived from our public key, whoever holds it is a previous run
+	acquired, err := c.acquire(ctx, key, pj, s.id, func(*consul.KVPair) bool { return true })
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return fmt.Errorf("the consul key %s is held by another session", key)
+	}
+	return nil
+}
+
+// CompareAndJoin acquires wirey-addresses/<ifname>/<ip> with the session
+// of the peer before storing it, the address is taken when another
+// session holds it for a different public key.
+func (c *ConsulBackend) CompareAndJoin(ctx context.Context, ifname string, p Peer) error {
+	pj, err := MarshalPeer(p)
+	if err != nil {
+		return err
+	}
+
+	key := consulPeerKey(ifname, p)
+	s, err := c.session(ctx, ifname, key)
+	if err != nil {
+		return err
+	}
+
+	addressKey := consulAddressKey(ifname, p)
+	acquired, err := c.acquire(ctx, addressKey, p.PublicKey, s.id, func(pair *consul.KVPair) bool {
+		return string(pair.Value) == string(p.PublicKey)
+	})
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return AddressTakenError{IP: p.IP.String()}
+	}
+
+	// release the address claimed by the previous join
+	c.mutex.Lock()
+	previous := c.sessions[key].addressKey
+	s.addressKey = addressKey
+	c.sessions[key] = s
+	c.mutex.Unlock()
+	if len(previous) > 0 && previous != addressKey {
+		c.client.KV().Delete(previous, consulWrite(ctx))
+	}
+
+	acquired, err = c.acquire(ctx, key, pj, s.id, func(*consul.KVPair) bool { return true })
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return fmt.Errorf("the consul key %s is held by another session", key)
+	}
+	return nil
+}
+
+// session returns the session holding the keys of the peer,
+// it is created and renewed from the first join until Leave.
+func (c *ConsulBackend) session(ctx context.Context, ifname, key string) (consulSession, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if s, ok := c.sessions[key]; ok {
+		return s, nil
+	}
+
+	id, _, err := c.client.Session().CreateNoChecks(&consul.SessionEntry{
+		Name:     fmt.Sprintf("wirey-%s", ifname),
+		TTL:      c.ttl.String(),
+		Behavior: consul.SessionBehaviorDelete,
+	}, consulWrite(ctx))
+	if err != nil {
+		return consulSession{}, err
+	}
+	s := consulSession{id: id, stop: make(chan struct{})}
+	c.sessions[key] = s
+
+	go func() {
+		err := c.client.Session().RenewPeriodic(c.ttl.String(), id, nil, s.stop)
+		if err != nil {
+			orStdLogger(c.Logger).Errorf("error renewing the consul session of %s: %s", key, err.Error())
+		}
+	}()
+	return s, nil
+}
+
+// acquire locks the key with the session and sets its value, when another
+// session holds the key and takeover agrees, e.g: the session of a previous
+// run of wirey that did not expire yet, that session is destroyed first.
+func (c *ConsulBackend) acquire(ctx context.Context, key string, value []byte, id string, takeover func(*consul.KVPair) bool) (bool, error) {
+	pair := &consul.KVPair{
+		Key:     key,
+		Value:   value,
+		Session: id,
+	}
+	acquired, _, err := c.client.KV().Acquire(pair, consulWrite(ctx))
+	if err != nil || acquired {
+		return acquired, err
+	}
+
+	current, _, err := c.client.KV().Get(key, consulQuery(ctx))
+	if err != nil {
+		return false, err
+	}
+	if current == nil || len(current.Session) == 0 || !takeover(current) {
+		return false, nil
+	}
+	if _, err := c.client.Session().Destroy(current.Session, consulWrite(ctx)); err != nil {
+		return false, err
+	}
+	acquired, _, err = c.client.KV().Acquire(pair, consulWrite(ctx))
+	return acquired, err
+}
+
+// Leave destroys the session of the peer and deletes its key.
+func (c *ConsulBackend) Leave(ctx context.Context, ifname string, p Peer) error {
+	key := consulPeerKey(ifname, p)
+
+	c.mutex.Lock()
+	s, ok := c.sessions[key]
+	delete(c.sessions, key)
+	c.mutex.Unlock()
+
+	if ok {
+		close(s.stop)
+		if _, err := c.client.Session().Destroy(s.id, consulWrite(ctx)); err != nil {
+			return err
+		}
+	}
+	_, err := c.client.KV().Delete(key, consulWrite(ctx))
+	return err
+}
+
+// Close stops renewing the sessions, the keys of the peers that did not
+// leave are deleted when they expire.
+func (c *ConsulBackend) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, s := range c.sessions {
+		close(s.stop)
+		delete(c.sessions, key)
+	}
+	return nil
+}
+
+// ListInterfaces lists the folders under wirey/.
+func (c *ConsulBackend) ListInterfaces(ctx context.Context) ([]string, error) {
+	prefix := consulWireyPrefix + "/"
+	keys, _, err := c.client.KV().Keys(prefix, "/", consulQuery(ctx))
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, key := range keys {
+		names = append(names, interfaceFromKey(key, prefix))
+	}
+	return names, nil
+}
+
+func (c *ConsulBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	peers, _, err := c.list(ifname, consulQuery(ctx))
+	return peers, err
+}
+
+func (c *ConsulBackend) list(ifname string, q *consul.QueryOptions) ([]Peer, uint64, error) {
+	pairs, meta, err := c.client.KV().List(consulPrefix(ifname), q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	peers := []Peer{}
+	for _, pair := range pairs {
+		peer, err := UnmarshalPeer(pair.Value)
+		if err != nil {
+			return nil, 0, err
+		}
+		peers = append(peers, peer)
+	}
+	return peers, meta.LastIndex, nil
+}
+
+// Watch uses blocking queries on the interface prefix.
+func (c *ConsulBackend) Watch(ctx context.Context, ifname string) (<-chan []Peer, error) {
+	peers, index, err := c.list(ifname, consulQuery(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	peersc := make(chan []Peer)
+	go func() {
+		defer close(peersc)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case peersc <- peers:
+			}
+
+			// the blocking query returns early when the prefix changes,
+			// loop until the index moves since it can also time out
+			lastIndex := index
+			for index == lastIndex {
+				q := &consul.QueryOptions{WaitIndex: index}
+				peers, index, err = c.list(ifname, q.WithContext(ctx))
+				if err != nil {
+					return
+				}
+				// the index went backwards, consul asks to start over
+				if index < lastIndex {
+					index = 0
+				}
+			}
+		}
+	}()
+	return peersc, nil
+}
@@ -0,0 +1,50 @@
+package backend
+
+import (
+	"context"
+	"time"
+)
+
+// debounce forwards the peers received on peersc, coalescing the ones
+// received within window of a change into the last of them, so that churn
+// does not reconfigure the link back to back. The first peers are sent
+// right away and a change waits at most window. The returned channel is
+// closed with peersc or when the context is done.
+func debounce(ctx context.Context, peersc <-chan []Peer, window time.Duration) <-chan []Peer {
+	if window <= 0 {
+		return peersc
+	}
+	out := make(chan []Peer)
+	go func() {
+		defer close(out)
+		var pending []Peer
+		var flush <-chan time.Time
+		// the first peers are not delayed
+		var outc chan<- []Peer
+		first := true
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case peers, ok := <-peersc:
+				if !ok {
+					return
+				}
+				pending = peers
+				if first {
+					outc = out
+				} else if flush == nil {
+					flush = time.After(window)
+				}
+			case <-flush:
+				flush = nil
+				outc = out
+			case outc <- pending:
+				first = false
+				outc = nil
+				pending = nil
+			}
+		}
+	}()
+	return out
+}
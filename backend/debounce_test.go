@@ -0,0 +1,58 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func receivePeers(t *testing.T, peersc <-chan []Peer, timeout time.Duration) []Peer {
+	select {
+	case peers := <-peersc:
+		return peers
+	case <-time.After(timeout):
+		t.Fatal("no peers received")
+	}
+	return nil
+}
+
+func TestDebounceCoalescesChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan []Peer)
+	out := debounce(ctx, in, 100*time.Millisecond)
+
+	first := []Peer{testPeer("key1", "10.0.0.1", "192.168.1.1:2345")}
+	in <- first
+	// the first peers are not delayed
+	assert.Equal(t, first, receivePeers(t, out, 50*time.Millisecond))
+
+	second := append(first, testPeer("key2", "10.0.0.2", "192.168.1.2:2345"))
+	third := append(second, testPeer("key3", "10.0.0.3", "192.168.1.3:2345"))
+	start := time.Now()
+	in <- second
+	in <- third
+	assert.Equal(t, third, receivePeers(t, out, time.Second))
+	assert.True(t, time.Since(start) >= 100*time.Millisecond, time.Since(start).String())
+
+	select {
+	case peers := <-out:
+		t.Fatalf("unexpected peers: %v", peers)
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func TestDebounceClosesWithInput(t *testing.T) {
+	in := make(chan []Peer)
+	out := debounce(context.Background(), in, time.Second)
+	close(in)
+	_, ok := <-out
+	assert.False(t, ok)
+}
+
+func TestDebounceDisabled(t *testing.T) {
+	in := make(chan []Peer)
+	assert.Equal(t, (<-chan []Peer)(in), debounce(context.Background(), in, 0))
+}
@@ -0,0 +1,236 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	dnsWireyLabel  = "_wirey"
+	dnsDefaultTTL  = 60
+	dnsTXTChunkLen = 255
+	dnsTimeout     = 5 * time.Second
+)
+
+// TSIG is the key used to sign the dynamic updates.
+type TSIG struct {
+	Name string
+	// Secret is the base64 encoded hmac-sha256 secret
+	Secret string
+}
+
+// DNSBackend publishes every peer as a TXT record at _wirey.<ifname>.<domain>,
+// the record holds the base64 encoded json peer. Peers are read from the
+// nameserver and, when TSIG is set, written with RFC2136 dynamic updates,
+// otherwise Join and Leave do nothing and the discovery is read only.
+type DNSBackend struct {
+	domain     string
+	nameserver string
+	client     *dns.Client
+	// TTL of the published records, in seconds
+	TTL  uint32
+	TSIG *TSIG
+
+	mutex *sync.Mutex
+	cache map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	peers   []Peer
+	expires time.Time
+}
+
+// NewDNSBackend uses the nameserver, e.g: 192.168.1.1:53, to query and update the domain zone.
+func NewDNSBackend(domain, nameserver string) (*DNSBackend, error) {
+	if len(domain) == 0 {
+		return nil, fmt.Errorf("the dns backend needs a domain")
+	}
+	if len(nameserver) == 0 {
+		return nil, fmt.Errorf("the dns backend needs a nameserver")
+	}
+	return &DNSBackend{
+		domain:     dns.Fqdn(domain),
+		nameserver: nameserver,
+		client: &dns.Client{
+			Net:     "tcp",
+			Timeout: dnsTimeout,
+		},
+		TTL:   dnsDefaultTTL,
+		mutex: &sync.Mutex{},
+		cache: map[string]dnsCacheEntry{},
+	}, nil
+}
+
+func (d *DNSBackend) recordName(ifname string) string {
+	return fmt.Sprintf("%s.%s.%s", dnsWireyLabel, ifname, d.domain)
+}
+
+func (d *DNSBackend) Join(ctx context.Context, ifname string, p Peer) error {
+	if d.TSIG == nil {
+		return nil
+	}
+	rr, err := d.peerRecord(ifname, p)
+	if err != nil {
+		return err
+	}
+
+	// replace the records published before by the same peer
+	old, _, err := d.lookup(ctx, ifname)
+	if err != nil {
+		return err
+	}
+	m := d.update()
+	for _, o := range old {
+		if bytes.Equal(o.peer.PublicKey, p.PublicKey) {
+			m.Remove([]dns.RR{o.rr})
+		}
+	}
+	m.Insert([]dns.RR{rr})
+	return d.exchange(ctx, ifname, m)
+}
+
+func (d *DNSBackend) Leave(ctx context.Context, ifname string, p Peer) error {
+	if d.TSIG == nil {
+		return nil
+	}
+	old, _, err := d.lookup(ctx, ifname)
+	if err != nil {
+		return err
+	}
+	m := d.update()
+	for _, o := range old {
+		if bytes.Equal(o.peer.PublicKey, p.PublicKey) {
+			m.Remove([]dns.RR{o.rr})
+		}
+	}
+	return d.exchange(ctx, ifname, m)
+}
+
+// GetPeers resolves the peers, caching them for the TTL of the records.
+func (d *DNSBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	d.mutex.Lock()
+	entry, ok := d.cache[ifname]
+	d.mutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.peers, nil
+	}
+
+	records, ttl, err := d.lookup(ctx, ifname)
+	if err != nil {
+		return nil, err
+	}
+	peers := []Peer{}
+	for _, r := range records {
+		peers = append(peers, r.peer)
+	}
+
+	d.mutex.Lock()
+	d.cache[ifname] = dnsCacheEntry{
+		peers:   peers,
+		expires: time.Now().Add(time.Duration(ttl) * time.Second),
+	}
+	d.mutex.Unlock()
+	return peers, nil
+}
+
+type dnsPeerRecord struct {
+	rr   *dns.TXT
+	peer Peer
+}
+
+// lookup returns the peer records and the lowest ttl among them.
+func (d *DNSBackend) lookup(ctx context.Context, ifname string) ([]dnsPeerRecord, uint32, error) {
+	m := &dns.Msg{}
+	m.SetQuestion(d.recordName(ifname), dns.TypeTXT)
+	res, _, err := d.client.ExchangeContext(ctx, m, d.nameserver)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error resolving the peers: %w", err)
+	}
+	if res.Rcode != dns.RcodeSuccess && res.Rcode != dns.RcodeNameError {
+		return nil, 0, fmt.Errorf("error resolving the peers: %s", dns.RcodeToString[res.Rcode])
+	}
+
+	records := []dnsPeerRecord{}
+	var ttl uint32
+	for _, a := range res.Answer {
+		txt, ok := a.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		pj, err := base64.StdEncoding.DecodeString(strings.Join(txt.Txt, ""))
+		if err != nil {
+			return nil, 0, fmt.Errorf("error decoding the peer record: %w", err)
+		}
+		peer, err := UnmarshalPeer(pj)
+		if err != nil {
+			return nil, 0, err
+		}
+		records = append(records, dnsPeerRecord{rr: txt, peer: peer})
+		if ttl == 0 || txt.Hdr.Ttl < ttl {
+			ttl = txt.Hdr.Ttl
+		}
+	}
+	return records, ttl, nil
+}
+
+// peerRecord encodes the peer in a TXT record, split in strings of 255 characters.
+func (d *DNSBackend) peerRecord(ifname string, p Peer) (*dns.TXT, error) {
+	pj, err := MarshalPeer(p)
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(pj)
+	txt := []string{}
+	for len(encoded) > dnsTXTChunkLen {
+		txt = append(txt, encoded[:dnsTXTChunkLen])
+		encoded = encoded[dnsTXTChunkLen:]
+	}
+	txt = append(txt, encoded)
+
+	return &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   d.recordName(ifname),
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    d.TTL,
+		},
+		Txt: txt,
+	}, nil
+}
+
+func (d *DNSBackend) update() *dns.Msg {
+	m := &dns.Msg{}
+	m.SetUpdate(d.domain)
+	return m
+}
+
+// exchange signs and sends the update, invalidating the cached peers.
+func (d *DNSBackend) exchange(ctx context.Context, ifname string, m *dns.Msg) error {
+	keyName := dns.Fqdn(d.TSIG.Name)
+	m.SetTsig(keyName, dns.HmacSHA256, 300, time.Now().Unix())
+	client := &dns.Client{
+		Net:        d.client.Net,
+		Timeout:    d.client.Timeout,
+		TsigSecret: map[string]string{keyName: d.TSIG.Secret},
+	}
+
+	res, _, err := client.ExchangeContext(ctx, m, d.nameserver)
+	if err != nil {
+		return fmt.Errorf("error updating the peers: %w", err)
+	}
+	if res.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("error updating the peers: %s", dns.RcodeToString[res.Rcode])
+	}
+
+	d.mutex.Lock()
+	delete(d.cache, ifname)
+	d.mutex.Unlock()
+	return nil
+}
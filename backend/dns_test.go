@@ -0,0 +1,33 @@
+package backend
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNSPeerRecord(t *testing.T) {
+	d, err := NewDNSBackend("example.com", "127.0.0.1:53")
+	assert.NoError(t, err)
+
+	p := testPeer("Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=", "10.0.0.1", "192.168.1.1:2345")
+	p.AllowedIPs = []string{"192.168.10.0/24", "192.168.11.0/24", "192.168.12.0/24", "192.168.13.0/24", "192.168.14.0/24"}
+	rr, err := d.peerRecord("wg0", p)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "_wirey.wg0.example.com.", rr.Hdr.Name)
+	assert.Equal(t, uint32(60), rr.Hdr.Ttl)
+	assert.True(t, len(rr.Txt) > 1)
+	for _, s := range rr.Txt {
+		assert.True(t, len(s) <= 255)
+	}
+
+	pj, err := base64.StdEncoding.DecodeString(strings.Join(rr.Txt, ""))
+	assert.NoError(t, err)
+	decoded := Peer{}
+	assert.NoError(t, json.Unmarshal(pj, &decoded))
+	assert.Equal(t, p, decoded)
+}
@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/influxdata/wirey/pkg/wireguard"
+)
+
+const hiddenPrivateKey = "(hidden)"
+
+// dryRun prints the wireguard configuration and the link changes Connect
+// would apply for the peers currently in the backend, without joining it.
+func (i *Interface) dryRun(ctx context.Context) error {
+	peers, err := i.Backend.GetPeers(ctx, i.Name)
+	if err != nil {
+		return err
+	}
+
+	// preview the address the allocation would pick, among all the peers
+	// like allocateIP
+	if i.Pool != nil && i.LocalPeer.IP == nil {
+		ip, err := freeIP(i.Pool, peers, i.LocalPeer.PublicKey, i.allocationStart())
+		if err != nil {
+			return err
+		}
+		i.LocalPeer.IP = &ip
+	}
+
+	owner, err := i.addressOwner(ctx)
+	if err != nil {
+		return err
+	}
+	if owner != nil {
+		return i.addressTakenError(*owner)
+	}
+
+	addr, listenPort, err := i.checkConfig()
+	if err != nil {
+		return err
+	}
+
+	peers = i.usablePeers(peers)
+	conf := i.configuration(peers, listenPort)
+	conf.Interface.PrivateKey = hiddenPrivateKey
+	rendered, err := wireguard.RenderConfiguration(conf)
+	if err != nil {
+		return err
+	}
+
+	w := i.DryRunOutput
+	if w == nil {
+		w = os.Stdout
+	}
+	fmt.Fprintf(w, "# wireguard configuration of %s\n%s\n", i.Name, rendered)
+	fmt.Fprintf(w, "# link operations\n")
+	fmt.Fprintf(w, "ensure the wireguard link %s with mtu %d\n", i.Name, i.mtu())
+	fmt.Fprintf(w, "add the address %s to %s\n", addr.IPNet.String(), i.Name)
+	fmt.Fprintf(w, "set %s up\n", i.Name)
+	for _, dst := range i.peerRoutes(peers, addr.IPNet) {
+		fmt.Fprintf(w, "replace the route to %s through %s\n", dst.String(), i.Name)
+	}
+	return nil
+}
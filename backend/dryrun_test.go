@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRun(t *testing.T) {
+	b := NewMemoryBackend()
+	remote := testPeer("remote", "10.0.0.2", "192.168.1.2:2345")
+	remote.AllowedIPs = []string{"192.168.10.0/24"}
+	assert.NoError(t, b.Join(context.Background(), "wg0", remote))
+
+	out := &bytes.Buffer{}
+	i := &Interface{
+		Backend:      b,
+		Name:         "wg0",
+		PrefixLen:    24,
+		LocalPeer:    testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		DryRun:       true,
+		DryRunOutput: out,
+		privateKey:   []byte("private"),
+	}
+	assert.NoError(t, i.dryRun(context.Background()))
+
+	assert.Contains(t, out.String(), "PrivateKey = (hidden)")
+	assert.NotContains(t, out.String(), "private\n")
+	assert.Contains(t, out.String(), "PublicKey = remote")
+	assert.Contains(t, out.String(), "AllowedIPs = 10.0.0.2/32,192.168.10.0/24")
+	assert.Contains(t, out.String(), "add the address 10.0.0.1/24 to wg0")
+	assert.Contains(t, out.String(), "replace the route to 192.168.10.0/24 through wg0")
+
+	// nothing is written to the backend
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 1)
+}
+
+func TestDryRunAuthorizedKeys(t *testing.T) {
+	b := NewMemoryBackend()
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer(authorizedKey1, "10.0.0.2", "192.168.1.2:2345")))
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer(authorizedKey2, "10.0.0.3", "192.168.1.3:2345")))
+
+	out := &bytes.Buffer{}
+	i := &Interface{
+		Backend:        b,
+		Name:           "wg0",
+		PrefixLen:      24,
+		LocalPeer:      testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		AuthorizedKeys: [][]byte{[]byte(authorizedKey1)},
+		DryRun:         true,
+		DryRunOutput:   out,
+	}
+	assert.NoError(t, i.dryRun(context.Background()))
+
+	assert.Contains(t, out.String(), "PublicKey = "+authorizedKey1)
+	assert.NotContains(t, out.String(), "PublicKey = "+authorizedKey2)
+}
@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+// endpointTimeout is how long an endpoint is kept without a handshake
+// before failing over to the next one of the peer. Wireguard handshakes
+// every 2 minutes while the peers exchange packets.
+const endpointTimeout = time.Minute * 3
+
+// endpointChoice is the endpoint of a peer in use, as an index in its
+// candidates, and the time it was picked.
+type endpointChoice struct {
+	index int
+	since time.Time
+}
+
+// endpointCandidates returns the endpoints of the peer in order of
+// priority: Endpoint first, then the Endpoints not equal to it.
+func endpointCandidates(p Peer) []string {
+	candidates := []string{p.Endpoint}
+	for _, e := range p.Endpoints {
+		if e != p.Endpoint {
+			candidates = append(candidates, e)
+		}
+	}
+	return candidates
+}
+
+// chooseEndpoints returns a copy of the peers with the Endpoint set to
+// the one in use. A peer stays on its endpoint while the handshakes go
+// through, after endpointTimeout without one the next endpoint is tried,
+// wrapping around. The handshakes are read through a LinkManager that is
+// a StatsReader and are only regular with a PersistentKeepalive,
+// without them the first endpoint is used.
+func (i *Interface) chooseEndpoints(peers []Peer, now time.Time) []Peer {
+	reader, ok := i.links().(StatsReader)
+	if !ok || i.PersistentKeepalive <= 0 {
+		return peers
+	}
+	var handshakes map[string]time.Time
+	stats, err := reader.PeerStats(i.Name)
+	if err != nil {
+		// the link does not exist before the first configuration,
+		// the peers keep their endpoint until the handshakes are known
+		i.logger().Debugf("Cannot read the handshakes of the peers: %s", err.Error())
+	} else {
+		handshakes = map[string]time.Time{}
+		for _, s := range stats {
+			handshakes[s.PublicKey] = s.LastHandshake
+		}
+	}
+
+	choices := map[string]endpointChoice{}
+	chosen := make([]Peer, 0, len(peers))
+	for _, p := range peers {
+		candidates := endpointCandidates(p)
+		if len(candidates) == 1 || bytes.Equal(p.PublicKey, i.LocalPeer.PublicKey) {
+			chosen = append(chosen, p)
+			continue
+		}
+
+		key := strings.TrimSpace(string(p.PublicKey))
+		choice, ok := i.endpoints[key]
+		if !ok || choice.index >= len(candidates) {
+			choice = endpointChoice{since: now}
+		}
+		if handshakes != nil && now.Sub(choice.since) > endpointTimeout && now.Sub(handshakes[key]) > endpointTimeout {
+			choice = endpointChoice{index: (choice.index + 1) % len(candidates), since: now}
+			i.logger().Infof("No handshake with %s for %s, trying the endpoint %s", peerName(p), endpointTimeout, candidates[choice.index])
+		}
+		choices[key] = choice
+
+		p.Endpoint = candidates[choice.index]
+		chosen = append(chosen, p)
+	}
+	i.endpoints = choices
+	return chosen
+}
@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/influxdata/wirey/pkg/wireguard"
+	"github.com/stretchr/testify/assert"
+)
+
+// handshakeLinkManager reports the handshakes of the peers.
+type handshakeLinkManager struct {
+	*fakeLinkManager
+	handshakes map[string]time.Time
+}
+
+func (h *handshakeLinkManager) PeerStats(ifname string) ([]wireguard.PeerStats, error) {
+	if h.handshakes == nil {
+		return nil, fmt.Errorf("link %s not found", ifname)
+	}
+	stats := []wireguard.PeerStats{}
+	for key, handshake := range h.handshakes {
+		stats = append(stats, wireguard.PeerStats{PublicKey: key, LastHandshake: handshake})
+	}
+	return stats, nil
+}
+
+func multiEndpointPeer() Peer {
+	p := testPeer("key2", "10.0.0.2", "192.168.1.2:2345")
+	p.Endpoints = []string{"192.168.1.2:2345", "10.1.0.2:2345", "10.2.0.2:2345"}
+	return p
+}
+
+func TestEndpointCandidates(t *testing.T) {
+	assert.Equal(t, []string{"192.168.1.2:2345", "10.1.0.2:2345", "10.2.0.2:2345"}, endpointCandidates(multiEndpointPeer()))
+	assert.Equal(t, []string{"192.168.1.1:2345"}, endpointCandidates(testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+}
+
+func TestChooseEndpointsWithoutHandshakes(t *testing.T) {
+	i := &Interface{Name: "wg0", LinkManager: newFakeLinkManager(), PersistentKeepalive: 25}
+	peers := []Peer{multiEndpointPeer()}
+
+	// the first endpoint is used when the handshakes cannot be read
+	now := time.Now()
+	i.chooseEndpoints(peers, now)
+	chosen := i.chooseEndpoints(peers, now.Add(time.Hour))
+	assert.Equal(t, "192.168.1.2:2345", chosen[0].Endpoint)
+}
+
+func TestChooseEndpointsFailover(t *testing.T) {
+	links := &handshakeLinkManager{fakeLinkManager: newFakeLinkManager()}
+	i := &Interface{Name: "wg0", LinkManager: links, PersistentKeepalive: 25}
+	peers := []Peer{multiEndpointPeer(), testPeer("key3", "10.0.0.3", "192.168.1.3:2345")}
+	now := time.Now()
+
+	// the link does not exist yet, the first endpoint is kept
+	chosen := i.chooseEndpoints(peers, now)
+	assert.Equal(t, "192.168.1.2:2345", chosen[0].Endpoint)
+	assert.Equal(t, "192.168.1.3:2345", chosen[1].Endpoint)
+
+	// no handshake, but the endpoint is not given up before the timeout
+	links.handshakes = map[string]time.Time{}
+	chosen = i.chooseEndpoints(peers, now.Add(time.Minute))
+	assert.Equal(t, "192.168.1.2:2345", chosen[0].Endpoint)
+
+	now = now.Add(endpointTimeout + time.Second)
+	chosen = i.chooseEndpoints(peers, now)
+	assert.Equal(t, "10.1.0.2:2345", chosen[0].Endpoint)
+	assert.Equal(t, "192.168.1.3:2345", chosen[1].Endpoint)
+	// the peers given to choose are not changed
+	assert.Equal(t, "192.168.1.2:2345", peers[0].Endpoint)
+
+	// the endpoint is kept while the handshakes go through
+	links.handshakes["key2"] = now.Add(endpointTimeout)
+	now = now.Add(endpointTimeout + time.Second)
+	chosen = i.chooseEndpoints(peers, now)
+	assert.Equal(t, "10.1.0.2:2345", chosen[0].Endpoint)
+
+	// then the next ones are tried, wrapping around
+	now = now.Add(endpointTimeout + time.Second)
+	chosen = i.chooseEndpoints(peers, now)
+	assert.Equal(t, "10.2.0.2:2345", chosen[0].Endpoint)
+	now = now.Add(endpointTimeout + time.Second)
+	chosen = i.chooseEndpoints(peers, now)
+	assert.Equal(t, "192.168.1.2:2345", chosen[0].Endpoint)
+}
+
+func TestChooseEndpointsNeedsKeepalive(t *testing.T) {
+	links := &handshakeLinkManager{fakeLinkManager: newFakeLinkManager(), handshakes: map[string]time.Time{}}
+	i := &Interface{Name: "wg0", LinkManager: links}
+	peers := []Peer{multiEndpointPeer()}
+
+	// without keepalive an idle peer does not handshake, it is not a failure
+	now := time.Now()
+	i.chooseEndpoints(peers, now)
+	chosen := i.chooseEndpoints(peers, now.Add(2*endpointTimeout))
+	assert.Equal(t, "192.168.1.2:2345", chosen[0].Endpoint)
+}
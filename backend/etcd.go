@@ -2,43 +2,211 @@ package backend
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
 )
 
 const (
-	etcdWireyPrefix = "/wirey"
+	etcdWireyPrefix   = "/wirey"
+	etcdAddressPrefix = "/wirey-addresses"
 )
 
+// EtcdBackend stores every peer under /wirey/<ifname>/<publickey>
+// attached to a lease, the lease is kept alive until the peer leaves
+// so that the keys of the crashed nodes expire with it.
 type EtcdBackend struct {
-	client *clientv3.Client
+	client   *clientv3.Client
+	leaseTTL time.Duration
+	mutex    *sync.Mutex
+	leases   map[string]etcdLease
+	// Logger reports the expired leases, StdLogger when nil
+	Logger Logger
 }
 
-func NewEtcdBackend(endpoints []string) (*EtcdBackend, error) {
+type etcdLease struct {
+	id     clientv3.LeaseID
+	cancel context.CancelFunc
+}
+
+func NewEtcdBackend(endpoints []string, leaseTTL time.Duration) (*EtcdBackend, error) {
+	return NewEtcdBackendWithAuth(endpoints, "", "", TLSConfig{}, leaseTTL)
+}
+
+// NewEtcdBackendWithAuth connects to the etcd endpoints like NewEtcdBackend,
+// the username and password are optional, like the tls files, which are
+// checked before connecting.
+func NewEtcdBackendWithAuth(endpoints []string, username, password string, tlsConfig TLSConfig, leaseTTL time.Duration) (*EtcdBackend, error) {
+	if leaseTTL < time.Second {
+		return nil, fmt.Errorf("the etcd lease ttl must be at least one second, got: %s", leaseTTL)
+	}
+	tlsc, err := tlsConfig.Load()
+	if err != nil {
+		return nil, fmt.Errorf("error configuring the etcd tls: %w", err)
+	}
 	cli, err := clientv3.New(clientv3.Config{
 		Endpoints:   endpoints,
 		DialTimeout: 5 * time.Second,
+		TLS:         tlsc,
+		Username:    username,
+		Password:    password,
 	})
 	if err != nil {
 		return nil, err
 	}
 	return &EtcdBackend{
-		client: cli,
+		client:   cli,
+		leaseTTL: leaseTTL,
+		mutex:    &sync.Mutex{},
+		leases:   map[string]etcdLease{},
 	}, nil
 }
 
-func (e *EtcdBackend) Join(ifname string, p Peer) error {
-	pj, err := json.Marshal(p)
+// Ping asks the status of the first endpoint that answers.
+func (e *EtcdBackend) Ping(ctx context.Context) error {
+	var err error
+	for _, endpoint := range e.client.Endpoints() {
+		if _, err = e.client.Status(ctx, endpoint); err == nil {
+			return nil
+		}
+	}
+	if err == nil {
+		return fmt.Errorf("the etcd backend has no endpoints")
+	}
+	return fmt.Errorf("error checking the etcd status: %w", err)
+}
+
+func etcdPeerKey(ifname string, p Peer) string {
+	return fmt.Sprintf("%s/%s/%s", etcdWireyPrefix, ifname, p.PublicKey)
+}
+
+// EntryTTL is the ttl of the lease of the peers.
+func (e *EtcdBackend) EntryTTL() time.Duration {
+	return e.leaseTTL
+}
 
+func (e *EtcdBackend) Join(ctx context.Context, ifname string, p Peer) error {
+	pj, err := MarshalPeer(p)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	key := etcdPeerKey(ifname, p)
+	return e.joinWithLease(ctx, key, func(ctx context.Context, kvc clientv3.KV, lease clientv3.LeaseID) error {
+		_, err := kvc.Put(ctx, key, string(pj), clientv3.WithLease(lease))
+		return err
+	})
+}
+
+// CompareAndJoin claims the address of the peer under /wirey-addresses/<ifname>/<ip>
+// in the same transaction that stores the peer, the claim shares its lease.
+func (e *EtcdBackend) CompareAndJoin(ctx context.Context, ifname string, p Peer) error {
+	pj, err := MarshalPeer(p)
+
+	if err != nil {
+		return err
+	}
+	key := etcdPeerKey(ifname, p)
+	addressKey := fmt.Sprintf("%s/%s/%s", etcdAddressPrefix, ifname, p.IP.String())
+	return e.joinWithLease(ctx, key, func(ctx context.Context, kvc clientv3.KV, lease clientv3.LeaseID) error {
+		res, err := kvc.Get(ctx, addressKey)
+		if err != nil {
+			return err
+		}
+		// the claim must still be missing, or still ours, when committing
+		cmp := clientv3.Compare(clientv3.CreateRevision(addressKey), "=", 0)
+		if len(res.Kvs) > 0 {
+			if string(res.Kvs[0].Value) != string(p.PublicKey) {
+				return AddressTakenError{IP: p.IP.String()}
+			}
+			cmp = clientv3.Compare(clientv3.ModRevision(addressKey), "=", res.Kvs[0].ModRevision)
+		}
+
+		txn, err := kvc.Txn(ctx).If(cmp).Then(
+			clientv3.OpPut(addressKey, string(p.PublicKey), clientv3.WithLease(lease)),
+			clientv3.OpPut(key, string(pj), clientv3.WithLease(lease)),
+		).Commit()
+		if err != nil {
+			return err
+		}
+		if !txn.Succeeded {
+			return AddressTakenError{IP: p.IP.String()}
+		}
+		return nil
+	})
+}
+
+// joinWithLease grants a new lease, calls put to store the keys of the peer
+// with it and keeps it alive, revoking the lease of the previous join. The
+// keepalive outlives ctx, until the peer leaves.
+func (e *EtcdBackend) joinWithLease(ctx context.Context, key string, put func(ctx context.Context, kvc clientv3.KV, lease clientv3.LeaseID) error) error {
+	putCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	lease, err := e.client.Grant(putCtx, int64(e.leaseTTL/time.Second))
+	if err != nil {
+		cancel()
+		return err
+	}
+	err = put(putCtx, clientv3.NewKV(e.client), lease.ID)
+	cancel()
+	if err != nil {
+		// the lease expires anyway when it cannot be revoked
+		e.revoke(context.Background(), etcdLease{id: lease.ID, cancel: func() {}})
+		return err
+	}
+
+	keepaliveCtx, keepaliveCancel := context.WithCancel(context.Background())
+	keepalives, err := e.client.KeepAlive(keepaliveCtx, lease.ID)
+	if err != nil {
+		keepaliveCancel()
+		return err
+	}
+
+	e.mutex.Lock()
+	previous, ok := e.leases[key]
+	e.leases[key] = etcdLease{id: lease.ID, cancel: keepaliveCancel}
+	e.mutex.Unlock()
+
+	// the keys are now attached to the new lease, the old one can go
+	if ok {
+		e.revoke(ctx, previous)
+	}
+
+	go func() {
+		// drain the responses, the channel closes when the keepalive stops
+		for range keepalives {
+		}
+		if keepaliveCtx.Err() == nil {
+			orStdLogger(e.Logger).Errorf("the etcd lease of %s expired, the peer will be removed", key)
+		}
+	}()
+	return nil
+}
+
+func (e *EtcdBackend) revoke(ctx context.Context, l etcdLease) error {
+	l.cancel()
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+	_, err := e.client.Revoke(ctx, l.id)
+	return err
+}
+
+// Leave revokes the lease of the peer, which deletes its key too.
+func (e *EtcdBackend) Leave(ctx context.Context, ifname string, p Peer) error {
+	key := etcdPeerKey(ifname, p)
+
+	e.mutex.Lock()
+	l, ok := e.leases[key]
+	delete(e.leases, key)
+	e.mutex.Unlock()
+
+	if ok {
+		return e.revoke(ctx, l)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	kvc := clientv3.NewKV(e.client)
-	_, err = kvc.Put(ctx, fmt.Sprintf("%s/%s/%s", etcdWireyPrefix, ifname, p.PublicKey), string(pj))
+	_, err := kvc.Delete(ctx, key)
 	cancel()
 	if err != nil {
 		return err
@@ -46,10 +214,22 @@ func (e *EtcdBackend) Join(ifname string, p Peer) error {
 	return nil
 }
 
-func (e *EtcdBackend) GetPeers(ifname string) ([]Peer, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+// Close stops keeping the leases alive, the keys of the peers that did
+// not leave expire with them, and closes the client.
+func (e *EtcdBackend) Close() error {
+	e.mutex.Lock()
+	for key, l := range e.leases {
+		l.cancel()
+		delete(e.leases, key)
+	}
+	e.mutex.Unlock()
+	return e.client.Close()
+}
+
+func (e *EtcdBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	kvc := clientv3.NewKV(e.client)
-	res, err := kvc.Get(ctx, fmt.Sprintf("%s/%s", etcdWireyPrefix, ifname), clientv3.WithPrefix())
+	res, err := kvc.Get(ctx, fmt.Sprintf("%s/%s/", etcdWireyPrefix, ifname), clientv3.WithPrefix())
 	cancel()
 	if err != nil {
 		return nil, err
@@ -57,8 +237,7 @@ func (e *EtcdBackend) GetPeers(ifname string) ([]Peer, error) {
 
 	peers := []Peer{}
 	for _, v := range res.Kvs {
-		peer := Peer{}
-		err = json.Unmarshal(v.Value, &peer)
+		peer, err := UnmarshalPeer(v.Value)
 		if err != nil {
 			return nil, err
 		}
@@ -66,3 +245,56 @@ func (e *EtcdBackend) GetPeers(ifname string) ([]Peer, error) {
 	}
 	return peers, nil
 }
+
+// ListInterfaces reads the keys under /wirey, without their values.
+func (e *EtcdBackend) ListInterfaces(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+	prefix := etcdWireyPrefix + "/"
+	res, err := clientv3.NewKV(e.client).Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, kv := range res.Kvs {
+		names = append(names, interfaceFromKey(string(kv.Key), prefix))
+	}
+	return names, nil
+}
+
+// Watch uses the etcd watch on the interface prefix,
+// the peers are read again on every change.
+func (e *EtcdBackend) Watch(ctx context.Context, ifname string) (<-chan []Peer, error) {
+	peers, err := e.GetPeers(ctx, ifname)
+	if err != nil {
+		return nil, err
+	}
+	wc := e.client.Watch(ctx, fmt.Sprintf("%s/%s/", etcdWireyPrefix, ifname), clientv3.WithPrefix())
+
+	peersc := make(chan []Peer)
+	go func() {
+		defer close(peersc)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case peersc <- peers:
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case res, ok := <-wc:
+				if !ok || res.Canceled || res.Err() != nil {
+					return
+				}
+			}
+
+			peers, err = e.GetPeers(ctx, ifname)
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return peersc, nil
+}
@@ -0,0 +1,180 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+type PeerEventType int
+
+const (
+	PeerAdded PeerEventType = iota
+	PeerRemoved
+	PeerUpdated
+	// PeerSkipped is sent when a peer is left out of the link for its
+	// networks overlapping the ones of another peer, see PeerConflict
+	PeerSkipped
+)
+
+func (t PeerEventType) String() string {
+	switch t {
+	case PeerAdded:
+		return "added"
+	case PeerRemoved:
+		return "removed"
+	case PeerUpdated:
+		return "updated"
+	case PeerSkipped:
+		return "skipped"
+	}
+	return "unknown"
+}
+
+// PeerEvent describes a change of a peer of the interface.
+type PeerEvent struct {
+	Type PeerEventType
+	Peer Peer
+	// Previous is the peer before the change, only set for updates
+	Previous *Peer
+}
+
+// PeerChange is a peer found with a different configuration.
+type PeerChange struct {
+	Previous Peer
+	Current  Peer
+}
+
+// PeerDiff holds what changed between two lists of peers of an interface.
+type PeerDiff struct {
+	Added   []Peer
+	Removed []Peer
+	Changed []PeerChange
+}
+
+// Empty tells if no peer was added, removed or changed.
+func (d PeerDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffPeers compares the previous peers with the current ones, peers are
+// matched by public key and a change of endpoint, ip or allowed ips is
+// reported as a change. The hostname and LastSeen are not
+// compared, as for the peers sha. Every list is sorted by public key.
+func DiffPeers(previous, current []Peer) PeerDiff {
+	prev := map[string]Peer{}
+	for _, p := range previous {
+		prev[string(p.PublicKey)] = p
+	}
+
+	diff := PeerDiff{Added: []Peer{}, Removed: []Peer{}, Changed: []PeerChange{}}
+	seen := map[string]bool{}
+	for _, p := range current {
+		key := string(p.PublicKey)
+		seen[key] = true
+		old, ok := prev[key]
+		if !ok {
+			diff.Added = append(diff.Added, p)
+			continue
+		}
+		if peerChanged(old, p) {
+			diff.Changed = append(diff.Changed, PeerChange{Previous: old, Current: p})
+		}
+	}
+	for _, p := range previous {
+		if !seen[string(p.PublicKey)] {
+			diff.Removed = append(diff.Removed, p)
+		}
+	}
+
+	sortPeers(diff.Added)
+	sortPeers(diff.Removed)
+	sort.SliceStable(diff.Changed, func(i, j int) bool {
+		return bytes.Compare(diff.Changed[i].Current.PublicKey, diff.Changed[j].Current.PublicKey) < 0
+	})
+	return diff
+}
+
+// Events returns the diff as events sorted by public key.
+func (d PeerDiff) Events() []PeerEvent {
+	events := []PeerEvent{}
+	for _, p := range d.Added {
+		events = append(events, PeerEvent{Type: PeerAdded, Peer: p})
+	}
+	for _, p := range d.Removed {
+		events = append(events, PeerEvent{Type: PeerRemoved, Peer: p})
+	}
+	for _, c := range d.Changed {
+		previous := c.Previous
+		events = append(events, PeerEvent{Type: PeerUpdated, Peer: c.Current, Previous: &previous})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return bytes.Compare(events[i].Peer.PublicKey, events[j].Peer.PublicKey) < 0
+	})
+	return events
+}
+
+func peerChanged(a, b Peer) bool {
+	return a.Endpoint != b.Endpoint ||
+		!ipEqual(a.IP, b.IP) ||
+		a.PrefixLen != b.PrefixLen ||
+		a.ManagementAddr != b.ManagementAddr ||
+		a.Gateway != b.Gateway ||
+		strings.Join(a.AllowedIPs, ",") != strings.Join(b.AllowedIPs, ",")
+}
+
+func sortPeers(peers []Peer) {
+	sort.SliceStable(peers, func(i, j int) bool {
+		return bytes.Compare(peers[i].PublicKey, peers[j].PublicKey) < 0
+	})
+}
+
+// peerName is how the logs refer to the peer, its hostname when known.
+func peerName(p Peer) string {
+	key := string(bytes.TrimSpace(p.PublicKey))
+	if len(p.Hostname) > 0 {
+		return fmt.Sprintf("%s (%s)", p.Hostname, key)
+	}
+	return key
+}
+
+// logPeerDiff tells what changed in the peers of the interface.
+func (i *Interface) logPeerDiff(d PeerDiff) {
+	for _, p := range d.Added {
+		i.logger().Infof("Peer %s joined with the address %s at %s", peerName(p), ipString(p.IP), p.Endpoint)
+	}
+	for _, p := range d.Removed {
+		i.logger().Infof("Peer %s left", peerName(p))
+	}
+	for _, c := range d.Changed {
+		i.logger().Infof("Peer %s changed: endpoint %s, address %s, allowed ips %v",
+			peerName(c.Current), c.Current.Endpoint, ipString(c.Current.IP), c.Current.AllowedIPs)
+	}
+}
+
+func ipString(ip *net.IP) string {
+	if ip == nil {
+		return "<none>"
+	}
+	return ip.String()
+}
+
+func ipEqual(a, b *net.IP) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// notifyPeerEvents calls the OnPeerEvent callback, if any, for every event.
+func (i *Interface) notifyPeerEvents(events []PeerEvent) {
+	if i.OnPeerEvent == nil {
+		return
+	}
+	for _, e := range events {
+		i.OnPeerEvent(e)
+	}
+}
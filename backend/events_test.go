@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffPeers(t *testing.T) {
+	previous := []Peer{
+		testPeer("key1", "10.0.0.1", "192.168.1.1:2345"),
+		testPeer("key2", "10.0.0.2", "192.168.1.2:2345"),
+		testPeer("key3", "10.0.0.3", "192.168.1.3:2345"),
+	}
+	current := []Peer{
+		testPeer("key1", "10.0.0.1", "192.168.1.1:2345"),
+		testPeer("key2", "10.0.0.2", "192.168.1.22:2345"),
+		testPeer("key4", "10.0.0.4", "192.168.1.4:2345"),
+	}
+
+	events := DiffPeers(previous, current).Events()
+
+	assert.Len(t, events, 3)
+	assert.Equal(t, PeerUpdated, events[0].Type)
+	assert.Equal(t, current[1], events[0].Peer)
+	assert.Equal(t, previous[1], *events[0].Previous)
+	assert.Equal(t, PeerRemoved, events[1].Type)
+	assert.Equal(t, previous[2], events[1].Peer)
+	assert.Equal(t, PeerAdded, events[2].Type)
+	assert.Equal(t, current[2], events[2].Peer)
+}
+
+func TestDiffPeersFromNothing(t *testing.T) {
+	current := []Peer{testPeer("key1", "10.0.0.1", "192.168.1.1:2345")}
+
+	events := DiffPeers(nil, current).Events()
+
+	assert.Equal(t, []PeerEvent{{Type: PeerAdded, Peer: current[0]}}, events)
+	assert.Empty(t, DiffPeers(current, current).Events())
+}
+
+func TestDiffPeersAdded(t *testing.T) {
+	previous := []Peer{testPeer("key1", "10.0.0.1", "192.168.1.1:2345")}
+	current := []Peer{
+		testPeer("key3", "10.0.0.3", "192.168.1.3:2345"),
+		testPeer("key1", "10.0.0.1", "192.168.1.1:2345"),
+		testPeer("key2", "10.0.0.2", "192.168.1.2:2345"),
+	}
+
+	diff := DiffPeers(previous, current)
+
+	assert.Equal(t, []Peer{current[2], current[0]}, diff.Added)
+	assert.Empty(t, diff.Removed)
+	assert.Empty(t, diff.Changed)
+}
+
+func TestDiffPeersRemoved(t *testing.T) {
+	previous := []Peer{
+		testPeer("key1", "10.0.0.1", "192.168.1.1:2345"),
+		testPeer("key2", "10.0.0.2", "192.168.1.2:2345"),
+	}
+	current := []Peer{testPeer("key2", "10.0.0.2", "192.168.1.2:2345")}
+
+	diff := DiffPeers(previous, current)
+
+	assert.Empty(t, diff.Added)
+	assert.Equal(t, []Peer{previous[0]}, diff.Removed)
+	assert.Empty(t, diff.Changed)
+}
+
+func TestDiffPeersChanged(t *testing.T) {
+	previous := []Peer{
+		testPeer("key1", "10.0.0.1", "192.168.1.1:2345"),
+		testPeer("key2", "10.0.0.2", "192.168.1.2:2345"),
+		testPeer("key3", "10.0.0.3", "192.168.1.3:2345"),
+		testPeer("key4", "10.0.0.4", "192.168.1.4:2345"),
+	}
+	current := []Peer{
+		testPeer("key1", "10.0.0.1", "192.168.1.11:2345"),
+		testPeer("key2", "10.0.0.22", "192.168.1.2:2345"),
+		testPeer("key3", "10.0.0.3", "192.168.1.3:2345"),
+		testPeer("key4", "10.0.0.4", "192.168.1.4:2345"),
+	}
+	current[2].AllowedIPs = []string{"192.168.10.0/24"}
+	// metadata is not a change
+	current[3].Hostname = "node4"
+
+	diff := DiffPeers(previous, current)
+
+	assert.Empty(t, diff.Added)
+	assert.Empty(t, diff.Removed)
+	assert.Equal(t, []PeerChange{
+		{Previous: previous[0], Current: current[0]},
+		{Previous: previous[1], Current: current[1]},
+		{Previous: previous[2], Current: current[2]},
+	}, diff.Changed)
+	assert.False(t, diff.Empty())
+	assert.True(t, DiffPeers(current, current).Empty())
+}
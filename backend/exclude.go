@@ -0,0 +1,57 @@
+package backend
+
+import (
+	"net"
+)
+
+// excludeNetwork returns the smallest set of networks covering network
+// without excluded, ordered by address: network itself when they do not
+// overlap, none when excluded covers it.
+func excludeNetwork(network, excluded *net.IPNet) []*net.IPNet {
+	if len(network.Mask) != len(excluded.Mask) || !overlaps(network, excluded) {
+		return []*net.IPNet{network}
+	}
+	if contains(excluded, network) {
+		return nil
+	}
+	// excluded is within one of the halves of network, the other one is
+	// kept whole and the first one is split further
+	ones, bits := network.Mask.Size()
+	mask := net.CIDRMask(ones+1, bits)
+	low := &net.IPNet{IP: network.IP.Mask(mask), Mask: mask}
+	high := &net.IPNet{IP: append(net.IP{}, low.IP...), Mask: mask}
+	high.IP[ones/8] |= 0x80 >> uint(ones%8)
+	return append(excludeNetwork(low, excluded), excludeNetwork(high, excluded)...)
+}
+
+// excludeNetworks removes every excluded network from the networks.
+func excludeNetworks(networks, excluded []*net.IPNet) []*net.IPNet {
+	for _, e := range excluded {
+		kept := []*net.IPNet{}
+		for _, n := range networks {
+			kept = append(kept, excludeNetwork(n, e)...)
+		}
+		networks = kept
+	}
+	return networks
+}
+
+// excludeIPs removes the ExcludedIPs of the interface from the networks
+// in CIDR notation, the ones not valid are left for the caller to log.
+func (i *Interface) excludeIPs(allowedIPs []string) []string {
+	if len(i.ExcludedIPs) == 0 {
+		return allowedIPs
+	}
+	kept := []string{}
+	for _, a := range allowedIPs {
+		_, network, err := net.ParseCIDR(a)
+		if err != nil {
+			kept = append(kept, a)
+			continue
+		}
+		for _, n := range excludeNetworks([]*net.IPNet{network}, i.ExcludedIPs) {
+			kept = append(kept, n.String())
+		}
+	}
+	return kept
+}
@@ -0,0 +1,89 @@
+package backend
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func networkStrings(networks []*net.IPNet) []string {
+	s := []string{}
+	for _, n := range networks {
+		s = append(s, n.String())
+	}
+	return s
+}
+
+func TestExcludeNetwork(t *testing.T) {
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+	_, lan, _ := net.ParseCIDR("192.168.0.0/16")
+
+	assert.Equal(t, []string{
+		"0.0.0.0/1",
+		"128.0.0.0/2",
+		"192.0.0.0/9",
+		"192.128.0.0/11",
+		"192.160.0.0/13",
+		"192.169.0.0/16",
+		"192.170.0.0/15",
+		"192.172.0.0/14",
+		"192.176.0.0/12",
+		"192.192.0.0/10",
+		"193.0.0.0/8",
+		"194.0.0.0/7",
+		"196.0.0.0/6",
+		"200.0.0.0/5",
+		"208.0.0.0/4",
+		"224.0.0.0/3",
+	}, networkStrings(excludeNetwork(all, lan)))
+
+	_, other, _ := net.ParseCIDR("10.0.0.0/8")
+	assert.Equal(t, []string{"10.0.0.0/8"}, networkStrings(excludeNetwork(other, lan)))
+	_, inside, _ := net.ParseCIDR("192.168.10.0/24")
+	assert.Empty(t, excludeNetwork(inside, lan))
+	_, all6, _ := net.ParseCIDR("::/0")
+	assert.Equal(t, []string{"::/0"}, networkStrings(excludeNetwork(all6, lan)))
+}
+
+func TestExcludeNetworks(t *testing.T) {
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+	excluded := []*net.IPNet{}
+	for _, e := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"} {
+		_, network, _ := net.ParseCIDR(e)
+		excluded = append(excluded, network)
+	}
+
+	kept := excludeNetworks([]*net.IPNet{all}, excluded)
+	for _, e := range []string{"10.1.2.3", "172.20.0.1", "192.168.1.1"} {
+		for _, n := range kept {
+			assert.False(t, n.Contains(net.ParseIP(e)), "%s is in %s", e, n)
+		}
+	}
+	for _, e := range []string{"0.0.0.1", "8.8.8.8", "172.32.0.1", "255.255.255.255"} {
+		covered := false
+		for _, n := range kept {
+			covered = covered || n.Contains(net.ParseIP(e))
+		}
+		assert.True(t, covered, "%s is not covered", e)
+	}
+}
+
+func TestAllowedIPsExcluded(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.0.0/16")
+	i := &Interface{
+		LocalPeer:        testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		AllowedIPsPolicy: AllowedIPsGateway,
+		ExcludedIPs:      []*net.IPNet{lan},
+	}
+	gateway := testPeer("key1", "192.168.0.2", "192.168.1.2:2345")
+	gateway.Gateway = true
+	gateway.AllowedIPs = []string{"192.168.10.0/24", "172.16.0.0/12", "not-a-network"}
+
+	// the tunnel network of the peer is kept even within the excluded ones
+	allowedIPs := i.peerAllowedIPs(gateway)
+	assert.Equal(t, []string{"192.168.0.2/32", "172.16.0.0/12", "0.0.0.0/1"}, allowedIPs[:3])
+	assert.Len(t, allowedIPs, 18)
+	assert.NotContains(t, allowedIPs, "192.168.10.0/24")
+	assert.NotContains(t, allowedIPs, "0.0.0.0/0")
+}
@@ -0,0 +1,204 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// fileLockPoll is the wait between the attempts to take a busy flock.
+const fileLockPoll = 10 * time.Millisecond
+
+// FileBackend stores the peers of every interface as a json array in
+// <dir>/<ifname>.json, e.g: on a single host or on a shared NFS mount.
+// The updates hold an flock on <dir>/<ifname>.lock, so that the wirey
+// processes sharing the directory do not overwrite each other.
+type FileBackend struct {
+	dir string
+}
+
+// NewFileBackend stores the peers in dir, creating it when missing.
+func NewFileBackend(dir string) (*FileBackend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating the directory of the file backend: %w", err)
+	}
+	return &FileBackend{dir: dir}, nil
+}
+
+// Ping checks that the directory is still there, e.g: the NFS share is mounted.
+func (f *FileBackend) Ping(ctx context.Context) error {
+	info, err := os.Stat(f.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("the file backend path %s is not a directory", f.dir)
+	}
+	return nil
+}
+
+func (f *FileBackend) path(ifname string) string {
+	return filepath.Join(f.dir, fmt.Sprintf("%s.json", ifname))
+}
+
+// Join adds the peer to the interface, replacing any peer with the same public key.
+func (f *FileBackend) Join(ctx context.Context, ifname string, p Peer) error {
+	return f.update(ctx, ifname, func(peers []Peer) ([]Peer, error) {
+		return append(removePeer(peers, p), p), nil
+	})
+}
+
+// CompareAndJoin adds the peer unless its address belongs to another peer,
+// the check and the write happen under the lock of the file.
+func (f *FileBackend) CompareAndJoin(ctx context.Context, ifname string, p Peer) error {
+	return f.update(ctx, ifname, func(peers []Peer) ([]Peer, error) {
+		if addressClaimed(peers, p) {
+			return nil, AddressTakenError{IP: p.IP.String()}
+		}
+		return append(removePeer(peers, p), p), nil
+	})
+}
+
+// Leave removes the peer from the interface, leaving with a peer
+// that never joined is not an error.
+func (f *FileBackend) Leave(ctx context.Context, ifname string, p Peer) error {
+	return f.update(ctx, ifname, func(peers []Peer) ([]Peer, error) {
+		return removePeer(peers, p), nil
+	})
+}
+
+// GetPeers reads the peers of the interface, none when the file does not exist yet.
+func (f *FileBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	unlock, err := f.lock(ctx, ifname, syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return f.read(ifname)
+}
+
+func (f *FileBackend) read(ifname string) ([]Peer, error) {
+	data, err := ioutil.ReadFile(f.path(ifname))
+	if os.IsNotExist(err) {
+		return []Peer{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	peers, err := UnmarshalPeers(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the peers in %s: %w", f.path(ifname), err)
+	}
+	return peers, nil
+}
+
+// update replaces the peers of the interface with the ones returned by
+// change, holding the exclusive lock. The file is replaced by renaming
+// a complete copy, so the readers never see a partial write.
+func (f *FileBackend) update(ctx context.Context, ifname string, change func([]Peer) ([]Peer, error)) error {
+	unlock, err := f.lock(ctx, ifname, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	peers, err := f.read(ifname)
+	if err != nil {
+		return err
+	}
+	peers, err = change(peers)
+	if err != nil {
+		return err
+	}
+	data, err := MarshalPeers(peers)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(f.dir, fmt.Sprintf(".%s.json", ifname))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(ifname))
+}
+
+// ListInterfaces lists the <ifname>.json files of the directory.
+func (f *FileBackend) ListInterfaces(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, file := range files {
+		name := file.Name()
+		// the temporary files of the updates start with a dot
+		if file.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(name, ".json"))
+	}
+	return names, nil
+}
+
+// lock takes the flock of the interface, how is LOCK_SH or LOCK_EX. The
+// flock is tried without blocking every fileLockPoll, so that waiting for
+// a process holding it stops with the context.
+func (f *FileBackend) lock(ctx context.Context, ifname string, how int) (func(), error) {
+	path := filepath.Join(f.dir, fmt.Sprintf("%s.lock", ifname))
+	lockfile, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err := syscall.Flock(int(lockfile.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			lockfile.Close()
+			return nil, fmt.Errorf("error locking %s: %w", path, err)
+		}
+		select {
+		case <-ctx.Done():
+			lockfile.Close()
+			return nil, fmt.Errorf("error locking %s: %w", path, ctx.Err())
+		case <-time.After(fileLockPoll):
+		}
+	}
+	return func() {
+		syscall.Flock(int(lockfile.Fd()), syscall.LOCK_UN)
+		lockfile.Close()
+	}, nil
+}
+
+// removePeer returns the peers without the ones with the public key of p.
+func removePeer(peers []Peer, p Peer) []Peer {
+	kept := []Peer{}
+	for _, other := range peers {
+		if !bytes.Equal(other.PublicKey, p.PublicKey) {
+			kept = append(kept, other)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,167 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFileBackend(t *testing.T) (*FileBackend, func()) {
+	dir, err := ioutil.TempDir("", "wirey-file")
+	assert.NoError(t, err)
+	b, err := NewFileBackend(dir)
+	assert.NoError(t, err)
+	return b, func() { os.RemoveAll(dir) }
+}
+
+func TestFileBackendMissingFile(t *testing.T) {
+	b, cleanup := testFileBackend(t)
+	defer cleanup()
+
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Empty(t, peers)
+}
+
+func TestFileBackendFileMode(t *testing.T) {
+	b, cleanup := testFileBackend(t)
+	defer cleanup()
+
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+	info, err := os.Stat(filepath.Join(b.dir, "wg0.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestFileBackendJoinReplacesPeer(t *testing.T) {
+	b, cleanup := testFileBackend(t)
+	defer cleanup()
+
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.2:2345")))
+	assert.NoError(t, b.Join(context.Background(), "wg1", testPeer("key2", "10.0.0.2", "192.168.1.3:2345")))
+
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 1)
+	assert.Equal(t, "192.168.1.2:2345", peers[0].Endpoint)
+
+	// another process sharing the directory sees the same peers
+	other, err := NewFileBackend(b.dir)
+	assert.NoError(t, err)
+	peers, err = other.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 1)
+}
+
+func TestFileBackendKeepsHostname(t *testing.T) {
+	b, cleanup := testFileBackend(t)
+	defer cleanup()
+
+	p := testPeer("key1", "10.0.0.1", "192.168.1.1:2345")
+	p.Hostname = "node1"
+	assert.NoError(t, b.Join(context.Background(), "wg0", p))
+
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Equal(t, "node1", peers[0].Hostname)
+}
+
+func TestFileBackendCompareAndJoin(t *testing.T) {
+	b, cleanup := testFileBackend(t)
+	defer cleanup()
+
+	assert.NoError(t, b.CompareAndJoin(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+	err := b.CompareAndJoin(context.Background(), "wg0", testPeer("key2", "10.0.0.1", "192.168.1.2:2345"))
+	assert.Equal(t, AddressTakenError{IP: "10.0.0.1"}, err)
+}
+
+func TestFileBackendLeave(t *testing.T) {
+	b, cleanup := testFileBackend(t)
+	defer cleanup()
+
+	assert.NoError(t, b.Leave(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("key2", "10.0.0.2", "192.168.1.2:2345")))
+	assert.NoError(t, b.Leave(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 1)
+	assert.Equal(t, []byte("key2"), peers[0].PublicKey)
+}
+
+func TestFileBackendConcurrentJoins(t *testing.T) {
+	b, cleanup := testFileBackend(t)
+	defer cleanup()
+
+	wg := sync.WaitGroup{}
+	for n := 0; n < 20; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			// every join uses its own backend, like separate processes
+			other, _ := NewFileBackend(b.dir)
+			assert.NoError(t, other.Join(context.Background(), "wg0", testPeer(string(rune('a'+n)), "10.0.0.1", "192.168.1.1:2345")))
+		}(n)
+	}
+	wg.Wait()
+
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 20)
+}
+
+func TestFileBackendCorruptedFile(t *testing.T) {
+	b, cleanup := testFileBackend(t)
+	defer cleanup()
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(b.dir, "wg0.json"), []byte("{"), 0644))
+	_, err := b.GetPeers(context.Background(), "wg0")
+	assert.Error(t, err)
+}
+
+func TestFileBackendPing(t *testing.T) {
+	b, cleanup := testFileBackend(t)
+	assert.NoError(t, b.Ping(context.Background()))
+	cleanup()
+	assert.Error(t, b.Ping(context.Background()))
+}
+
+func TestFileBackendListInterfaces(t *testing.T) {
+	b, cleanup := testFileBackend(t)
+	defer cleanup()
+
+	p := testPeer("local", "10.0.0.1", "192.168.1.1:2345")
+	assert.NoError(t, b.Join(context.Background(), "wg1", p))
+	assert.NoError(t, b.Join(context.Background(), "wg0", p))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(b.dir, ".wg2.json123"), []byte("[]"), 0644))
+
+	names, err := ListInterfaces(context.Background(), b)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"wg0", "wg1"}, names)
+}
+
+func TestFileBackendLockContext(t *testing.T) {
+	b, cleanup := testFileBackend(t)
+	defer cleanup()
+
+	// another process holding the lock
+	unlock, err := b.lock(context.Background(), "wg0", syscall.LOCK_EX)
+	assert.NoError(t, err)
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = b.Join(ctx, "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345"))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
@@ -0,0 +1,48 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// HealthHandler serves the probes of the interface from its Status:
+// /healthz answers 200 when the last call to the backend succeeded and the
+// link is up, /readyz answers 200 once the link has been configured,
+// /backendz answers 200 when the backend answers a ping now, /convergedz
+// answers 200 once the peers stopped changing, see Status.Converged.
+func HealthHandler(i *Interface) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		statusProbe(w, i, (*Status).Healthy)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		statusProbe(w, i, (*Status).Ready)
+	})
+	mux.HandleFunc("/convergedz", func(w http.ResponseWriter, r *http.Request) {
+		statusProbe(w, i, func(s *Status) bool { return s.Converged })
+	})
+	mux.HandleFunc("/backendz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), pingTimeout)
+		defer cancel()
+		if err := i.PingBackend(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+func statusProbe(w http.ResponseWriter, i *Interface, check func(*Status) bool) {
+	status, err := i.Status()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !check(status) {
+		http.Error(w, "not ok", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
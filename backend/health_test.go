@@ -0,0 +1,54 @@
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthHandler(t *testing.T) {
+	i := &Interface{
+		Name:      "wireytest0",
+		LocalPeer: testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+	}
+	h := HealthHandler(i)
+
+	probe := func(path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, probe("/readyz"))
+	assert.Equal(t, http.StatusServiceUnavailable, probe("/healthz"))
+
+	peers := []Peer{i.LocalPeer}
+	i.setPeers(peers)
+	i.setPeersSHA(extractPeersSHA(peers))
+	assert.Equal(t, http.StatusOK, probe("/readyz"))
+	// the link does not exist
+	assert.Equal(t, http.StatusServiceUnavailable, probe("/healthz"))
+
+	i.Backend = failingBackend{}
+	assert.Equal(t, http.StatusServiceUnavailable, probe("/backendz"))
+	i.Backend = NewMemoryBackend()
+	assert.Equal(t, http.StatusOK, probe("/backendz"))
+
+	i.ConvergenceCycles = 1
+	assert.Equal(t, http.StatusServiceUnavailable, probe("/convergedz"))
+	i.stableCycle()
+	assert.Equal(t, http.StatusOK, probe("/convergedz"))
+}
+
+func TestStatusHealthy(t *testing.T) {
+	s := &Status{BackendHealthy: true, Up: true}
+	assert.True(t, s.Healthy())
+
+	s.BackendHealthy = false
+	assert.False(t, s.Healthy())
+
+	s = &Status{BackendHealthy: true}
+	assert.False(t, s.Healthy())
+}
@@ -2,9 +2,10 @@ package backend
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
-	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"time"
@@ -18,9 +19,11 @@ type BasicAuth struct {
 }
 
 type HTTPBackend struct {
-	client       *http.Client
-	baseurl      string
-	BasicAuth    *BasicAuth
+	client    *http.Client
+	baseurl   string
+	BasicAuth *BasicAuth
+	// BearerToken is sent in the Authorization header when not empty
+	BearerToken  string
 	wireyVersion string
 }
 
@@ -47,10 +50,10 @@ func publicKeySHA256(key []byte) string {
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
-func (b *HTTPBackend) Join(ifname string, p Peer) error {
+func (b *HTTPBackend) Join(ctx context.Context, ifname string, p Peer) error {
 	joinURL := fmt.Sprintf("%s/%s/%s", b.baseurl, ifname, publicKeySHA256(p.PublicKey))
 
-	jsonPeer, err := json.Marshal(p)
+	jsonPeer, err := MarshalPeer(p)
 	if err != nil {
 		return err
 	}
@@ -62,12 +65,13 @@ func (b *HTTPBackend) Join(ifname string, p Peer) error {
 	}
 	req.Header.Add("Content-Type", "application/json")
 
-	injectCommonHeaders(req, b.wireyVersion, b.BasicAuth)
+	injectCommonHeaders(req, b.wireyVersion, b.BasicAuth, b.BearerToken)
 
-	res, err := b.client.Do(req)
+	res, err := b.client.Do(req.WithContext(ctx))
 	if err != nil {
-		return fmt.Errorf("request error during join: %s", err.Error())
+		return fmt.Errorf("request error during join: %w", err)
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusCreated {
 		return fmt.Errorf("the join http request gave an unexpected status code: %d", res.StatusCode)
@@ -75,7 +79,29 @@ func (b *HTTPBackend) Join(ifname string, p Peer) error {
 	return nil
 }
 
-func (b *HTTPBackend) GetPeers(ifname string) ([]Peer, error) {
+func (b *HTTPBackend) Leave(ctx context.Context, ifname string, p Peer) error {
+	leaveURL := fmt.Sprintf("%s/%s/%s", b.baseurl, ifname, publicKeySHA256(p.PublicKey))
+
+	req, err := http.NewRequest("DELETE", leaveURL, nil)
+	if err != nil {
+		return err
+	}
+
+	injectCommonHeaders(req, b.wireyVersion, b.BasicAuth, b.BearerToken)
+
+	res, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("request error during leave: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("the leave http request gave an unexpected status code: %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (b *HTTPBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
 	getPeersURL := fmt.Sprintf("%s/%s", b.baseurl, ifname)
 
 	req, err := http.NewRequest("GET", getPeersURL, nil)
@@ -83,31 +109,59 @@ func (b *HTTPBackend) GetPeers(ifname string) ([]Peer, error) {
 		return nil, err
 	}
 
-	injectCommonHeaders(req, b.wireyVersion, b.BasicAuth)
+	injectCommonHeaders(req, b.wireyVersion, b.BasicAuth, b.BearerToken)
 
-	res, err := b.client.Do(req)
+	res, err := b.client.Do(req.WithContext(ctx))
 	if err != nil {
-		return nil, fmt.Errorf("request error during get peers: %s", err.Error())
+		return nil, fmt.Errorf("request error during get peers: %w", err)
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("the get peers http request gave an unexpected status code: %d", res.StatusCode)
 	}
 
-	peers := []Peer{}
-	err = json.NewDecoder(res.Body).Decode(&peers)
-
+	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return nil, fmt.Errorf("error decoding peers during get peers: %s", err.Error())
+		return nil, fmt.Errorf("error reading peers during get peers: %w", err)
+	}
+	peers, err := UnmarshalPeers(body)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding peers during get peers: %w", err)
 	}
 
 	return peers, nil
 }
 
-func injectCommonHeaders(req *http.Request, wireyVersion string, basicAuth *BasicAuth) {
+// Ping sends a HEAD to the base url, any answer but a server error or a
+// refused authentication means the server is up.
+func (b *HTTPBackend) Ping(ctx context.Context) error {
+	req, err := http.NewRequest("HEAD", b.baseurl, nil)
+	if err != nil {
+		return err
+	}
+	injectCommonHeaders(req, b.wireyVersion, b.BasicAuth, b.BearerToken)
+
+	res, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("request error during ping: %w", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("the ping http request gave an unexpected status code: %d", res.StatusCode)
+	}
+	return nil
+}
+
+func injectCommonHeaders(req *http.Request, wireyVersion string, basicAuth *BasicAuth, bearerToken string) {
 	req.Header.Add("User-Agent", fmt.Sprintf("%s/%s", httpUserAgent, wireyVersion))
 
 	if basicAuth != nil {
 		req.SetBasicAuth(basicAuth.Username, basicAuth.Password)
 	}
+
+	if len(bearerToken) > 0 {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", bearerToken))
+	}
 }
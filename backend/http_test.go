@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPBackendBearerToken(t *testing.T) {
+	peer := testPeer("key1", "10.0.0.1", "192.168.1.1:2345")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		switch r.Method {
+		case "POST":
+			assert.Equal(t, "/wg0/"+publicKeySHA256(peer.PublicKey), r.URL.Path)
+			w.WriteHeader(http.StatusCreated)
+		case "DELETE":
+			assert.Equal(t, "/wg0/"+publicKeySHA256(peer.PublicKey), r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		case "GET":
+			assert.Equal(t, "/wg0", r.URL.Path)
+			json.NewEncoder(w).Encode([]Peer{peer})
+		}
+	}))
+	defer ts.Close()
+
+	b, err := NewHTTPBackend(ts.URL, "test")
+	assert.NoError(t, err)
+	b.BearerToken = "secret"
+
+	assert.NoError(t, b.Join(context.Background(), "wg0", peer))
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Equal(t, []Peer{peer}, peers)
+	assert.NoError(t, b.Leave(context.Background(), "wg0", peer))
+}
+
+func TestHTTPBackendPing(t *testing.T) {
+	status := http.StatusNotFound
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "HEAD", r.Method)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	b, err := NewHTTPBackend(ts.URL, "test")
+	assert.NoError(t, err)
+	// the server answers, even without a route for the base url
+	assert.NoError(t, b.Ping(context.Background()))
+
+	status = http.StatusUnauthorized
+	assert.EqualError(t, b.Ping(context.Background()), "the ping http request gave an unexpected status code: 401")
+	status = http.StatusBadGateway
+	assert.Error(t, b.Ping(context.Background()))
+}
+
+func TestHTTPBackendContext(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	b, err := NewHTTPBackend(ts.URL, "test")
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = b.GetPeers(ctx, "wg0")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
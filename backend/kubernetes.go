@@ -0,0 +1,242 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubewatch "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	kubernetesConfigMapPrefix = "wirey-"
+	kubernetesTimeout         = 5 * time.Second
+)
+
+// KubernetesBackend stores the peers of every interface in a ConfigMap
+// named wirey-<ifname>, each peer is a data entry keyed by its public key sha.
+type KubernetesBackend struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+// NewKubernetesBackend connects to the api server using the kubeconfig
+// at the provided path, if the path is empty the in cluster service account is used.
+func NewKubernetesBackend(kubeconfig, namespace string) (*KubernetesBackend, error) {
+	var config *rest.Config
+	var err error
+	if len(kubeconfig) == 0 {
+		config, err = rest.InClusterConfig()
+	} else {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading the kubernetes configuration: %w", err)
+	}
+	config.Timeout = kubernetesTimeout
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &KubernetesBackend{
+		client:    client,
+		namespace: namespace,
+	}, nil
+}
+
+// Ping asks the version of the api server.
+func (k *KubernetesBackend) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, kubernetesTimeout)
+	defer cancel()
+	if err := k.client.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error(); err != nil {
+		return fmt.Errorf("error checking the kubernetes api server: %w", err)
+	}
+	return nil
+}
+
+func kubernetesConfigMapName(ifname string) string {
+	return kubernetesConfigMapPrefix + ifname
+}
+
+// updatePeers applies update to the data of the interface ConfigMap,
+// creating it when missing and retrying on conflicting writes.
+func (k *KubernetesBackend) updatePeers(ctx context.Context, ifname string, update func(data map[string]string) error) error {
+	ctx, cancel := context.WithTimeout(ctx, kubernetesTimeout)
+	defer cancel()
+	configMaps := k.client.CoreV1().ConfigMaps(k.namespace)
+	name := kubernetesConfigMapName(ifname)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: k.namespace,
+				},
+				Data: map[string]string{},
+			}
+			if err := update(cm.Data); err != nil {
+				return err
+			}
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			if errors.IsAlreadyExists(err) {
+				// created in the meantime by another peer, retry as a conflict
+				return errors.NewConflict(corev1.Resource("configmaps"), name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		if err := update(cm.Data); err != nil {
+			return err
+		}
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (k *KubernetesBackend) Join(ctx context.Context, ifname string, p Peer) error {
+	pj, err := MarshalPeer(p)
+	if err != nil {
+		return err
+	}
+	return k.updatePeers(ctx, ifname, func(data map[string]string) error {
+		data[publicKeySHA256(p.PublicKey)] = string(pj)
+		return nil
+	})
+}
+
+// CompareAndJoin adds the peer unless its address belongs to another peer,
+// the resource version of the ConfigMap makes the check and the update atomic.
+func (k *KubernetesBackend) CompareAndJoin(ctx context.Context, ifname string, p Peer) error {
+	pj, err := MarshalPeer(p)
+	if err != nil {
+		return err
+	}
+	return k.updatePeers(ctx, ifname, func(data map[string]string) error {
+		peers, err := kubernetesDecodePeers(&corev1.ConfigMap{Data: data})
+		if err != nil {
+			return err
+		}
+		if addressClaimed(peers, p) {
+			return AddressTakenError{IP: p.IP.String()}
+		}
+		data[publicKeySHA256(p.PublicKey)] = string(pj)
+		return nil
+	})
+}
+
+func (k *KubernetesBackend) Leave(ctx context.Context, ifname string, p Peer) error {
+	return k.updatePeers(ctx, ifname, func(data map[string]string) error {
+		delete(data, publicKeySHA256(p.PublicKey))
+		return nil
+	})
+}
+
+func (k *KubernetesBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	ctx, cancel := context.WithTimeout(ctx, kubernetesTimeout)
+	defer cancel()
+	cm, err := k.client.CoreV1().ConfigMaps(k.namespace).Get(ctx, kubernetesConfigMapName(ifname), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return []Peer{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kubernetesDecodePeers(cm)
+}
+
+// ListInterfaces lists the ConfigMaps named wirey-<ifname> with peers.
+func (k *KubernetesBackend) ListInterfaces(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, kubernetesTimeout)
+	defer cancel()
+	list, err := k.client.CoreV1().ConfigMaps(k.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, cm := range list.Items {
+		if strings.HasPrefix(cm.Name, kubernetesConfigMapPrefix) && len(cm.Data) > 0 {
+			names = append(names, strings.TrimPrefix(cm.Name, kubernetesConfigMapPrefix))
+		}
+	}
+	return names, nil
+}
+
+// Watch uses the api server watch on the interface ConfigMap.
+func (k *KubernetesBackend) Watch(ctx context.Context, ifname string) (<-chan []Peer, error) {
+	w, err := k.client.CoreV1().ConfigMaps(k.namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("metadata.name=%s", kubernetesConfigMapName(ifname)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	peersc := make(chan []Peer)
+	go func() {
+		defer close(peersc)
+		defer w.Stop()
+		for {
+			var ev kubewatch.Event
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok = <-w.ResultChan():
+			}
+			if !ok {
+				return
+			}
+
+			peers := []Peer{}
+			switch ev.Type {
+			case kubewatch.Added, kubewatch.Modified:
+				cm, ok := ev.Object.(*corev1.ConfigMap)
+				if !ok {
+					continue
+				}
+				decoded, err := kubernetesDecodePeers(cm)
+				if err != nil {
+					return
+				}
+				peers = decoded
+			case kubewatch.Deleted:
+			default:
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case peersc <- peers:
+			}
+		}
+	}()
+	return peersc, nil
+}
+
+func kubernetesDecodePeers(cm *corev1.ConfigMap) ([]Peer, error) {
+	peers := []Peer{}
+	for _, v := range cm.Data {
+		peer, err := UnmarshalPeer([]byte(v))
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
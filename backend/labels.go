@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const errLabelNotValid = "the label must be key=value, got: %q"
+
+// PeerSelector keeps the peers having all its labels, e.g: region=eu for
+// a mesh per region. An empty selector keeps all the peers.
+type PeerSelector map[string]string
+
+// ParseLabels parses the key=value labels, nil when there are none.
+func ParseLabels(labels []string) (map[string]string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	parsed := map[string]string{}
+	for _, l := range labels {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 {
+			return nil, fmt.Errorf(errLabelNotValid, l)
+		}
+		parsed[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return parsed, nil
+}
+
+// ParsePeerSelector parses comma separated key=value labels,
+// e.g: region=eu,env=prod.
+func ParsePeerSelector(selector string) (PeerSelector, error) {
+	if len(strings.TrimSpace(selector)) == 0 {
+		return nil, nil
+	}
+	labels, err := ParseLabels(strings.Split(selector, ","))
+	return PeerSelector(labels), err
+}
+
+// Matches tells if the peer has all the labels of the selector.
+func (s PeerSelector) Matches(p Peer) bool {
+	for k, v := range s {
+		if value, ok := p.Labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (s PeerSelector) String() string {
+	labels := make([]string, 0, len(s))
+	for k, v := range s {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
+
+// selectedPeers keeps the peers matching the PeerSelector, the gateways,
+// which join the groups together, and the local peer.
+func (i *Interface) selectedPeers(peers []Peer) []Peer {
+	if len(i.PeerSelector) == 0 {
+		return peers
+	}
+	selected := []Peer{}
+	for _, p := range peers {
+		if p.Gateway || i.PeerSelector.Matches(p) || bytes.Equal(p.PublicKey, i.LocalPeer.PublicKey) {
+			selected = append(selected, p)
+			continue
+		}
+		i.logger().Debugf("Leaving out the peer %s, it does not match the selector %s", peerName(p), i.PeerSelector)
+	}
+	return selected
+}
@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"region=eu", " role = db", "empty="})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"region": "eu", "role": "db", "empty": ""}, labels)
+
+	labels, err = ParseLabels(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, labels)
+
+	_, err = ParseLabels([]string{"region"})
+	assert.EqualError(t, err, `the label must be key=value, got: "region"`)
+	_, err = ParseLabels([]string{"=eu"})
+	assert.EqualError(t, err, `the label must be key=value, got: "=eu"`)
+}
+
+func TestPeerSelectorMatches(t *testing.T) {
+	selector, err := ParsePeerSelector("region=eu,env=prod")
+	assert.NoError(t, err)
+	assert.Equal(t, "env=prod,region=eu", selector.String())
+
+	assert.True(t, selector.Matches(Peer{Labels: map[string]string{"region": "eu", "env": "prod", "role": "db"}}))
+	assert.False(t, selector.Matches(Peer{Labels: map[string]string{"region": "eu"}}))
+	assert.False(t, selector.Matches(Peer{Labels: map[string]string{"region": "us", "env": "prod"}}))
+	assert.False(t, selector.Matches(Peer{}))
+
+	empty, err := ParsePeerSelector(" ")
+	assert.NoError(t, err)
+	assert.True(t, empty.Matches(Peer{}))
+
+	_, err = ParsePeerSelector("region=eu,prod")
+	assert.Error(t, err)
+}
+
+func TestSelectedPeers(t *testing.T) {
+	local := testPeer("local", "10.0.0.1", "192.168.1.1:2345")
+	eu := testPeer("eu", "10.0.0.2", "192.168.1.2:2345")
+	eu.Labels = map[string]string{"region": "eu"}
+	us := testPeer("us", "10.0.0.3", "192.168.1.3:2345")
+	us.Labels = map[string]string{"region": "us"}
+	gateway := testPeer("gateway", "10.0.0.4", "192.168.1.4:2345")
+	gateway.Gateway = true
+	peers := []Peer{local, eu, us, gateway}
+
+	i := &Interface{LocalPeer: local}
+	assert.Equal(t, peers, i.selectedPeers(peers))
+
+	i.PeerSelector = PeerSelector{"region": "eu"}
+	assert.Equal(t, []Peer{local, eu, gateway}, i.selectedPeers(peers))
+}
+
+func TestApplyPeerSelector(t *testing.T) {
+	links := newFakeLinkManager()
+	local := testPeer("local", "10.0.0.1", "192.168.1.1:2345")
+	eu := testPeer("eu", "10.0.0.2", "192.168.1.2:2345")
+	eu.Labels = map[string]string{"region": "eu"}
+	us := testPeer("us", "10.0.0.3", "192.168.1.3:2345")
+	i := &Interface{
+		Name:         "wg0",
+		PrefixLen:    24,
+		LocalPeer:    local,
+		LinkManager:  links,
+		PeerSelector: PeerSelector{"region": "eu"},
+	}
+	addr, err := i.localAddr()
+	assert.NoError(t, err)
+
+	assert.NoError(t, i.apply(addr, []Peer{local, eu, us}, 2345))
+	assert.Equal(t, extractPeersSHA([]Peer{local, eu}), i.appliedSHA)
+	conf := links.confs["wg0"]
+	assert.Len(t, conf.Peers, 1)
+	assert.Equal(t, string(eu.PublicKey), conf.Peers[0].PublicKey)
+}
@@ -0,0 +1,49 @@
+package backend
+
+import (
+	"context"
+)
+
+// LegacyBackend is the Backend of the previous releases, whose calls
+// take no context.
+//
+// Deprecated: implement Backend, FromLegacy adapts the old
+// implementations in the meantime.
+type LegacyBackend interface {
+	Join(ifname string, peer Peer) error
+	Leave(ifname string, peer Peer) error
+	GetPeers(ifname string) ([]Peer, error)
+}
+
+// FromLegacy adapts a LegacyBackend to Backend. Its calls cannot be
+// cancelled: when the context is done first the adapter returns the error
+// of the context and the call finishes in the background. The optional
+// interfaces of b, e.g: Watcher, are not forwarded.
+func FromLegacy(b LegacyBackend) Backend {
+	return legacyBackend{b}
+}
+
+type legacyBackend struct {
+	b LegacyBackend
+}
+
+func (l legacyBackend) Join(ctx context.Context, ifname string, p Peer) error {
+	return callWithContext(ctx, func() error { return l.b.Join(ifname, p) })
+}
+
+func (l legacyBackend) Leave(ctx context.Context, ifname string, p Peer) error {
+	return callWithContext(ctx, func() error { return l.b.Leave(ifname, p) })
+}
+
+func (l legacyBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	peersc := make(chan []Peer, 1)
+	err := callWithContext(ctx, func() error {
+		peers, err := l.b.GetPeers(ifname)
+		peersc <- peers
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return <-peersc, nil
+}
@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// oldBackend implements the Backend of the previous releases.
+type oldBackend struct {
+	m       *MemoryBackend
+	release chan struct{}
+}
+
+func (o oldBackend) Join(ifname string, p Peer) error {
+	return o.m.Join(context.Background(), ifname, p)
+}
+
+func (o oldBackend) Leave(ifname string, p Peer) error {
+	return o.m.Leave(context.Background(), ifname, p)
+}
+
+func (o oldBackend) GetPeers(ifname string) ([]Peer, error) {
+	if o.release != nil {
+		<-o.release
+	}
+	return o.m.GetPeers(context.Background(), ifname)
+}
+
+func TestFromLegacy(t *testing.T) {
+	b := FromLegacy(oldBackend{m: NewMemoryBackend()})
+	p := testPeer("key1", "10.0.0.1", "192.168.1.1:2345")
+
+	assert.NoError(t, b.Join(context.Background(), "wg0", p))
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Equal(t, []Peer{p}, peers)
+	assert.NoError(t, b.Leave(context.Background(), "wg0", p))
+	peers, err = b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Empty(t, peers)
+}
+
+func TestFromLegacyContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	b := FromLegacy(oldBackend{m: NewMemoryBackend(), release: release})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	peers, err := b.GetPeers(ctx, "wg0")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Nil(t, peers)
+}
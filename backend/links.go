@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"github.com/influxdata/wirey/pkg/wireguard"
+	"github.com/vishvananda/netlink"
+)
+
+// LinkManager performs the operations on the link that need CAP_NET_ADMIN,
+// the default one calls netlink and wireguard directly. Another one can
+// delegate them, e.g: to a privileged helper, or mock them in the tests.
+type LinkManager interface {
+	LinkByName(name string) (netlink.Link, error)
+	LinkAdd(link netlink.Link) error
+	LinkDel(link netlink.Link) error
+	LinkSetUp(link netlink.Link) error
+	LinkSetMTU(link netlink.Link, mtu int) error
+	AddrList(link netlink.Link, family int) ([]netlink.Addr, error)
+	AddrAdd(link netlink.Link, addr *netlink.Addr) error
+	RouteReplace(route *netlink.Route) error
+	RouteDel(route *netlink.Route) error
+	// SetConf applies the wireguard configuration to the link
+	SetConf(ifname string, conf wireguard.Configuration) error
+}
+
+// AddrDeleter is implemented by the LinkManagers able to delete the
+// addresses of the link, e.g: the stale ones of a link reused after the
+// address changed. Without it the stale addresses are left in place.
+type AddrDeleter interface {
+	AddrDel(link netlink.Link, addr *netlink.Addr) error
+}
+
+// NetlinkLinkManager manages the links of the current network namespace
+// with netlink, and configures wireguard with wireguard.SetConf.
+type NetlinkLinkManager struct {
+	netlink.Handle
+}
+
+func (m *NetlinkLinkManager) SetConf(ifname string, conf wireguard.Configuration) error {
+	_, err := wireguard.SetConf(ifname, conf)
+	return err
+}
+
+// StatsReader is implemented by the LinkManagers able to read the state
+// the kernel holds for the peers of the link: the handshakes and the
+// traffic. It is used to fail over to the next endpoint of the peers that
+// stopped answering and to report the tunnels in Status and the metrics.
+type StatsReader interface {
+	PeerStats(ifname string) ([]wireguard.PeerStats, error)
+}
+
+func (m *NetlinkLinkManager) PeerStats(ifname string) ([]wireguard.PeerStats, error) {
+	return wireguard.Stats(ifname)
+}
+
+// links returns the configured LinkManager or the netlink one when not set.
+func (i *Interface) links() LinkManager {
+	if i.LinkManager == nil {
+		return &NetlinkLinkManager{}
+	}
+	return i.LinkManager
+}
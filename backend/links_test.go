@@ -0,0 +1,502 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/influxdata/wirey/pkg/wireguard"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+// fakeLinkManager keeps the links, addresses and routes in memory.
+type fakeLinkManager struct {
+	mutex      sync.Mutex
+	links      map[string]netlink.Link
+	addrs      map[string][]netlink.Addr
+	routes     map[string]*netlink.Route
+	confs      map[string]wireguard.Configuration
+	setConfErr error
+}
+
+func newFakeLinkManager() *fakeLinkManager {
+	return &fakeLinkManager{
+		links:  map[string]netlink.Link{},
+		addrs:  map[string][]netlink.Addr{},
+		routes: map[string]*netlink.Route{},
+		confs:  map[string]wireguard.Configuration{},
+	}
+}
+
+func (f *fakeLinkManager) LinkByName(name string) (netlink.Link, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if l, ok := f.links[name]; ok {
+		return l, nil
+	}
+	return nil, netlink.LinkNotFoundError{}
+}
+
+func (f *fakeLinkManager) LinkAdd(link netlink.Link) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	link.Attrs().Index = len(f.links) + 1
+	f.links[link.Attrs().Name] = link
+	return nil
+}
+
+func (f *fakeLinkManager) LinkDel(link netlink.Link) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.links, link.Attrs().Name)
+	delete(f.addrs, link.Attrs().Name)
+	return nil
+}
+
+func (f *fakeLinkManager) LinkSetUp(link netlink.Link) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	link.Attrs().Flags |= net.FlagUp
+	return nil
+}
+
+func (f *fakeLinkManager) LinkSetMTU(link netlink.Link, mtu int) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	link.Attrs().MTU = mtu
+	return nil
+}
+
+func (f *fakeLinkManager) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.addrs[link.Attrs().Name], nil
+}
+
+// AddrAdd fails like the kernel for an address already there.
+func (f *fakeLinkManager) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, a := range f.addrs[link.Attrs().Name] {
+		if a.Equal(*addr) {
+			return syscall.EEXIST
+		}
+	}
+	f.addrs[link.Attrs().Name] = append(f.addrs[link.Attrs().Name], *addr)
+	return nil
+}
+
+func (f *fakeLinkManager) AddrDel(link netlink.Link, addr *netlink.Addr) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	kept := []netlink.Addr{}
+	for _, a := range f.addrs[link.Attrs().Name] {
+		if !a.Equal(*addr) {
+			kept = append(kept, a)
+		}
+	}
+	f.addrs[link.Attrs().Name] = kept
+	return nil
+}
+
+func (f *fakeLinkManager) RouteReplace(route *netlink.Route) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.routes[route.Dst.String()] = route
+	return nil
+}
+
+func (f *fakeLinkManager) RouteDel(route *netlink.Route) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.routes, route.Dst.String())
+	return nil
+}
+
+func (f *fakeLinkManager) SetConf(ifname string, conf wireguard.Configuration) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.setConfErr != nil {
+		return f.setConfErr
+	}
+	f.confs[ifname] = conf
+	return nil
+}
+
+func TestConnectWithLinkManager(t *testing.T) {
+	// a free port for the local endpoint
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	assert.NoError(t, err)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	b := NewMemoryBackend()
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("remote", "10.0.0.2", "192.168.1.2:2345")))
+	links := newFakeLinkManager()
+	i := &Interface{
+		Backend:      b,
+		Name:         "wg0",
+		PeerCheckTTL: time.Second,
+		PrefixLen:    24,
+		LocalPeer:    testPeer("local", "10.0.0.1", net.JoinHostPort("192.168.1.1", strconv.Itoa(port))),
+		LinkManager:  links,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- i.Connect(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := i.Status()
+		if err == nil && status.Ready() && status.Up {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the link was not configured")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	links.mutex.Lock()
+	assert.Len(t, links.confs["wg0"].Peers, 1)
+	assert.Equal(t, "remote", links.confs["wg0"].Peers[0].PublicKey)
+	assert.Equal(t, "10.0.0.1/24", links.addrs["wg0"][0].IPNet.String())
+	links.mutex.Unlock()
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 1)
+}
+
+func TestConnectReplacesPreviousRun(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	assert.NoError(t, err)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	// the entry left by a previous run that crashed
+	b := NewMemoryBackend()
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("local", "10.0.0.1", "192.168.1.9:2345")))
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("remote", "10.0.0.2", "192.168.1.2:2345")))
+
+	wctx, wcancel := context.WithCancel(context.Background())
+	defer wcancel()
+	peersc, err := b.Watch(wctx, "wg0")
+	assert.NoError(t, err)
+
+	i := &Interface{
+		Backend:      b,
+		Name:         "wg0",
+		PeerCheckTTL: time.Second,
+		PrefixLen:    24,
+		LocalPeer:    testPeer("local", "10.0.0.1", net.JoinHostPort("192.168.1.1", strconv.Itoa(port))),
+		LinkManager:  newFakeLinkManager(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- i.Connect(ctx) }()
+
+	// what the other peers see while the node joins again
+	for {
+		var peers []Peer
+		select {
+		case peers = <-peersc:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the peer did not join again")
+		}
+		local := []Peer{}
+		for _, p := range peers {
+			if string(p.PublicKey) == "local" {
+				local = append(local, p)
+			}
+		}
+		assert.Len(t, local, 1)
+		if len(local) == 1 && local[0].Endpoint == i.LocalPeer.Endpoint {
+			break
+		}
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
+
+func TestReconcileConverges(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	assert.NoError(t, err)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	b := NewMemoryBackend()
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("remote", "10.0.0.2", "192.168.1.2:2345")))
+	links := newFakeLinkManager()
+	i := &Interface{
+		Backend:     b,
+		Name:        "wg0",
+		PrefixLen:   24,
+		LocalPeer:   testPeer("local", "10.0.0.1", net.JoinHostPort("192.168.1.1", strconv.Itoa(port))),
+		LinkManager: links,
+	}
+
+	assert.NoError(t, i.Reconcile())
+	first := links.confs["wg0"]
+	assert.Len(t, first.Peers, 1)
+
+	// a second pass joins again without a second entry and keeps the link
+	assert.NoError(t, i.Reconcile())
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 2)
+	assert.Equal(t, first, links.confs["wg0"])
+	assert.Len(t, links.links, 1)
+}
+
+// expiringBackend drops the entries of the peers a ttl after the last join.
+type expiringBackend struct {
+	*MemoryBackend
+	ttl time.Duration
+}
+
+func (e expiringBackend) EntryTTL() time.Duration {
+	return e.ttl
+}
+
+func TestReconcileExpiringBackend(t *testing.T) {
+	b := expiringBackend{MemoryBackend: NewMemoryBackend(), ttl: 10 * time.Second}
+	links := newFakeLinkManager()
+	i := &Interface{
+		Backend:     NewMultiBackend(NewMemoryBackend(), b),
+		Name:        "wg0",
+		PrefixLen:   24,
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: links,
+	}
+
+	assert.EqualError(t, i.Reconcile(), "the entries of the backend expire 10s after the last refresh, reconcile once needs a backend keeping them until the peer leaves")
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Empty(t, peers)
+	assert.Empty(t, links.links)
+}
+
+func TestEnsureLink(t *testing.T) {
+	links := newFakeLinkManager()
+	i := &Interface{Name: "wg0", LinkManager: links}
+
+	// missing
+	link, created, err := i.ensureLink()
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.True(t, isWireguardLink(link))
+	assert.Equal(t, defaultMTU, link.Attrs().MTU)
+
+	// already there, with another mtu
+	i.MTU = 1380
+	again, created, err := i.ensureLink()
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, link, again)
+	assert.Equal(t, 1380, again.Attrs().MTU)
+
+	// a leftover link of another type with the same name
+	links.links["wg0"] = &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "wg0"}}
+	link, created, err = i.ensureLink()
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.True(t, isWireguardLink(link))
+	assert.Equal(t, link, links.links["wg0"])
+}
+
+func TestDisconnectKeepsOtherLinks(t *testing.T) {
+	links := newFakeLinkManager()
+	i := &Interface{
+		Backend:     NewMemoryBackend(),
+		Name:        "wg0",
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: links,
+	}
+
+	// nothing to delete
+	assert.NoError(t, i.Disconnect())
+
+	dummy := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "wg0"}}
+	links.links["wg0"] = dummy
+	assert.NoError(t, i.Disconnect())
+	assert.Equal(t, dummy, links.links["wg0"])
+
+	_, _, err := i.ensureLink()
+	assert.NoError(t, err)
+	assert.NoError(t, i.Disconnect())
+	assert.Empty(t, links.links)
+}
+
+func TestDisconnectDeletesLinkWhenLeaveFails(t *testing.T) {
+	links := newFakeLinkManager()
+	i := &Interface{
+		Backend:     failingBackend{},
+		Name:        "wg0",
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: links,
+	}
+	_, _, err := i.ensureLink()
+	assert.NoError(t, err)
+
+	assert.EqualError(t, i.Disconnect(), fmt.Errorf(errLeave, errors.New("unreachable")).Error())
+	assert.Empty(t, links.links)
+}
+
+// closingLinkManager counts the calls to Close
+type closingLinkManager struct {
+	*fakeLinkManager
+	closed int
+}
+
+func (c *closingLinkManager) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestDisconnectClosesLinkManager(t *testing.T) {
+	links := &closingLinkManager{fakeLinkManager: newFakeLinkManager()}
+	i := &Interface{
+		Backend:     NewMemoryBackend(),
+		Name:        "wg0",
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: links,
+	}
+	_, _, err := i.ensureLink()
+	assert.NoError(t, err)
+
+	assert.NoError(t, i.Disconnect())
+	assert.Empty(t, links.links)
+	assert.Equal(t, 1, links.closed)
+}
+
+func TestConfigureLinkReusesExistingLink(t *testing.T) {
+	links := newFakeLinkManager()
+	i := &Interface{
+		Name:        "wg0",
+		PrefixLen:   24,
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: links,
+	}
+	addr, err := i.localAddr()
+	assert.NoError(t, err)
+
+	// a link left down by a previous run, already with the address
+	down := &netlink.GenericLink{
+		LinkAttrs: netlink.LinkAttrs{Name: "wg0", MTU: defaultMTU, Index: 7},
+		LinkType:  wireguardLinkType,
+	}
+	links.links["wg0"] = down
+	links.addrs["wg0"] = []netlink.Addr{*addr}
+
+	peers := []Peer{testPeer("remote", "10.0.0.2", "192.168.1.2:2345")}
+	assert.NoError(t, i.configureLink(addr, peers, 2345))
+	assert.Equal(t, down, links.links["wg0"])
+	assert.Equal(t, 7, links.links["wg0"].Attrs().Index)
+	assert.NotZero(t, down.Attrs().Flags&net.FlagUp)
+	assert.Len(t, links.addrs["wg0"], 1)
+	assert.Len(t, links.confs["wg0"].Peers, 1)
+
+	// already up and connected, nothing is recreated
+	assert.NoError(t, i.configureLink(addr, peers, 2345))
+	assert.Equal(t, down, links.links["wg0"])
+	assert.Len(t, links.addrs["wg0"], 1)
+}
+
+func TestConfigureLinkManagementAddr(t *testing.T) {
+	links := newFakeLinkManager()
+	i := &Interface{
+		Name:        "wg0",
+		PrefixLen:   24,
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: links,
+	}
+	i.LocalPeer.ManagementAddr = "10.99.0.1/24"
+	addr, err := i.localAddr()
+	assert.NoError(t, err)
+
+	peers := []Peer{testPeer("remote", "10.0.0.2", "192.168.1.2:2345")}
+	assert.NoError(t, i.configureLink(addr, peers, 2345))
+	assert.NoError(t, i.configureLink(addr, peers, 2345))
+
+	addrs := []string{}
+	for _, a := range links.addrs["wg0"] {
+		addrs = append(addrs, a.IPNet.String())
+	}
+	assert.Equal(t, []string{"10.0.0.1/24", "10.99.0.1/24"}, addrs)
+	assert.Equal(t, "10.0.0.1/24,10.99.0.1/24", links.confs["wg0"].Interface.Address)
+}
+
+func TestSyncAddrs(t *testing.T) {
+	links := newFakeLinkManager()
+	i := &Interface{
+		Name:        "wg0",
+		PrefixLen:   24,
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: links,
+	}
+	link := &netlink.GenericLink{LinkAttrs: netlink.LinkAttrs{Name: "wg0"}, LinkType: wireguardLinkType}
+	links.links["wg0"] = link
+	addr, err := i.localAddr()
+	assert.NoError(t, err)
+
+	// configured twice, the address is added once
+	assert.NoError(t, i.syncAddrs(link, addr, nil))
+	assert.NoError(t, i.syncAddrs(link, addr, nil))
+	assert.Len(t, links.addrs["wg0"], 1)
+
+	// the prefix length changed, the previous address is stale
+	i.PrefixLen = 16
+	wider, err := i.localAddr()
+	assert.NoError(t, err)
+	linkLocal, err := netlink.ParseAddr("fe80::1/64")
+	assert.NoError(t, err)
+	links.addrs["wg0"] = append(links.addrs["wg0"], *linkLocal)
+	assert.NoError(t, i.syncAddrs(link, wider))
+	assert.Len(t, links.addrs["wg0"], 2)
+	assert.Equal(t, "10.0.0.1/16", links.addrs["wg0"][1].IPNet.String())
+	assert.Equal(t, "fe80::1/64", links.addrs["wg0"][0].IPNet.String())
+
+	// the address added by an operator is kept, the previous one of the
+	// peer is deleted even out of the new network
+	operator, err := netlink.ParseAddr("172.16.0.1/24")
+	assert.NoError(t, err)
+	links.addrs["wg0"] = append(links.addrs["wg0"], *operator)
+	moved, err := netlink.ParseAddr("10.1.0.1/24")
+	assert.NoError(t, err)
+	assert.NoError(t, i.syncAddrs(link, moved))
+	assert.Len(t, links.addrs["wg0"], 3)
+	assert.Equal(t, "fe80::1/64", links.addrs["wg0"][0].IPNet.String())
+	assert.Equal(t, "172.16.0.1/24", links.addrs["wg0"][1].IPNet.String())
+	assert.Equal(t, "10.1.0.1/24", links.addrs["wg0"][2].IPNet.String())
+}
+
+// noAddrDelLinkManager only has the methods of a LinkManager
+type noAddrDelLinkManager struct {
+	LinkManager
+}
+
+func TestSyncAddrsWithoutAddrDeleter(t *testing.T) {
+	links := newFakeLinkManager()
+	i := &Interface{Name: "wg0", LinkManager: noAddrDelLinkManager{links}}
+	link := &netlink.GenericLink{LinkAttrs: netlink.LinkAttrs{Name: "wg0"}, LinkType: wireguardLinkType}
+	stale, err := netlink.ParseAddr("10.0.0.1/24")
+	assert.NoError(t, err)
+	links.addrs["wg0"] = []netlink.Addr{*stale}
+
+	addr, err := netlink.ParseAddr("10.0.0.2/24")
+	assert.NoError(t, err)
+	assert.NoError(t, i.syncAddrs(link, addr))
+	assert.Len(t, links.addrs["wg0"], 2)
+}
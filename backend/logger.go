@@ -0,0 +1,155 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// The formats of the logs of the Config.
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+const errLogFormatNotValid = "the log format must be %s or %s, got: %q"
+
+// Logger is used by Interface to report what it is doing,
+// implement it to route the messages to your own logging stack.
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// StdLogger logs using the standard logger, debug messages
+// are discarded unless Debug is true.
+type StdLogger struct {
+	Debug bool
+}
+
+func (l StdLogger) Debugf(format string, v ...interface{}) {
+	if l.Debug {
+		log.Printf("DEBUG "+format, v...)
+	}
+}
+
+func (l StdLogger) Infof(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+func (l StdLogger) Errorf(format string, v ...interface{}) {
+	log.Printf("ERROR "+format, v...)
+}
+
+// orStdLogger returns l, or a StdLogger when l is nil, the default of the
+// Logger fields of the backends.
+func orStdLogger(l Logger) Logger {
+	if l == nil {
+		return StdLogger{}
+	}
+	return l
+}
+
+// EventLogger is implemented by the Loggers able to record the fields of
+// the main events of the interface, e.g: the sha and the number of the
+// peers on every reconfiguration. The others log the message only.
+type EventLogger interface {
+	Logger
+	Event(event string, fields map[string]interface{})
+}
+
+// JSONLogger writes every message as a line of json with the time, the
+// level, the interface and the message, so that the logs can be shipped
+// to an aggregator as they are. Debug messages are discarded unless
+// Debug is true.
+type JSONLogger struct {
+	Debug     bool
+	Interface string
+	// Output defaults to the stderr
+	Output io.Writer
+}
+
+func (l JSONLogger) Debugf(format string, v ...interface{}) {
+	if l.Debug {
+		l.write("debug", map[string]interface{}{"msg": fmt.Sprintf(format, v...)})
+	}
+}
+
+func (l JSONLogger) Infof(format string, v ...interface{}) {
+	l.write("info", map[string]interface{}{"msg": fmt.Sprintf(format, v...)})
+}
+
+func (l JSONLogger) Errorf(format string, v ...interface{}) {
+	l.write("error", map[string]interface{}{"msg": fmt.Sprintf(format, v...)})
+}
+
+// Event logs the fields with the event, as an error when they hold one.
+func (l JSONLogger) Event(event string, fields map[string]interface{}) {
+	level := "info"
+	if _, ok := fields["error"]; ok {
+		level = "error"
+	}
+	fields["event"] = event
+	l.write(level, fields)
+}
+
+func (l JSONLogger) write(level string, fields map[string]interface{}) {
+	fields["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	fields["level"] = level
+	if len(l.Interface) > 0 {
+		fields["iface"] = l.Interface
+	}
+	line, err := json.Marshal(fields)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"level": "error", "msg": err.Error()})
+	}
+	out := l.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	// a single write per line to not interleave the lines
+	out.Write(append(line, '\n'))
+}
+
+// Write logs p as an info message, so that the JSONLogger can be the
+// output of the standard logger, e.g: log.SetOutput, with no log flags.
+func (l JSONLogger) Write(p []byte) (int, error) {
+	l.Infof("%s", bytes.TrimSpace(p))
+	return len(p), nil
+}
+
+func validateLogFormat(format string) error {
+	switch format {
+	case "", LogText, LogJSON:
+		return nil
+	}
+	return fmt.Errorf(errLogFormatNotValid, LogText, LogJSON, format)
+}
+
+// logEvent logs the event with its fields when the Logger is an
+// EventLogger, otherwise the message formatted as Infof does, or as
+// Errorf when the fields hold an error.
+func (i *Interface) logEvent(event string, fields map[string]interface{}, format string, v ...interface{}) {
+	if l, ok := i.logger().(EventLogger); ok {
+		fields["msg"] = fmt.Sprintf(format, v...)
+		l.Event(event, fields)
+		return
+	}
+	if _, ok := fields["error"]; ok {
+		i.logger().Errorf(format, v...)
+		return
+	}
+	i.logger().Infof(format, v...)
+}
+
+func (i *Interface) logger() Logger {
+	if i.Logger == nil {
+		return StdLogger{}
+	}
+	return i.Logger
+}
@@ -0,0 +1,79 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdLoggerDiscardsDebug(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	i := &Interface{}
+	i.logger().Debugf("idle")
+	assert.Empty(t, buf.String())
+
+	i.logger().Infof("changed")
+	assert.Contains(t, buf.String(), "changed")
+
+	i.Logger = StdLogger{Debug: true}
+	i.logger().Debugf("idle")
+	assert.Contains(t, buf.String(), "DEBUG idle")
+}
+
+func TestJSONLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	i := &Interface{Logger: JSONLogger{Interface: "wg0", Output: buf}}
+
+	i.logger().Debugf("idle")
+	assert.Empty(t, buf.String())
+
+	i.logEvent("reconfigured", map[string]interface{}{"peer_count": 3, "sha": "abc"}, "Link up")
+	i.logEvent("retry", map[string]interface{}{"error": "unreachable"}, "Retry connect, reason: %s", "unreachable")
+	i.logger().Errorf("failed %d times", 2)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if !assert.Len(t, lines, 3) {
+		return
+	}
+	entries := make([]map[string]interface{}, len(lines))
+	for n, line := range lines {
+		assert.NoError(t, json.Unmarshal(line, &entries[n]))
+		assert.Equal(t, "wg0", entries[n]["iface"])
+		assert.NotEmpty(t, entries[n]["time"])
+	}
+	assert.Equal(t, "reconfigured", entries[0]["event"])
+	assert.Equal(t, "info", entries[0]["level"])
+	assert.Equal(t, float64(3), entries[0]["peer_count"])
+	assert.Equal(t, "abc", entries[0]["sha"])
+	assert.Equal(t, "Link up", entries[0]["msg"])
+	assert.Equal(t, "error", entries[1]["level"])
+	assert.Equal(t, "unreachable", entries[1]["error"])
+	assert.Equal(t, "failed 2 times", entries[2]["msg"])
+}
+
+func TestLogEventWithoutEventLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	i := &Interface{}
+	i.logEvent("retry", map[string]interface{}{"error": "unreachable"}, "Retry connect, reason: %s", "unreachable")
+	assert.Contains(t, buf.String(), "ERROR Retry connect, reason: unreachable")
+}
+
+func TestJSONLoggerAsOutput(t *testing.T) {
+	buf := &bytes.Buffer{}
+	std := log.New(JSONLogger{Output: buf}, "", 0)
+	std.Printf("problem during extraction of peers from the backend: %s", "timeout")
+
+	entry := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "problem during extraction of peers from the backend: timeout", entry["msg"])
+}
@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+const errManagementAddrNotValid = "the management address must be in CIDR notation, e.g: 10.99.0.5/24, got: %q"
+
+// validateManagementAddr checks the management address of a peer, if any.
+func validateManagementAddr(addr string) error {
+	if len(addr) == 0 {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(addr); err != nil {
+		return fmt.Errorf(errManagementAddrNotValid, addr)
+	}
+	return nil
+}
+
+// managementHost returns the single address of the management address of
+// the peer, nil when it has none or it is not valid.
+func managementHost(p Peer) *net.IPNet {
+	if len(p.ManagementAddr) == 0 {
+		return nil
+	}
+	ip, _, err := net.ParseCIDR(p.ManagementAddr)
+	if err != nil {
+		return nil
+	}
+	bits := hostBits(ip)
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// managementAddr returns the management address to assign to the link,
+// nil when the local peer has none.
+func (i *Interface) managementAddr() (*netlink.Addr, error) {
+	if len(i.LocalPeer.ManagementAddr) == 0 {
+		return nil, nil
+	}
+	if err := validateManagementAddr(i.LocalPeer.ManagementAddr); err != nil {
+		return nil, err
+	}
+	return netlink.ParseAddr(i.LocalPeer.ManagementAddr)
+}
@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBackend keeps the peers in memory, it is only useful
+// when all the interfaces live in the same process, e.g: in tests.
+// Its calls never block, so the contexts are not used.
+type MemoryBackend struct {
+	mutex    *sync.RWMutex
+	peers    map[string]map[string]Peer
+	watchers map[string][]chan struct{}
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		mutex:    &sync.RWMutex{},
+		peers:    map[string]map[string]Peer{},
+		watchers: map[string][]chan struct{}{},
+	}
+}
+
+// Join adds the peer to the interface, replacing any peer with the same public key.
+func (m *MemoryBackend) Join(ctx context.Context, ifname string, p Peer) error {
+	m.mutex.Lock()
+	if _, ok := m.peers[ifname]; !ok {
+		m.peers[ifname] = map[string]Peer{}
+	}
+	m.peers[ifname][string(p.PublicKey)] = p
+	m.notify(ifname)
+	m.mutex.Unlock()
+	return nil
+}
+
+// CompareAndJoin adds the peer unless its address belongs to another peer.
+func (m *MemoryBackend) CompareAndJoin(ctx context.Context, ifname string, p Peer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	peers := []Peer{}
+	for _, other := range m.peers[ifname] {
+		peers = append(peers, other)
+	}
+	if addressClaimed(peers, p) {
+		return AddressTakenError{IP: p.IP.String()}
+	}
+	if _, ok := m.peers[ifname]; !ok {
+		m.peers[ifname] = map[string]Peer{}
+	}
+	m.peers[ifname][string(p.PublicKey)] = p
+	m.notify(ifname)
+	return nil
+}
+
+// Leave removes the peer from the interface, leaving with a peer
+// that never joined is not an error.
+func (m *MemoryBackend) Leave(ctx context.Context, ifname string, p Peer) error {
+	m.mutex.Lock()
+	if peers, ok := m.peers[ifname]; ok {
+		delete(peers, string(p.PublicKey))
+	}
+	m.notify(ifname)
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *MemoryBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	m.mutex.RLock()
+	peers := []Peer{}
+	for _, p := range m.peers[ifname] {
+		peers = append(peers, p)
+	}
+	m.mutex.RUnlock()
+	return peers, nil
+}
+
+// ListInterfaces returns the interfaces with at least a peer.
+func (m *MemoryBackend) ListInterfaces(ctx context.Context) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	names := []string{}
+	for ifname, peers := range m.peers {
+		if len(peers) > 0 {
+			names = append(names, ifname)
+		}
+	}
+	return names, nil
+}
+
+// Watch sends the peers of the interface every time a peer joins or leaves.
+func (m *MemoryBackend) Watch(ctx context.Context, ifname string) (<-chan []Peer, error) {
+	changed := make(chan struct{}, 1)
+	// the current peers are sent right away
+	changed <- struct{}{}
+
+	m.mutex.Lock()
+	m.watchers[ifname] = append(m.watchers[ifname], changed)
+	m.mutex.Unlock()
+
+	peersc := make(chan []Peer)
+	go func() {
+		defer close(peersc)
+		defer m.unwatch(ifname, changed)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+
+			peers, _ := m.GetPeers(ctx, ifname)
+			select {
+			case <-ctx.Done():
+				return
+			case peersc <- peers:
+			}
+		}
+	}()
+	return peersc, nil
+}
+
+// notify must be called with the lock held.
+func (m *MemoryBackend) notify(ifname string) {
+	for _, changed := range m.watchers[ifname] {
+		select {
+		case changed <- struct{}{}:
+		default:
+			// a notification is already pending
+		}
+	}
+}
+
+func (m *MemoryBackend) unwatch(ifname string, changed chan struct{}) {
+	m.mutex.Lock()
+	watchers := m.watchers[ifname]
+	for i, w := range watchers {
+		if w == changed {
+			m.watchers[ifname] = append(watchers[:i], watchers[i+1:]...)
+			break
+		}
+	}
+	m.mutex.Unlock()
+}
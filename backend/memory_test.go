@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPeer(publicKey, ip, endpoint string) Peer {
+	peerIP := net.ParseIP(ip)
+	return Peer{
+		PublicKey: []byte(publicKey),
+		IP:        &peerIP,
+		Endpoint:  endpoint,
+	}
+}
+
+func TestMemoryBackendJoinReplacesPeer(t *testing.T) {
+	b := NewMemoryBackend()
+
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.2:2345")))
+
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 1)
+	assert.Equal(t, "192.168.1.2:2345", peers[0].Endpoint)
+}
+
+func TestMemoryBackendCompareAndJoin(t *testing.T) {
+	b := NewMemoryBackend()
+
+	assert.NoError(t, b.CompareAndJoin(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+	// the same peer can join again with its own address
+	assert.NoError(t, b.CompareAndJoin(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.3:2345")))
+
+	err := b.CompareAndJoin(context.Background(), "wg0", testPeer("key2", "10.0.0.1", "192.168.1.2:2345"))
+	assert.Equal(t, AddressTakenError{IP: "10.0.0.1"}, err)
+
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 1)
+	assert.Equal(t, "192.168.1.3:2345", peers[0].Endpoint)
+}
+
+func TestMemoryBackendLeave(t *testing.T) {
+	b := NewMemoryBackend()
+
+	assert.NoError(t, b.Leave(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("key2", "10.0.0.2", "192.168.1.2:2345")))
+	assert.NoError(t, b.Leave(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 1)
+	assert.Equal(t, []byte("key2"), peers[0].PublicKey)
+}
+
+func TestMemoryBackendInterfacesAreSeparated(t *testing.T) {
+	b := NewMemoryBackend()
+
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+
+	peers, err := b.GetPeers(context.Background(), "wg1")
+	assert.NoError(t, err)
+	assert.Empty(t, peers)
+}
+
+func TestMemoryBackendWatch(t *testing.T) {
+	b := NewMemoryBackend()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peersc, err := b.Watch(ctx, "wg0")
+	assert.NoError(t, err)
+
+	select {
+	case peers := <-peersc:
+		assert.Empty(t, peers)
+	case <-time.After(time.Second):
+		t.Fatal("the current peers were not sent")
+	}
+
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("key1", "10.0.0.1", "192.168.1.1:2345")))
+
+	select {
+	case peers := <-peersc:
+		assert.Len(t, peers, 1)
+	case <-time.After(time.Second):
+		t.Fatal("the join was not notified")
+	}
+
+	cancel()
+	_, ok := <-peersc
+	assert.False(t, ok)
+}
+
+func TestMemoryBackendListInterfaces(t *testing.T) {
+	b := NewMemoryBackend()
+	p := testPeer("local", "10.0.0.1", "192.168.1.1:2345")
+	assert.NoError(t, b.Join(context.Background(), "wg1", p))
+	assert.NoError(t, b.Join(context.Background(), "wg0", p))
+	assert.NoError(t, b.Join(context.Background(), "wg2", p))
+	assert.NoError(t, b.Leave(context.Background(), "wg2", p))
+
+	names, err := ListInterfaces(context.Background(), b)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"wg0", "wg1"}, names)
+}
+
+func TestListInterfacesNotSupported(t *testing.T) {
+	_, err := ListInterfaces(context.Background(), failingBackend{})
+	assert.EqualError(t, err, "the backend.failingBackend backend cannot list the interfaces")
+}
+
+func TestInterfaceFromKey(t *testing.T) {
+	assert.Equal(t, "wg0", interfaceFromKey("/wirey/wg0/ab/c+d=", "/wirey/"))
+	assert.Equal(t, "wg0", interfaceFromKey("wirey/wg0/", "wirey/"))
+}
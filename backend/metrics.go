@@ -0,0 +1,166 @@
+package backend
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "wirey"
+
+var (
+	peersGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "peers",
+		Help:      "Number of peers returned by the last poll of the backend.",
+	}, []string{"interface"})
+
+	reconciliationsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconciliations_total",
+		Help:      "Number of reconciliation cycles of the peers.",
+	}, []string{"interface"})
+
+	backendErrorsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "backend_errors_total",
+		Help:      "Number of failed calls to the backend.",
+	}, []string{"interface"})
+
+	lastReconfigurationGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_reconfiguration_timestamp_seconds",
+		Help:      "Time of the last successful reconfiguration of the link.",
+	}, []string{"interface"})
+
+	unreachablePeersGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "unreachable_peers",
+		Help:      "Number of peers whose endpoint refused the last probe, 0 without probing.",
+	}, []string{"interface"})
+
+	addressTakenCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "address_taken_total",
+		Help:      "Number of times the peer did not join for its address belonging to another peer.",
+	}, []string{"interface"})
+
+	peersSHAChangedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "peers_sha_changed_timestamp_seconds",
+		Help:      "Time of the last change of the peers the link is configured with.",
+	}, []string{"interface"})
+
+	convergedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "converged",
+		Help:      "1 once the reads of the peers found the same peers for the convergence cycles in a row, 0 otherwise.",
+	}, []string{"interface"})
+
+	buildInfoGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "build_info",
+		Help:      "Always 1, the labels identify the build of wirey.",
+		ConstLabels: prometheus.Labels{
+			"version":    Version,
+			"commit":     Commit,
+			"build_date": BuildDate,
+			"goversion":  runtime.Version(),
+		},
+	}, func() float64 { return 1 })
+
+	peersSHAGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "peers_sha",
+		Help:      "Always 1, the sha label identifies the peers the link is configured with.",
+	}, []string{"interface", "sha"})
+)
+
+var (
+	peerLastHandshakeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "peer", "last_handshake_timestamp_seconds"),
+		"Time of the last handshake with the peer read from the device, 0 if none yet.",
+		[]string{"interface", "public_key"}, nil,
+	)
+	peerReceiveBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "peer", "receive_bytes_total"),
+		"Bytes received from the peer read from the device.",
+		[]string{"interface", "public_key"}, nil,
+	)
+	peerTransmitBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "peer", "transmit_bytes_total"),
+		"Bytes sent to the peer read from the device.",
+		[]string{"interface", "public_key"}, nil,
+	)
+)
+
+// RegisterMetrics registers the wirey collectors to the provided registerer.
+func RegisterMetrics(reg prometheus.Registerer) error {
+	collectors := []prometheus.Collector{
+		peersGauge,
+		reconciliationsCounter,
+		backendErrorsCounter,
+		lastReconfigurationGauge,
+		peersSHAGauge,
+		unreachablePeersGauge,
+		addressTakenCounter,
+		peersSHAChangedGauge,
+		convergedGauge,
+		buildInfoGauge,
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func observeReconfiguration(ifname, previousSHA, sha string) {
+	lastReconfigurationGauge.WithLabelValues(ifname).Set(float64(time.Now().Unix()))
+	peersSHAGauge.DeleteLabelValues(ifname, previousSHA)
+	peersSHAGauge.WithLabelValues(ifname, sha).Set(1)
+}
+
+func observeConvergence(ifname string, changed time.Time, converged bool) {
+	peersSHAChangedGauge.WithLabelValues(ifname).Set(float64(changed.Unix()))
+	if converged {
+		convergedGauge.WithLabelValues(ifname).Set(1)
+	} else {
+		convergedGauge.WithLabelValues(ifname).Set(0)
+	}
+}
+
+// statsCollector reads the state of the tunnels of an interface from the
+// device on every scrape.
+type statsCollector struct {
+	i *Interface
+}
+
+// StatsCollector returns a collector of the handshakes and of the traffic of
+// the peers of the interface, read from the device on every scrape.
+func (i *Interface) StatsCollector() prometheus.Collector {
+	return &statsCollector{i: i}
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- peerLastHandshakeDesc
+	ch <- peerReceiveBytesDesc
+	ch <- peerTransmitBytesDesc
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.i.Observer {
+		return
+	}
+	for _, s := range c.i.peerStats() {
+		var handshake float64
+		if !s.LastHandshake.IsZero() {
+			handshake = float64(s.LastHandshake.Unix())
+		}
+		ch <- prometheus.MustNewConstMetric(peerLastHandshakeDesc, prometheus.GaugeValue, handshake, c.i.Name, s.PublicKey)
+		ch <- prometheus.MustNewConstMetric(peerReceiveBytesDesc, prometheus.CounterValue, float64(s.ReceiveBytes), c.i.Name, s.PublicKey)
+		ch <- prometheus.MustNewConstMetric(peerTransmitBytesDesc, prometheus.CounterValue, float64(s.TransmitBytes), c.i.Name, s.PublicKey)
+	}
+}
@@ -0,0 +1,58 @@
+package backend
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	assert.NoError(t, RegisterMetrics(reg))
+	assert.Error(t, RegisterMetrics(reg))
+}
+
+func TestBuildInfoMetric(t *testing.T) {
+	assert.Equal(t, float64(1), testutil.ToFloat64(buildInfoGauge))
+	expected := `
+		# HELP wirey_build_info Always 1, the labels identify the build of wirey.
+		# TYPE wirey_build_info gauge
+		wirey_build_info{build_date="",commit="",goversion="` + runtime.Version() + `",version="dev"} 1
+	`
+	assert.NoError(t, testutil.CollectAndCompare(buildInfoGauge, strings.NewReader(expected)))
+}
+
+func TestObserveReconfiguration(t *testing.T) {
+	observeReconfiguration("wiretest1", "", "sha1")
+	observeReconfiguration("wiretest1", "sha1", "sha2")
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(peersSHAGauge.WithLabelValues("wiretest1", "sha2")))
+	assert.False(t, peersSHAGauge.DeleteLabelValues("wiretest1", "sha1"))
+}
+
+func TestStatsCollector(t *testing.T) {
+	handshake := time.Unix(1500000000, 0)
+	links := &handshakeLinkManager{
+		fakeLinkManager: newFakeLinkManager(),
+		handshakes:      map[string]time.Time{"peer2": handshake},
+	}
+	i := &Interface{Name: "wg0", LinkManager: links}
+
+	expected := `
+# HELP wirey_peer_last_handshake_timestamp_seconds Time of the last handshake with the peer read from the device, 0 if none yet.
+# TYPE wirey_peer_last_handshake_timestamp_seconds gauge
+wirey_peer_last_handshake_timestamp_seconds{interface="wg0",public_key="peer2"} 1.5e+09
+`
+	err := testutil.CollectAndCompare(i.StatsCollector(), strings.NewReader(expected), "wirey_peer_last_handshake_timestamp_seconds")
+	assert.NoError(t, err)
+
+	// nothing when the device cannot be read
+	links.handshakes = nil
+	err = testutil.CollectAndCompare(i.StatsCollector(), strings.NewReader(""))
+	assert.NoError(t, err)
+}
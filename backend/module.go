@@ -0,0 +1,52 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	errNoWireguardSupport = "%w, load the module with: modprobe wireguard, or run a userspace implementation like wireguard-go: %s"
+	errLoadModule         = "error loading the wireguard module: %w - %s"
+)
+
+// modprobeWireguard loads the wireguard kernel module.
+var modprobeWireguard = func() error {
+	output, err := exec.Command("modprobe", "wireguard").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(errLoadModule, err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+// noWireguardSupport tells if the link could not be created because the
+// kernel does not know the wireguard link type, netlink then answers with
+// EOPNOTSUPP.
+func noWireguardSupport(err error) bool {
+	return errors.Is(err, syscall.EOPNOTSUPP) || strings.Contains(err.Error(), "not supported")
+}
+
+// addLink creates the wireguard link. When the kernel has no wireguard
+// support it loads the module and tries again if LoadModule is set,
+// otherwise the error wraps ErrNoWireguard and tells how to fix it.
+func (i *Interface) addLink(link netlink.Link) error {
+	err := i.links().LinkAdd(link)
+	if err == nil || !noWireguardSupport(err) {
+		return err
+	}
+	if i.LoadModule {
+		i.logger().Infof("The kernel has no wireguard support, loading the module")
+		if loadErr := modprobeWireguard(); loadErr != nil {
+			i.logger().Errorf("%s", loadErr.Error())
+		} else if err = i.links().LinkAdd(link); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf(errNoWireguardSupport, ErrNoWireguard, err.Error())
+}
@@ -0,0 +1,79 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+// moduleLinkManager cannot create wireguard links until the module is loaded.
+type moduleLinkManager struct {
+	*fakeLinkManager
+	loaded bool
+}
+
+func (m *moduleLinkManager) LinkAdd(link netlink.Link) error {
+	if !m.loaded {
+		return fmt.Errorf("operation not supported: %w", syscall.EOPNOTSUPP)
+	}
+	return m.fakeLinkManager.LinkAdd(link)
+}
+
+func TestEnsureLinkWithoutModule(t *testing.T) {
+	links := &moduleLinkManager{fakeLinkManager: newFakeLinkManager()}
+	i := &Interface{Name: "wg0", LinkManager: links}
+
+	_, _, err := i.ensureLink()
+	assert.True(t, errors.Is(err, ErrNoWireguard))
+	assert.Contains(t, err.Error(), "modprobe wireguard")
+	assert.Contains(t, err.Error(), "wireguard-go")
+
+	defer func(f func() error) { modprobeWireguard = f }(modprobeWireguard)
+	modprobeWireguard = func() error {
+		links.loaded = true
+		return nil
+	}
+	i.LoadModule = true
+	_, created, err := i.ensureLink()
+	assert.NoError(t, err)
+	assert.True(t, created)
+}
+
+func TestAddLinkOtherErrors(t *testing.T) {
+	i := &Interface{
+		Name:        "wg0",
+		LinkManager: &failingLinkManager{fakeLinkManager: newFakeLinkManager(), err: syscall.EPERM},
+		LoadModule:  true,
+	}
+	defer func(f func() error) { modprobeWireguard = f }(modprobeWireguard)
+	modprobeWireguard = func() error {
+		t.Fatal("the module is not loaded for the other errors")
+		return nil
+	}
+	err := i.addLink(&netlink.GenericLink{LinkAttrs: netlink.LinkAttrs{Name: "wg0"}, LinkType: wireguardLinkType})
+	assert.Equal(t, syscall.EPERM, err)
+}
+
+func TestConnectWithoutModuleLeaves(t *testing.T) {
+	b := NewMemoryBackend()
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("remote", "10.0.0.2", "192.168.1.2:2345")))
+	i := &Interface{
+		Backend:     b,
+		Name:        "wg0",
+		PrefixLen:   24,
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: &moduleLinkManager{fakeLinkManager: newFakeLinkManager()},
+	}
+
+	err := i.Connect(context.Background())
+	assert.True(t, errors.Is(err, ErrNoWireguard))
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 1)
+	assert.Equal(t, "remote", string(peers[0].PublicKey))
+}
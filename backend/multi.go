@@ -0,0 +1,181 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The write policies of a MultiBackend.
+const (
+	// WriteAll fails the writes unless all the backends succeed
+	WriteAll = "all"
+	// WriteAny fails the writes only when all the backends fail
+	WriteAny = "any"
+)
+
+const (
+	errWritePolicyNotValid = "the write policy must be %s or %s, got: %q"
+	errMultiBackend        = "%d of %d backends failed: %s"
+)
+
+// MultiBackend stores the peers in several backends, so that the outage
+// of one of them does not partition the mesh. Join and Leave are sent to
+// all the backends, GetPeers merges the peers of the backends that answer
+// and fails only when none does.
+type MultiBackend struct {
+	Backends []Backend
+	// WritePolicy is WriteAll or WriteAny, WriteAll when empty
+	WritePolicy string
+	// Logger reports the backends failing while the others answer,
+	// StdLogger when nil
+	Logger Logger
+}
+
+func NewMultiBackend(backends ...Backend) *MultiBackend {
+	return &MultiBackend{Backends: backends, WritePolicy: WriteAll}
+}
+
+func validateWritePolicy(policy string) error {
+	switch policy {
+	case "", WriteAll, WriteAny:
+		return nil
+	}
+	return fmt.Errorf(errWritePolicyNotValid, WriteAll, WriteAny, policy)
+}
+
+func (m *MultiBackend) Join(ctx context.Context, ifname string, p Peer) error {
+	return m.write(func(b Backend) error { return b.Join(ctx, ifname, p) })
+}
+
+func (m *MultiBackend) Leave(ctx context.Context, ifname string, p Peer) error {
+	return m.write(func(b Backend) error { return b.Leave(ctx, ifname, p) })
+}
+
+// GetPeers returns the peers of all the backends, a peer stored in more
+// than one is returned once, with the most recent LastSeen.
+func (m *MultiBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	index := map[string]int{}
+	peers := []Peer{}
+	errs := []string{}
+	for _, b := range m.Backends {
+		bpeers, err := b.GetPeers(ctx, ifname)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for _, p := range bpeers {
+			n, ok := index[string(p.PublicKey)]
+			if !ok {
+				index[string(p.PublicKey)] = len(peers)
+				peers = append(peers, p)
+				continue
+			}
+			if newer(p, peers[n]) {
+				peers[n] = p
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return peers, nil
+	}
+	err := fmt.Errorf(errMultiBackend, len(errs), len(m.Backends), strings.Join(errs, "; "))
+	if len(errs) == len(m.Backends) {
+		return nil, err
+	}
+	orStdLogger(m.Logger).Errorf("problem during extraction of peers from the backend: %s", err.Error())
+	return peers, nil
+}
+
+// Ping fails only when all the backends fail, like GetPeers.
+func (m *MultiBackend) Ping(ctx context.Context) error {
+	errs := []string{}
+	for _, b := range m.Backends {
+		if err := ping(ctx, b); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	err := fmt.Errorf(errMultiBackend, len(errs), len(m.Backends), strings.Join(errs, "; "))
+	if len(errs) == len(m.Backends) {
+		return err
+	}
+	orStdLogger(m.Logger).Errorf("problem pinging the backend: %s", err.Error())
+	return nil
+}
+
+// ListInterfaces merges the interfaces of the backends that are Listers,
+// it fails only when none of them can list them.
+func (m *MultiBackend) ListInterfaces(ctx context.Context) ([]string, error) {
+	names := []string{}
+	errs := []string{}
+	for _, b := range m.Backends {
+		bnames, err := ListInterfaces(ctx, b)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		names = append(names, bnames...)
+	}
+	if len(errs) == len(m.Backends) {
+		return nil, fmt.Errorf(errMultiBackend, len(errs), len(m.Backends), strings.Join(errs, "; "))
+	}
+	return names, nil
+}
+
+// EntryTTL is the shortest one of the backends whose entries expire.
+func (m *MultiBackend) EntryTTL() time.Duration {
+	var shortest time.Duration
+	for _, b := range m.Backends {
+		if ttl := entryTTL(b); ttl > 0 && (shortest == 0 || ttl < shortest) {
+			shortest = ttl
+		}
+	}
+	return shortest
+}
+
+// Close closes the backends that are io.Closers.
+func (m *MultiBackend) Close() error {
+	errs := []string{}
+	for _, b := range m.Backends {
+		if err := closeBackend(b); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf(errMultiBackend, len(errs), len(m.Backends), strings.Join(errs, "; "))
+}
+
+// newer tells if a was seen after b, a peer that is never refreshed
+// is not newer than the other copies.
+func newer(a, b Peer) bool {
+	if a.LastSeen == nil {
+		return false
+	}
+	return b.LastSeen == nil || a.LastSeen.After(*b.LastSeen)
+}
+
+// write calls f on every backend, also after a failure, so that the
+// backends stay in sync as much as possible.
+func (m *MultiBackend) write(f func(Backend) error) error {
+	errs := []string{}
+	for _, b := range m.Backends {
+		if err := f(b); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	err := fmt.Errorf(errMultiBackend, len(errs), len(m.Backends), strings.Join(errs, "; "))
+	if m.WritePolicy == WriteAny && len(errs) < len(m.Backends) {
+		orStdLogger(m.Logger).Errorf("problem writing to the backend: %s", err.Error())
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingBackend struct{}
+
+func (failingBackend) Join(ctx context.Context, ifname string, p Peer) error {
+	return errors.New("unreachable")
+}
+
+func (failingBackend) Leave(ctx context.Context, ifname string, p Peer) error {
+	return errors.New("unreachable")
+}
+
+func (failingBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	return nil, errors.New("unreachable")
+}
+
+func TestMultiBackendGetPeersMerges(t *testing.T) {
+	first, second := NewMemoryBackend(), NewMemoryBackend()
+	old, recent := time.Unix(100, 0), time.Unix(200, 0)
+	stale := testPeer("key1", "10.0.0.1", "192.168.1.1:2345")
+	stale.LastSeen = &old
+	fresh := testPeer("key1", "10.0.0.1", "192.168.1.9:2345")
+	fresh.LastSeen = &recent
+	assert.NoError(t, first.Join(context.Background(), "wg0", fresh))
+	assert.NoError(t, second.Join(context.Background(), "wg0", stale))
+	assert.NoError(t, second.Join(context.Background(), "wg0", testPeer("key2", "10.0.0.2", "192.168.1.2:2345")))
+
+	peers, err := NewMultiBackend(first, second, failingBackend{}).GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 2)
+	for _, p := range peers {
+		if string(p.PublicKey) == "key1" {
+			assert.Equal(t, fresh.Endpoint, p.Endpoint)
+		}
+	}
+
+	_, err = NewMultiBackend(failingBackend{}, failingBackend{}).GetPeers(context.Background(), "wg0")
+	assert.Error(t, err)
+}
+
+func TestMultiBackendWritePolicy(t *testing.T) {
+	p := testPeer("key1", "10.0.0.1", "192.168.1.1:2345")
+
+	ok := NewMemoryBackend()
+	all := NewMultiBackend(ok, failingBackend{})
+	assert.Error(t, all.Join(context.Background(), "wg0", p))
+	// the backends that answer are written anyway
+	peers, _ := ok.GetPeers(context.Background(), "wg0")
+	assert.Len(t, peers, 1)
+
+	any := NewMultiBackend(NewMemoryBackend(), failingBackend{})
+	any.WritePolicy = WriteAny
+	assert.NoError(t, any.Join(context.Background(), "wg0", p))
+	assert.NoError(t, any.Leave(context.Background(), "wg0", p))
+
+	none := NewMultiBackend(failingBackend{}, failingBackend{})
+	none.WritePolicy = WriteAny
+	assert.Error(t, none.Join(context.Background(), "wg0", p))
+}
+
+func TestNewBackendMultiple(t *testing.T) {
+	fb, cleanup := testFileBackend(t)
+	defer cleanup()
+
+	c := &BackendConfig{File: fb.dir}
+	b, err := c.NewBackend("test")
+	assert.NoError(t, err)
+	assert.IsType(t, &FileBackend{}, b)
+
+	c.HTTP = "http://127.0.0.1:8080"
+	c.WritePolicy = WriteAny
+	b, err = c.NewBackend("test")
+	assert.NoError(t, err)
+	if assert.IsType(t, &MultiBackend{}, b) {
+		assert.Len(t, b.(*MultiBackend).Backends, 2)
+		assert.Equal(t, WriteAny, b.(*MultiBackend).WritePolicy)
+	}
+}
+
+func TestMultiBackendPing(t *testing.T) {
+	ctx := context.Background()
+	assert.NoError(t, NewMultiBackend(NewMemoryBackend(), failingBackend{}).Ping(ctx))
+	assert.EqualError(t, NewMultiBackend(failingBackend{}, failingBackend{}).Ping(ctx),
+		fmt.Sprintf(errMultiBackend, 2, 2, "unreachable; unreachable"))
+
+	// the failing backend is reported to the Logger
+	logs := &bytes.Buffer{}
+	m := NewMultiBackend(NewMemoryBackend(), failingBackend{})
+	m.Logger = JSONLogger{Output: logs}
+	assert.NoError(t, m.Ping(ctx))
+	assert.Contains(t, logs.String(), "problem pinging the backend")
+}
+
+func TestMultiBackendListInterfaces(t *testing.T) {
+	first, second := NewMemoryBackend(), NewMemoryBackend()
+	p := testPeer("local", "10.0.0.1", "192.168.1.1:2345")
+	assert.NoError(t, first.Join(context.Background(), "wg0", p))
+	assert.NoError(t, second.Join(context.Background(), "wg0", p))
+	assert.NoError(t, second.Join(context.Background(), "wg1", p))
+
+	names, err := ListInterfaces(context.Background(), NewMultiBackend(first, failingBackend{}, second))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"wg0", "wg1"}, names)
+
+	_, err = ListInterfaces(context.Background(), NewMultiBackend(failingBackend{}))
+	assert.Error(t, err)
+}
+
+func TestMultiBackendEntryTTL(t *testing.T) {
+	assert.Zero(t, NewMultiBackend(NewMemoryBackend(), failingBackend{}).EntryTTL())
+
+	m := NewMultiBackend(
+		NewMemoryBackend(),
+		expiringBackend{MemoryBackend: NewMemoryBackend(), ttl: time.Minute},
+		expiringBackend{MemoryBackend: NewMemoryBackend(), ttl: 10 * time.Second},
+	)
+	assert.Equal(t, 10*time.Second, m.EntryTTL())
+}
@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/influxdata/wirey/pkg/wireguard"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+const (
+	// netnsDir is where ip netns add mounts the named namespaces
+	netnsDir          = "/var/run/netns"
+	errNetnsNotValid  = "error opening the network namespace %s: %w"
+	errNetnsMoveLink  = "error moving the link into the network namespace: %w"
+	errNetnsSwitching = "error switching to the network namespace: %w"
+)
+
+// NetnsLinkManager manages a link living in another network namespace. The
+// link is created in the current namespace and moved into the other one, so
+// that wireguard sends and receives the encrypted packets in the current
+// namespace while the tunnel is only reachable from the other one.
+//
+// Disconnect deletes the link from the namespace, the namespace itself is
+// left in place. Deleting the namespace deletes the link too.
+type NetnsLinkManager struct {
+	*netlink.Handle
+	ns netns.NsHandle
+}
+
+// NewNetnsLinkManager opens the network namespace, a name as given to
+// ip netns add or the path of a namespace, e.g: /proc/<pid>/ns/net.
+// It fails when the namespace does not exist.
+func NewNetnsLinkManager(namespace string) (*NetnsLinkManager, error) {
+	path := netnsPath(namespace)
+	ns, err := netns.GetFromPath(path)
+	if err != nil {
+		return nil, fmt.Errorf(errNetnsNotValid, path, err)
+	}
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		ns.Close()
+		return nil, fmt.Errorf(errNetnsNotValid, path, err)
+	}
+	return &NetnsLinkManager{Handle: h, ns: ns}, nil
+}
+
+// netnsPath returns the path of the named namespaces, the paths as they are.
+func netnsPath(namespace string) string {
+	if strings.Contains(namespace, "/") {
+		return namespace
+	}
+	return filepath.Join(netnsDir, namespace)
+}
+
+// LinkAdd creates the link in the current namespace and moves it into the
+// one of the manager, the index of the link is the one in the namespace.
+func (m *NetnsLinkManager) LinkAdd(link netlink.Link) error {
+	if err := netlink.LinkAdd(link); err != nil {
+		return err
+	}
+	if err := netlink.LinkSetNsFd(link, int(m.ns)); err != nil {
+		netlink.LinkDel(link)
+		return fmt.Errorf(errNetnsMoveLink, err)
+	}
+	moved, err := m.Handle.LinkByName(link.Attrs().Name)
+	if err != nil {
+		return err
+	}
+	link.Attrs().Index = moved.Attrs().Index
+	return nil
+}
+
+func (m *NetnsLinkManager) SetConf(ifname string, conf wireguard.Configuration) error {
+	return m.do(func() error {
+		_, err := wireguard.SetConf(ifname, conf)
+		return err
+	})
+}
+
+func (m *NetnsLinkManager) PeerStats(ifname string) ([]wireguard.PeerStats, error) {
+	var stats []wireguard.PeerStats
+	err := m.do(func() error {
+		var err error
+		stats, err = wireguard.Stats(ifname)
+		return err
+	})
+	return stats, err
+}
+
+// do runs f with a thread switched into the namespace, wireguard is
+// configured through the sockets of the namespace the thread is in. It
+// runs in its own goroutine: a thread that cannot switch back stays
+// locked and the runtime throws it away when the goroutine exits.
+func (m *NetnsLinkManager) do(f func() error) error {
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		origin, err := netns.Get()
+		if err != nil {
+			runtime.UnlockOSThread()
+			errc <- fmt.Errorf(errNetnsSwitching, err)
+			return
+		}
+		defer origin.Close()
+		if err := netns.Set(m.ns); err != nil {
+			runtime.UnlockOSThread()
+			errc <- fmt.Errorf(errNetnsSwitching, err)
+			return
+		}
+
+		ferr := f()
+
+		if err := netns.Set(origin); err != nil {
+			errc <- fmt.Errorf(errNetnsSwitching, err)
+			return
+		}
+		runtime.UnlockOSThread()
+		errc <- ferr
+	}()
+	return <-errc
+}
+
+// Close releases the namespace and the netlink socket in it, Disconnect
+// calls it once the link is deleted.
+func (m *NetnsLinkManager) Close() error {
+	m.Handle.Delete()
+	return m.ns.Close()
+}
@@ -0,0 +1,27 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetnsPath(t *testing.T) {
+	assert.Equal(t, "/var/run/netns/tenant1", netnsPath("tenant1"))
+	assert.Equal(t, "/proc/1/ns/net", netnsPath("/proc/1/ns/net"))
+}
+
+func TestNewNetnsLinkManager(t *testing.T) {
+	_, err := NewNetnsLinkManager("wireytest-missing")
+	assert.Error(t, err)
+
+	// the namespace of the test itself
+	m, err := NewNetnsLinkManager("/proc/self/ns/net")
+	if err != nil {
+		t.Skipf("cannot open the network namespace: %s", err.Error())
+	}
+	defer m.Close()
+	link, err := m.LinkByName("lo")
+	assert.NoError(t, err)
+	assert.Equal(t, "lo", link.Attrs().Name)
+}
@@ -0,0 +1,57 @@
+package backend
+
+import (
+	"context"
+	"time"
+)
+
+// observe watches the peers of the interface without joining the backend
+// or touching the link, it keeps the status, the metrics and the peer
+// events up to date until the context is done.
+func (i *Interface) observe(ctx context.Context) error {
+	peersc, stopWatch, err := i.startWatch(ctx)
+	defer func() { stopWatch() }()
+	if err != nil {
+		i.backendFailed()
+		return i.retryConnection(ctx, err.Error())
+	}
+
+	var observedPeers []Peer
+	for {
+		var peers []Peer
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-i.reloadc():
+			i.applyPending(ctx)
+			stopWatch()
+			peersc, stopWatch, err = i.startWatch(ctx)
+			if err != nil {
+				i.backendFailed()
+				return i.retryConnection(ctx, err.Error())
+			}
+			continue
+		case peers, ok = <-peersc:
+		}
+		if !ok {
+			i.backendFailed()
+			return i.retryConnection(ctx, "the watch of the peers in the backend stopped")
+		}
+		peers = freshPeers(i.withoutPreviousKeys(dedupPeers(i.trustedPeers(i.authorizedPeers(peers)))), time.Now(), i.PeerStaleness)
+		i.setPeers(peers)
+		i.retries = 0
+		reconciliationsCounter.WithLabelValues(i.Name).Inc()
+		peersGauge.WithLabelValues(i.Name).Set(float64(len(peers)))
+
+		diff := DiffPeers(observedPeers, peers)
+		if observedPeers != nil && diff.Empty() {
+			i.stableCycle()
+			continue
+		}
+		i.setPeersSHA(extractPeersSHA(peers))
+		i.logPeerDiff(diff)
+		i.notifyPeerEvents(diff.Events())
+		observedPeers = peers
+	}
+}
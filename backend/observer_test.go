@@ -0,0 +1,72 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserverDoesNotJoin(t *testing.T) {
+	b := NewMemoryBackend()
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("remote", "10.0.0.2", "192.168.1.2:2345")))
+	links := newFakeLinkManager()
+
+	mutex := sync.Mutex{}
+	events := []PeerEvent{}
+	i := &Interface{
+		Backend:      b,
+		Name:         "wg0",
+		PeerCheckTTL: time.Second,
+		Observer:     true,
+		LinkManager:  links,
+		OnPeerEvent: func(e PeerEvent) {
+			mutex.Lock()
+			events = append(events, e)
+			mutex.Unlock()
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- i.Connect(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := i.Status()
+		assert.NoError(t, err)
+		if status.Ready() {
+			assert.True(t, status.Healthy())
+			assert.Len(t, status.Peers, 1)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the peers were not observed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("other", "10.0.0.3", "192.168.1.3:2345")))
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		mutex.Lock()
+		n := len(events)
+		mutex.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the join was not observed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Empty(t, links.links)
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 2)
+}
@@ -0,0 +1,146 @@
+package backend
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/influxdata/wirey/pkg/wireguard"
+)
+
+// Option configures the interface created by NewInterfaceWithOptions.
+type Option func(*options)
+
+type options struct {
+	endpoint         string
+	ipaddr           string
+	privateKeyPath   string
+	presharedKeyPath string
+	privateKey       []byte
+	keyGiven         bool
+	presharedKey     []byte
+	peerCheckTTL     time.Duration
+	prefixLen        int
+	mtu              int
+	logger           Logger
+	strictKeys       bool
+}
+
+// WithEndpoint sets the address, in the host:port format, the other
+// peers reach the local one at.
+func WithEndpoint(endpoint string) Option {
+	return func(o *options) { o.endpoint = endpoint }
+}
+
+// WithIP sets the address of the local peer in the tunnel, or the pool
+// in CIDR notation the address is allocated from when connecting.
+func WithIP(ipaddr string) Option {
+	return func(o *options) { o.ipaddr = ipaddr }
+}
+
+// WithPrivateKeyPath loads the private key stored at path, a new key is
+// generated there when the file does not exist.
+func WithPrivateKeyPath(path string) Option {
+	return func(o *options) { o.privateKeyPath = path }
+}
+
+// WithPresharedKeyPath loads the optional preshared key stored at path,
+// a new key is generated there when the file does not exist.
+func WithPresharedKeyPath(path string) Option {
+	return func(o *options) { o.presharedKeyPath = path }
+}
+
+// WithPrivateKey uses the base64 private key, as printed by wg genkey,
+// instead of a key file.
+func WithPrivateKey(key []byte) Option {
+	return func(o *options) {
+		o.privateKey = bytes.TrimSpace(key)
+		o.keyGiven = true
+	}
+}
+
+// WithPresharedKey uses the base64 preshared key instead of a key file.
+func WithPresharedKey(key []byte) Option {
+	return func(o *options) { o.presharedKey = bytes.TrimSpace(key) }
+}
+
+// WithPrefix sets the PrefixLen of the tunnel network, instead of the
+// default of the address family.
+func WithPrefix(prefixLen int) Option {
+	return func(o *options) { o.prefixLen = prefixLen }
+}
+
+// WithMTU sets the MTU of the link.
+func WithMTU(mtu int) Option {
+	return func(o *options) { o.mtu = mtu }
+}
+
+// WithReconcileInterval sets the PeerCheckTTL, the interval between the
+// reads of the peers in the backend.
+func WithReconcileInterval(ttl time.Duration) Option {
+	return func(o *options) { o.peerCheckTTL = ttl }
+}
+
+// WithStrictKeys refuses the key files accessible by the group or the
+// others, like ssh does, instead of logging them.
+func WithStrictKeys() Option {
+	return func(o *options) { o.strictKeys = true }
+}
+
+// WithLogger sets the Logger of the interface.
+func WithLogger(l Logger) Option {
+	return func(o *options) { o.logger = l }
+}
+
+// NewInterfaceWithOptions creates the interface as configured by the
+// options, the endpoint and the address are required. The key is taken
+// from WithPrivateKey when given, otherwise it is loaded or generated
+// like NewInterface does. The other fields can still be set afterwards.
+func NewInterfaceWithOptions(b Backend, ifname string, opts ...Option) (*Interface, error) {
+	o := &options{peerCheckTTL: defaultPeerCheckTTL}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	i, err := newInterface(b, ifname, o.endpoint, o.ipaddr, o.peerCheckTTL)
+	if err != nil {
+		return nil, err
+	}
+	i.Logger = o.logger
+
+	privKey := o.privateKey
+	if !o.keyGiven {
+		privKey, err = loadOrGenerateKey(o.privateKeyPath, wireguard.Genkey, o.strictKeys, i.logger())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the preshared key is optional
+	psk := o.presharedKey
+	if len(psk) == 0 && len(o.presharedKeyPath) > 0 {
+		psk, err = loadOrGenerateKey(o.presharedKeyPath, wireguard.Genpsk, o.strictKeys, i.logger())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := i.setKeys(privKey, psk); err != nil {
+		return nil, err
+	}
+
+	// the link of a previous run is found by its key
+	if i.Name == AutoIfname {
+		i.Name, err = autoLinkName(defaultIfnameBase, i.LocalPeer.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.prefixLen > 0 {
+		i.PrefixLen = o.prefixLen
+	}
+	if o.mtu > 0 {
+		i.MTU = o.mtu
+	}
+	return i, nil
+}
@@ -0,0 +1,63 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions(t *testing.T) {
+	o := &options{}
+	for _, opt := range []Option{
+		WithEndpoint("192.168.1.1:2345"),
+		WithIP("10.0.0.1"),
+		WithPrivateKeyPath("/etc/wirey/privkey"),
+		WithPresharedKey([]byte("psk\n")),
+		WithPrefix(16),
+		WithMTU(1380),
+		WithReconcileInterval(time.Minute),
+		WithLogger(StdLogger{Debug: true}),
+	} {
+		opt(o)
+	}
+	assert.Equal(t, &options{
+		endpoint:       "192.168.1.1:2345",
+		ipaddr:         "10.0.0.1",
+		privateKeyPath: "/etc/wirey/privkey",
+		presharedKey:   []byte("psk"),
+		peerCheckTTL:   time.Minute,
+		prefixLen:      16,
+		mtu:            1380,
+		logger:         StdLogger{Debug: true},
+	}, o)
+}
+
+func TestNewInterfaceWithOptionsInvalid(t *testing.T) {
+	_, err := NewInterfaceWithOptions(NewMemoryBackend(), "wg0", WithIP("10.0.0.1"))
+	assert.True(t, errors.Is(err, ErrInvalidEndpoint))
+
+	_, err = NewInterfaceWithOptions(NewMemoryBackend(), "wg0", WithEndpoint("192.168.1.1:2345"))
+	assert.True(t, errors.Is(err, ErrInvalidIP))
+
+	dir, err := ioutil.TempDir("", "wirey")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "privkey")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k="), 0644))
+
+	_, err = NewInterfaceWithOptions(
+		NewMemoryBackend(),
+		"wg0",
+		WithEndpoint("192.168.1.1:2345"),
+		WithIP("10.0.0.1"),
+		WithPrivateKeyPath(path),
+		WithStrictKeys(),
+	)
+	assert.EqualError(t, err, fmt.Sprintf(errKeyPermissions, path, 0644))
+}
@@ -2,16 +2,20 @@ package backend
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
-	"encoding/json"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/influxdata/wirey/pkg/wireguard"
@@ -19,267 +23,1330 @@ import (
 )
 
 const (
-	ifnamesiz  = 16
-	maxretries = 5
-	retryttl   = time.Second * 5
+	ifnamesiz        = 16
+	maxretries       = 5
+	retryttl         = time.Second * 5
+	maxRetryBackoff  = time.Minute * 2
+	defaultPrefixLen = 24
+	defaultMTU       = 1420
+	// defaultPeerCheckTTL is the default of the peerdiscoveryttl flag
+	defaultPeerCheckTTL = time.Second * 30
+	// minMTU is the minimum MTU that can carry IPv6 through the tunnel
+	minMTU = 1280
+	// defaultIPv6PrefixLen is the prefix length of an IPv6 tunnel address
+	defaultIPv6PrefixLen = 64
+	// defaultConvergenceCycles is the default of the convergencecycles flag
+	defaultConvergenceCycles = 3
+)
+
+const wireguardLinkType = "wireguard"
+
+const (
+	// AutoIfname as the interface name picks the wgN link left with the
+	// same key by a previous run, or the first free wgN
+	AutoIfname = "auto"
+	// defaultIfnameBase is the base of the names picked by AutoIfname
+	defaultIfnameBase = "wg"
 )
 
 const (
 	errMaxRetriesReached      = "maximum number of connection retries reached"
-	errEndpointFormatNotValid = "endpoint must be in format <ip>:<port>, like 192.168.1.3:3459"
-	errInvalidEndpoint        = "endpoint provided is not valid"
-	errInterfaceNameLength    = "the interface name size cannot be more than " + string(ifnamesiz)
-	errPrivateKeyWriting      = "error writing private key file: %s"
-	errPrivateKeyOpening      = "error opening private key file: %s"
-	errAddressAlreadyTaken    = "address already taken: %s"
-	errAddLink                = "error adding the wireguard link: %s"
-	errIntConversionPort      = "error during port conversion to int: %s"
+	errEndpointFormatNotValid = "%w, it must be in format <host>:<port>, like 192.168.1.3:3459 or [2001:db8::1]:3459"
+	errIPNotValid             = "%w: %q"
+	errInterfaceNameLength    = "the interface name size cannot be more than %d"
+	errPrivateKeyWriting      = "error writing key file: %w"
+	errPrivateKeyOpening      = "error opening key file: %w"
+	errKeyPermissions         = "the key file %s is accessible by other users with mode %#o, restrict it with chmod 600"
+	errAddLink                = "error adding the wireguard link: %w"
+	errIntConversionPort      = "error during port conversion to int: %w"
+	errLeave                  = "error leaving the backend: %w"
+	errLeaveAndDelLink        = "error leaving the backend: %w; %s"
+	errDelLink                = "error deleting the wireguard link: %w"
+	errGetLink                = "error getting the wireguard link: %w"
+	errAddAddr                = "error adding the address %s to the wireguard link: %w"
+	errDelAddr                = "error deleting the address %s from the wireguard link: %w"
+	errListAddrs              = "error listing the addresses of the wireguard link: %w"
+	errMTUNotValid            = "the mtu cannot be less than %d, got: %d"
+	errMaxRetriesNotValid     = "the maximum number of retries cannot be negative, got: %d"
+	errSetMTU                 = "error setting the mtu of the wireguard link: %w"
+	errListenPortNotValid     = "the listen port must be between 1 and 65535, got: %d"
+	errPortNotValid           = "%w, the port must be a number between 1 and 65535, got: %q"
+	errPortInUse              = "the udp port %d is already in use, stop the process using it or set another listen port: %w"
+	errPrefixLenNotValid      = "prefix length not valid for address %s: %d"
+	errPeerCheckTTLNotValid   = "the peer check ttl must be positive, got: %s"
+	errListLinks              = "error listing the links: %w"
+	errReconcileExpires       = "the entries of the backend expire %s after the last refresh, reconcile once needs a backend keeping them until the peer leaves"
+)
+
+// The errors callers can tell apart with errors.Is, the returned errors
+// wrap them with the details.
+var (
+	// ErrAddressTaken is returned when the address of the local peer
+	// belongs to another peer of the interface
+	ErrAddressTaken = errors.New("address already taken")
+	// ErrInvalidEndpoint is returned for an endpoint not in format <host>:<port>
+	ErrInvalidEndpoint = errors.New("endpoint provided is not valid")
+	// ErrInvalidIP is returned for an address of the tunnel not valid
+	ErrInvalidIP = errors.New("the ip address provided is not valid")
+	// ErrNoWireguard is returned when the kernel cannot create wireguard
+	// links, e.g: the module is not loaded
+	ErrNoWireguard = errors.New("the kernel has no wireguard support")
 )
 
 type Peer struct {
 	PublicKey []byte
 	Endpoint  string
 	IP        *net.IP
+	// AllowedIPs are the subnets, in CIDR notation, routed through this
+	// peer in addition to its own IP, e.g: the LAN behind a gateway node.
+	AllowedIPs []string `json:",omitempty"`
+	// Hostname is the name of the machine of the peer, only meant
+	// for humans, NewInterface defaults it to os.Hostname.
+	Hostname string `json:",omitempty"`
+	// LastSeen is refreshed by the peers with a PeerStaleness,
+	// it is nil for the others.
+	LastSeen *time.Time `json:",omitempty"`
+	// PrefixLen, when set, makes the peer own the whole subnet of its IP
+	// with this prefix length instead of the single address, e.g: a peer
+	// on a bigger subnet than the others. It must fit the address family.
+	PrefixLen int `json:",omitempty"`
+	// Endpoints are the other addresses the peer is reachable at, in
+	// order of priority after Endpoint, e.g: a wired and an LTE address.
+	// The peers fail over to the next one when the handshakes stop.
+	Endpoints []string `json:",omitempty"`
+	// ManagementAddr is an optional second address of the peer in CIDR
+	// notation, e.g: for a control plane reaching the nodes at a stable
+	// address apart from IP. The peer assigns it to its link and the others
+	// route its single address through the tunnel.
+	ManagementAddr string `json:",omitempty"`
+	// Gateway makes the other peers send all their traffic through this
+	// one, when their AllowedIPsPolicy is AllowedIPsGateway.
+	Gateway bool `json:",omitempty"`
+	// Labels group the peers, e.g: region=eu, for the PeerSelector of
+	// the other peers.
+	Labels map[string]string `json:",omitempty"`
+	// PreviousPublicKey is the key the peer is rotating from, the other
+	// peers drop the entry with that key in favour of this one.
+	PreviousPublicKey []byte `json:",omitempty"`
+	// Signature, set by SignPeer, covers all the other fields of the peer.
+	Signature []byte `json:",omitempty"`
 }
 
 type Interface struct {
-	Backend      Backend
-	Name         string
+	Backend Backend
+	Name    string
+	// PeerCheckTTL is the interval between the reads of the peers in the
+	// backend, it defaults to 30 seconds. The backends able to watch push
+	// the changes as they happen, for them it bounds the staleness of the
+	// peers when a change is missed.
 	PeerCheckTTL time.Duration
-	LocalPeer    Peer
-	privateKey   []byte
+	// PeerCheckMaxTTL, when greater than PeerCheckTTL, lets the interval
+	// between the reads grow by half on every read without changes up to
+	// it, so that large and stable meshes load the backend less. Any change
+	// resets it to PeerCheckTTL.
+	PeerCheckMaxTTL time.Duration
+	LocalPeer       Peer
+	// PrefixLen is the prefix length of the tunnel network the local
+	// address is assigned to, NewInterface sets it to 24 for IPv4 and to
+	// 64 for IPv6 addresses.
+	PrefixLen int
+	// MTU of the wireguard link, it defaults to 1420 and cannot be less than 1280.
+	MTU int
+	// ListenPort is the local port wireguard binds to, it defaults to the
+	// port of the LocalPeer endpoint. Set it when the advertised endpoint
+	// is forwarded to a different port, e.g: behind NAT.
+	// Wireguard binds it on every local address.
+	ListenPort int
+	// Pool, when set, is the network the local address is allocated from
+	// when connecting, the allocated address is stored in LocalPeer.IP.
+	Pool *net.IPNet
+	// Allocation tells which free address of the Pool is claimed, it
+	// defaults to AllocateLowest.
+	Allocation AllocationStrategy
+	// AllowedIPsPolicy tells which networks the peers are allowed to send
+	// traffic from, it defaults to AllowedIPsMesh.
+	AllowedIPsPolicy AllowedIPsPolicy
+	// AllowedIPsFunc returns the allowed ips of a peer in CIDR notation
+	// with the AllowedIPsCustom policy.
+	AllowedIPsFunc func(Peer) []string
+	// ExcludedIPs are cut out of the subnets advertised by the peers, the
+	// default route of the gateways included, e.g: to keep the traffic to
+	// the local network out of a full tunnel. The tunnel networks of the
+	// peers are never excluded.
+	ExcludedIPs []*net.IPNet
+	// PeerSelector limits the link to the peers with its Labels, and
+	// to the gateways, for a partial mesh. Empty keeps all the peers.
+	PeerSelector PeerSelector
+	// PersistentKeepalive is the interval in seconds between keepalive packets
+	// sent to every peer, 0 disables it. Nodes behind NAT usually want 25.
+	PersistentKeepalive int
+	// FwMark marks the packets sent by wireguard, so that policy routing
+	// can keep them out of the tunnel, 0 means unset.
+	FwMark int
+	// LoadModule makes Connect load the wireguard kernel module with
+	// modprobe when the kernel cannot create the link.
+	LoadModule bool
+	// RouteTable is the id of the routing table the routes through the link
+	// are installed in, 0 is the main table. Use it with ip rules for split
+	// tunnels, e.g: matching the FwMark.
+	RouteTable int
+	// DNS, when set, are the dns servers the system uses while the link
+	// is up, e.g: for a full tunnel. It needs a LinkManager that is a
+	// Resolver, Disconnect reverts them.
+	DNS []net.IP
+	// PeerStaleness, when set, makes Connect refresh the LastSeen of the
+	// local peer and leave out the peers not seen for longer, e.g: nodes
+	// that died without leaving. It must be the same on all the peers.
+	PeerStaleness time.Duration
+	// PeerDebounce, when set, coalesces the changes of the peers received
+	// within it into one configuration of the link, so that churn does
+	// not reconfigure it on every change. A change waits at most that long.
+	PeerDebounce time.Duration
+	// ConvergenceCycles is the number of reads of the peers in a row that
+	// find the same peers after which Status reports the interface as
+	// Converged, it defaults to 3.
+	ConvergenceCycles int
+	// ProbeEndpoints makes Connect send a datagram to the endpoint of every
+	// peer in the background after each read of the peers, the ones
+	// refusing it, e.g: with no route or nothing listening, are logged and
+	// reported by Status. It cannot detect the endpoints silently dropping
+	// the packets.
+	ProbeEndpoints bool
+	// MaxRetries is the number of consecutive failures after which
+	// Connect gives up, it defaults to 5 and cannot be negative.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubled on every
+	// consecutive failure up to 2 minutes. It defaults to 5 seconds.
+	RetryBackoff time.Duration
+	// DryRun makes Connect print the configuration it would apply to
+	// DryRunOutput, stdout when nil, and return without touching the link
+	// or joining the backend.
+	DryRun       bool
+	DryRunOutput io.Writer
+	// Observer makes Connect only watch the peers in the backend, e.g: for
+	// a monitoring node. It neither joins nor creates the link, so no key,
+	// endpoint or address are needed.
+	Observer bool
+	// SigningKey, when set, signs the local peer stored in the backend.
+	SigningKey ed25519.PrivateKey
+	// TrustedSigners, when set, makes Connect ignore the peers not signed by
+	// any of them, e.g: injected by someone with write access to the backend.
+	// It can hold a single key shared by all the peers or one key per peer.
+	TrustedSigners []ed25519.PublicKey
+	// AuthorizedKeys, when set, makes Connect ignore the peers whose public
+	// key is not one of them, whether they are signed or not.
+	AuthorizedKeys [][]byte
+	// OnPeerEvent, when set, is called for every peer added, removed,
+	// updated or skipped each time the peers of the interface change.
+	OnPeerEvent func(PeerEvent)
+	// LinkManager performs the operations on the link, it defaults
+	// to netlink in the current network namespace.
+	LinkManager LinkManager
+	// Logger defaults to the standard logger, without debug messages
+	Logger     Logger
+	privateKey []byte
+	// presharedKey is the optional symmetric key used on top of the public
+	// key handshake with every peer. Every node of the mesh needs the same
+	// one, it stays local and is never sent to the backend.
+	presharedKey []byte
 	retries      int
+	// routes installed through the link for the current peers
+	routes []*net.IPNet
+	// addresses added to the link, see syncAddrs
+	addrs []netlink.Addr
+	// the dns servers set for the link, see setDNS
+	dnsSet string
+	// endpoints in use of the peers with more than one, by public key
+	endpoints map[string]endpointChoice
+	// public keys of the peers ignored for their signature
+	untrusted map[string]bool
+	// public keys of the peers ignored for not being authorized
+	unauthorized map[string]bool
+	// the peers the link is configured with and their hash
+	appliedPeers []Peer
+	appliedSHA   string
+	// the config the interface was created from, see UpdateConfig
+	config *Config
+	reload chan struct{}
+	// the rotation of the private key, see RotateKey
+	rotation keyRotation
+
+	stateMutex     sync.RWMutex
+	peers          []Peer
+	peersSHA       string
+	shaChanged     time.Time
+	stableCycles   int
+	peerConflicts  []PeerConflict
+	probeFailures  []UnreachablePeer
+	probing        bool
+	backendHealthy bool
+	pending        *Config
+	pendingBackend Backend
 }
 
+// NewInterface creates the interface with the private key stored at
+// privateKeyPath, a new key is generated there when the file does not
+// exist. The same goes for the optional presharedKeyPath. The existing key
+// files accessible by the group or the others are logged, see WithStrictKeys.
 func NewInterface(
 	b Backend,
 	ifname string,
 	endpoint string,
 	ipaddr string,
 	privateKeyPath string,
+	presharedKeyPath string,
+	peerCheckTTL time.Duration,
+) (*Interface, error) {
+	return NewInterfaceWithOptions(
+		b,
+		ifname,
+		WithEndpoint(endpoint),
+		WithIP(ipaddr),
+		WithPrivateKeyPath(privateKeyPath),
+		WithPresharedKeyPath(presharedKeyPath),
+		WithReconcileInterval(peerCheckTTL),
+	)
+}
+
+// NewInterfaceWithKey creates the interface with the base64 private key,
+// as printed by wg genkey, without reading or writing any file, e.g: for
+// a key coming from an environment variable or a secret. The preshared
+// key is optional, nil disables it.
+func NewInterfaceWithKey(
+	b Backend,
+	ifname string,
+	endpoint string,
+	ipaddr string,
+	privateKey []byte,
+	presharedKey []byte,
+	peerCheckTTL time.Duration,
+) (*Interface, error) {
+	return NewInterfaceWithOptions(
+		b,
+		ifname,
+		WithEndpoint(endpoint),
+		WithIP(ipaddr),
+		WithPrivateKey(privateKey),
+		WithPresharedKey(presharedKey),
+		WithReconcileInterval(peerCheckTTL),
+	)
+}
+
+// newInterface validates the parameters and creates the interface without keys.
+func newInterface(
+	b Backend,
+	ifname string,
+	endpoint string,
+	ipaddr string,
 	peerCheckTTL time.Duration,
 ) (*Interface, error) {
-	hostPort := strings.Split(endpoint, ":")
-	if len(hostPort) != 2 {
-		return nil, fmt.Errorf(errEndpointFormatNotValid)
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf(errEndpointFormatNotValid, ErrInvalidEndpoint)
 	}
 
-	if net.ParseIP(hostPort[0]) == nil {
-		return nil, fmt.Errorf(errInvalidEndpoint)
+	if len(host) == 0 {
+		return nil, fmt.Errorf(errEndpointFormatNotValid, ErrInvalidEndpoint)
 	}
 
-	if err := validatePort(hostPort[1]); err != nil {
+	if err := validatePort(port); err != nil {
 		return nil, err
 	}
 
 	// Check that the passed interface name is ok for the kernel
 	// https://git.kernel.org/pub/scm/linux/kernel/git/stable/linux-stable.git/tree/include/uapi/linux/if.h?h=v4.14.36#n33
 	if len(ifname) > ifnamesiz {
-		return nil, fmt.Errorf(errInterfaceNameLength)
+		return nil, fmt.Errorf(errInterfaceNameLength, ifnamesiz)
 	}
 
-	if _, err := os.Stat(privateKeyPath); os.IsNotExist(err) {
-		privKey, err := wireguard.Genkey()
+	// an ip pool in CIDR notation means that the address is allocated on connect
+	var ip *net.IP
+	var pool *net.IPNet
+	prefixLen := defaultPrefixLen
+	if strings.Contains(ipaddr, "/") {
+		_, pool, err = net.ParseCIDR(ipaddr)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf(errIPNotValid, ErrInvalidIP, ipaddr)
 		}
-
-		err = ioutil.WriteFile(privateKeyPath, privKey, 0600)
-		if err != nil {
-			return nil, fmt.Errorf(errPrivateKeyWriting, err.Error())
+		prefixLen, _ = pool.Mask.Size()
+	} else {
+		ipnet := net.ParseIP(ipaddr)
+		if ipnet == nil {
+			return nil, fmt.Errorf(errIPNotValid, ErrInvalidIP, ipaddr)
+		}
+		ip = &ipnet
+		if ipnet.To4() == nil {
+			prefixLen = defaultIPv6PrefixLen
 		}
 	}
 
-	privKey, err := ioutil.ReadFile(privateKeyPath)
+	// the hostname is only informative, it is left empty when unknown
+	hostname, _ := os.Hostname()
 
-	if err != nil {
-		return nil, fmt.Errorf(errPrivateKeyOpening, err.Error())
-	}
-
-	pubKey, err := wireguard.ExtractPubKey(privKey)
-	if err != nil {
-		return nil, err
-	}
-	ipnet := net.ParseIP(ipaddr)
 	return &Interface{
 		Backend:      b,
 		Name:         ifname,
 		PeerCheckTTL: peerCheckTTL,
-		privateKey:   privKey,
+		PrefixLen:    prefixLen,
+		MTU:          defaultMTU,
+		Pool:         pool,
 		LocalPeer: Peer{
-			PublicKey: pubKey,
-			IP:        &ipnet,
-			Endpoint:  endpoint,
+			IP:       ip,
+			Endpoint: endpoint,
+			Hostname: hostname,
 		},
 	}, nil
 }
 
+// setKeys sets the private key, and the public key derived from it, and
+// the preshared key. The keys are never logged.
+func (i *Interface) setKeys(privateKey, presharedKey []byte) error {
+	pubKey, err := wireguard.ExtractPubKey(privateKey)
+	if err != nil {
+		return err
+	}
+	i.privateKey = privateKey
+	i.LocalPeer.PublicKey = pubKey
+	if len(presharedKey) > 0 {
+		i.presharedKey = presharedKey
+	}
+	return nil
+}
+
+// loadOrGenerateKey reads the key stored at path,
+// if the file does not exist a new key is generated and stored there.
+// Surrounding whitespace, e.g: a trailing newline, is trimmed.
+// A file accessible by the group or the others is logged, when strict
+// it is an error.
+func loadOrGenerateKey(path string, generate func() (string, error), strict bool, logger Logger) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err == nil && info.Mode().Perm()&0077 != 0 {
+		if strict {
+			return nil, fmt.Errorf(errKeyPermissions, path, info.Mode().Perm())
+		}
+		logger.Errorf(errKeyPermissions, path, info.Mode().Perm())
+	}
+	if os.IsNotExist(err) {
+		key, err := generate()
+		if err != nil {
+			return nil, err
+		}
+
+		err = ioutil.WriteFile(path, []byte(key), 0600)
+		if err != nil {
+			return nil, fmt.Errorf(errPrivateKeyWriting, err)
+		}
+	}
+
+	key, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf(errPrivateKeyOpening, err)
+	}
+	return bytes.TrimSpace(key), nil
+}
+
+// PeersSHA identifies a set of peers, so that the nodes of a mesh can be
+// compared: it is the hex sha256 of the sha256 of the json encoding of
+// every peer, ordered by public key, without the Hostname, the LastSeen
+// and the Signature, which do not change the tunnels. The order of the
+// peers does not change it.
+func PeersSHA(peers []Peer) string {
+	return extractPeersSHA(peers)
+}
+
 func extractPeersSHA(workingPeers []Peer) string {
+	// sort a copy by public key to obtain the same hash regardless of the order
+	workingPeers = append([]Peer{}, workingPeers...)
 	sort.Slice(workingPeers, func(i, j int) bool {
-		comparison := bytes.Compare(workingPeers[i].PublicKey, workingPeers[j].PublicKey)
-		if comparison > 0 {
-			return true
-		}
-		return false
+		return bytes.Compare(workingPeers[i].PublicKey, workingPeers[j].PublicKey) < 0
 	})
-	keys := ""
+	// hash of all the peers, made of the fixed size hashes of every
+	// full peer so that different peer sets cannot produce the same input
+	h := sha256.New()
 	for _, p := range workingPeers {
-		// hash the full peer to verify if it changed
-		peerj, _ := json.Marshal(p)
-		peerh := sha256.New()
-		peerh.Write(peerj)
-		keys = fmt.Sprintf("%s%x", keys, peerh.Sum(nil))
+		// the hostname and refreshing LastSeen, and so the signature,
+		// do not change the tunnels
+		p.Hostname = ""
+		p.LastSeen = nil
+		p.Signature = nil
+		peerj, _ := MarshalPeer(p)
+		peerh := sha256.Sum256(peerj)
+		h.Write(peerh[:])
 	}
 
-	// hash of all the peers
-	h := sha256.New()
-	h.Write([]byte(keys))
-
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
-func (i *Interface) addressAlreadyTaken() (bool, error) {
-	peers, err := i.Backend.GetPeers(i.Name)
+// localAddr returns the address to assign to the link, made of the
+// local peer ip and the configured prefix length.
+func (i *Interface) localAddr() (*netlink.Addr, error) {
+	if i.PrefixLen <= 0 || i.PrefixLen > hostBits(*i.LocalPeer.IP) {
+		return nil, fmt.Errorf(errPrefixLenNotValid, i.LocalPeer.IP.String(), i.PrefixLen)
+	}
+	return netlink.ParseAddr(fmt.Sprintf("%s/%d", i.LocalPeer.IP.String(), i.PrefixLen))
+}
+
+// hostBits returns the number of bits of the addresses of the ip family,
+// the prefix length of a single address.
+func hostBits(ip net.IP) int {
+	if ip.To4() != nil {
+		return net.IPv4len * 8
+	}
+	return net.IPv6len * 8
+}
+
+// join stores the local peer in the backend, with the current
+// LastSeen when the staleness of the peers is tracked.
+func (i *Interface) join(ctx context.Context) error {
+	if i.PeerStaleness > 0 {
+		now := time.Now().UTC()
+		i.LocalPeer.LastSeen = &now
+	}
+	previous, ok := i.previousPeer()
+	if !ok {
+		return join(ctx, i.Backend, i.Name, i.signed(i.LocalPeer))
+	}
+	// the address is still claimed by the previous key, both entries
+	// are stored until the end of the overlap
+	if err := i.Backend.Join(ctx, i.Name, i.signed(i.LocalPeer)); err != nil {
+		return err
+	}
+	return i.Backend.Join(ctx, i.Name, i.signed(previous))
+}
+
+// addressedPeers leaves out the remote peers without an address, their
+// allowed IPs and routes cannot be computed.
+func (i *Interface) addressedPeers(peers []Peer) []Peer {
+	addressed := []Peer{}
+	for _, p := range peers {
+		if p.IP == nil && !bytes.Equal(p.PublicKey, i.LocalPeer.PublicKey) {
+			i.logger().Errorf("Ignoring peer %s: it has no address", p.Endpoint)
+			continue
+		}
+		addressed = append(addressed, p)
+	}
+	return addressed
+}
+
+// freshPeers leaves out the peers not seen within the staleness,
+// the peers without a LastSeen do not track it and are kept.
+func freshPeers(peers []Peer, now time.Time, staleness time.Duration) []Peer {
+	if staleness <= 0 {
+		return peers
+	}
+	fresh := []Peer{}
+	for _, p := range peers {
+		if p.LastSeen == nil || now.Sub(*p.LastSeen) <= staleness {
+			fresh = append(fresh, p)
+		}
+	}
+	return fresh
+}
+
+// dedupPeers keeps a single entry per public key, the last one returned
+// by the backend wins, e.g: a fresh Join over the stale entry of the peer.
+func dedupPeers(peers []Peer) []Peer {
+	index := map[string]int{}
+	deduped := []Peer{}
+	for _, p := range peers {
+		if n, ok := index[string(p.PublicKey)]; ok {
+			deduped[n] = p
+			continue
+		}
+		index[string(p.PublicKey)] = len(deduped)
+		deduped = append(deduped, p)
+	}
+	return deduped
+}
+
+// addressOwner returns the other peer with the address of the local
+// peer, nil when the address is free.
+func (i *Interface) addressOwner(ctx context.Context) (*Peer, error) {
+	peers, err := i.Backend.GetPeers(ctx, i.Name)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	for _, p := range peers {
-		if p.IP.Equal(*i.LocalPeer.IP) && !bytes.Equal(i.LocalPeer.PublicKey, p.PublicKey) {
-			return true, nil
+		if bytes.Equal(i.LocalPeer.PreviousPublicKey, p.PublicKey) {
+			continue
+		}
+		if p.IP != nil && p.IP.Equal(*i.LocalPeer.IP) && !bytes.Equal(i.LocalPeer.PublicKey, p.PublicKey) {
+			owner := p
+			return &owner, nil
 		}
 	}
-	return false, nil
+	return nil, nil
+}
+
+// addressTakenError is the error of the local address belonging to owner.
+func (i *Interface) addressTakenError(owner Peer) AddressTakenError {
+	return AddressTakenError{IP: i.LocalPeer.IP.String(), Fingerprint: fingerprint(owner.PublicKey)}
+}
+
+// addressTaken records that the address of the local peer belongs to
+// another peer, so that the operators can alert on the collisions apart
+// from the other failures, and returns err.
+func (i *Interface) addressTaken(err AddressTakenError) error {
+	addressTakenCounter.WithLabelValues(i.Name).Inc()
+	i.logEvent("address_taken", map[string]interface{}{"error": err.Error(), "ip": err.IP, "peer": err.Fingerprint},
+		"The address %s is taken, the peer does not join: %s", err.IP, err.Error())
+	return err
 }
 
-func (i *Interface) retryConnection(reason string) error {
-	log.Printf("Retry connect, reason: %s", reason)
-	time.Sleep(retryttl)
+// errRetry is returned by retryConnection once the backoff is over, for
+// Connect to connect again.
+var errRetry = errors.New("retry the connection")
+
+// retryConnection waits for the backoff of the next retry and returns
+// errRetry, or the error ending Connect: the one of the retries, or the
+// one of leave once the context is done.
+func (i *Interface) retryConnection(ctx context.Context, reason string) error {
 	i.retries = i.retries + 1
-	if i.retries > maxretries-1 {
+	if i.retries > i.maxRetries()-1 {
 		return fmt.Errorf("%s: Last error: %s", errMaxRetriesReached, reason)
 	}
-	err := i.Connect()
+	backoff := i.retryBackoff()
+	i.logEvent("retry", map[string]interface{}{"error": reason, "backoff": backoff.String()},
+		"Retry connect in %s, reason: %s", backoff, reason)
+	select {
+	case <-ctx.Done():
+		return i.leave(ctx)
+	case <-time.After(backoff):
+	}
+	return errRetry
+}
 
-	if err == nil {
-		i.retries = 0
+// maxRetries returns the configured MaxRetries or the default one when not set.
+func (i *Interface) maxRetries() int {
+	if i.MaxRetries == 0 {
+		return maxretries
 	}
+	return i.MaxRetries
+}
 
-	return err
+// retryBackoff returns the wait before the next retry, it doubles
+// with every consecutive failure up to maxRetryBackoff.
+func (i *Interface) retryBackoff() time.Duration {
+	backoff := i.RetryBackoff
+	if backoff == 0 {
+		backoff = retryttl
+	}
+	for n := 1; n < i.retries && backoff < maxRetryBackoff; n++ {
+		backoff = backoff * 2
+	}
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
 }
 
-func (i *Interface) Connect() error {
-	taken, err := i.addressAlreadyTaken()
+// leave removes the local peer from the backend once the context
+// passed to Connect is done and returns the context error. The peer
+// leaves with a new context, the one of Connect is done already.
+func (i *Interface) leave(ctx context.Context) error {
+	if i.Observer {
+		return ctx.Err()
+	}
+	if err := i.leaveBackend(context.Background(), i.Backend); err != nil {
+		i.logger().Errorf("%s", fmt.Errorf(errLeave, err))
+	}
+	return ctx.Err()
+}
 
+// checkConfig validates the local configuration, it returns the
+// address of the link and the port wireguard listens on.
+func (i *Interface) checkConfig() (*netlink.Addr, int, error) {
+	addr, err := i.localAddr()
 	if err != nil {
-		return i.retryConnection(err.Error())
+		return nil, 0, err
+	}
+
+	if err := validateAllowedIPs(i.LocalPeer.AllowedIPs); err != nil {
+		return nil, 0, err
+	}
+
+	if err := validatePeerPrefixLen(i.LocalPeer); err != nil {
+		return nil, 0, err
+	}
+
+	if err := validateManagementAddr(i.LocalPeer.ManagementAddr); err != nil {
+		return nil, 0, err
 	}
 
-	if taken {
-		return fmt.Errorf(errAddressAlreadyTaken, *i.LocalPeer.IP)
+	if err := i.checkAllowedIPsPolicy(); err != nil {
+		return nil, 0, err
 	}
 
-	// Join
-	err = i.Backend.Join(i.Name, i.LocalPeer)
+	if err := validateAllocation(i.Allocation); err != nil {
+		return nil, 0, err
+	}
+
+	if i.mtu() < minMTU {
+		return nil, 0, fmt.Errorf(errMTUNotValid, minMTU, i.mtu())
+	}
 
+	if err := validateRouteTable(i.RouteTable); err != nil {
+		return nil, 0, err
+	}
+
+	if i.MaxRetries < 0 {
+		return nil, 0, fmt.Errorf(errMaxRetriesNotValid, i.MaxRetries)
+	}
+
+	if i.peerCheckTTL() < 0 {
+		return nil, 0, fmt.Errorf(errPeerCheckTTLNotValid, i.PeerCheckTTL)
+	}
+
+	listenPort, err := i.listenPort()
+	if err != nil {
+		return nil, 0, err
+	}
+	return addr, listenPort, nil
+}
+
+// Connect joins the backend and keeps the wireguard link in sync with
+// the peers found there until the passed context is done.
+// With DryRun set it only prints what it would configure.
+func (i *Interface) Connect(ctx context.Context) error {
+	build := GetBuildInfo()
+	i.logEvent("started", map[string]interface{}{
+		"version": build.Version, "commit": build.Commit, "build_date": build.BuildDate,
+	}, "Starting wirey %s", build)
+	if i.DryRun {
+		return i.dryRun(ctx)
+	}
+	for {
+		var err error
+		if i.Observer {
+			err = i.observe(ctx)
+		} else {
+			err = i.connect(ctx)
+		}
+		if err != errRetry {
+			return err
+		}
+	}
+}
+
+// connect is a connection of Connect, it returns errRetry when it is
+// worth connecting again.
+func (i *Interface) connect(ctx context.Context) error {
+	// the endpoints of the peers are chosen again on every connection
+	i.endpoints = nil
+
+	addr, listenPort, retryable, err := i.reconcile(ctx)
+	if retryable {
+		return i.retryConnection(ctx, err.Error())
+	}
+	if errors.Is(err, ErrNoWireguard) {
+		// the peer joined already, it does not stay without a link
+		i.leave(ctx)
+	}
 	if err != nil {
 		return err
 	}
 
-	peersSHA := ""
+	peersc, stopWatch, err := i.startWatch(ctx)
+	defer func() { stopWatch() }()
+	if err != nil {
+		i.backendFailed()
+		return i.retryConnection(ctx, fmt.Sprintf("problem watching the peers in the backend: %s", err.Error()))
+	}
+
+	refresh := i.refreshTicker()
+	defer func() { stopTicker(refresh) }()
+
 	for {
-		workingPeers, err := i.Backend.GetPeers(i.Name)
+		var workingPeers []Peer
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return i.leave(ctx)
+		case <-tickerC(refresh):
+			if err := i.join(ctx); err != nil {
+				i.backendFailed()
+				i.logger().Errorf("problem refreshing the peer in the backend: %s", err.Error())
+			}
+			continue
+		case <-i.rotationc():
+			if err := i.finishRotation(ctx); err != nil {
+				i.backendFailed()
+				i.logger().Errorf("problem finishing the rotation of the key: %s", err.Error())
+			}
+			continue
+		case <-i.reloadc():
+			i.startRotation()
+			if i.applyPending(ctx) {
+				if err := i.deleteLink(); err != nil {
+					return i.retryConnection(ctx, err.Error())
+				}
+			}
+			addr, listenPort, err = i.checkConfig()
+			if err != nil {
+				i.leave(ctx)
+				return err
+			}
+			if err := i.join(ctx); err != nil {
+				i.backendFailed()
+				i.logger().Errorf("problem joining the backend with the new configuration: %s", err.Error())
+			}
+			// read the peers again, with the new backend and ttl if any
+			stopWatch()
+			peersc, stopWatch, err = i.startWatch(ctx)
+			if err != nil {
+				i.backendFailed()
+				return i.retryConnection(ctx, fmt.Sprintf("problem watching the peers in the backend: %s", err.Error()))
+			}
+			stopTicker(refresh)
+			refresh = i.refreshTicker()
+			i.appliedSHA = ""
+			continue
+		case workingPeers, ok = <-peersc:
+		}
+		if !ok {
+			i.backendFailed()
+			return i.retryConnection(ctx, "the watch of the peers in the backend stopped")
+		}
+		if err := i.apply(addr, workingPeers, listenPort); err != nil {
+			// retrying cannot load the module
+			if errors.Is(err, ErrNoWireguard) {
+				i.leave(ctx)
+				return err
+			}
+			return i.retryConnection(ctx, err.Error())
+		}
+	}
+}
+
+// Reconcile performs a single pass of Connect and returns: it joins the
+// backend, reads the peers and configures the link with them, e.g: to run
+// wirey from cron. Every call configures the link again, so that repeated
+// calls converge even when the link was changed in between. The peer stays
+// in the backend, use Disconnect to leave. The calls to the backend are
+// bounded by its own timeouts. It fails with the backends whose entries
+// expire, see Expirer, the entry would be gone soon after it returns.
+func (i *Interface) Reconcile() error {
+	ctx := context.Background()
+	if i.DryRun {
+		return i.dryRun(ctx)
+	}
+	if i.Observer {
+		// observers only read the peers, there is no link to configure
+		peers, err := i.Backend.GetPeers(ctx, i.Name)
 		if err != nil {
-			return i.retryConnection(fmt.Sprintf("problem during extraction of peers from the backend: %s", err.Error()))
+			i.backendFailed()
+			return err
 		}
+		peers = freshPeers(i.withoutPreviousKeys(dedupPeers(i.trustedPeers(i.authorizedPeers(peers)))), time.Now(), i.PeerStaleness)
+		i.setPeers(peers)
+		i.setPeersSHA(extractPeersSHA(peers))
+		return nil
+	}
+	if ttl := entryTTL(i.Backend); ttl > 0 {
+		return fmt.Errorf(errReconcileExpires, ttl)
+	}
+	_, _, _, err := i.reconcile(ctx)
+	return err
+}
+
+// reconcile joins the backend, reads the peers and configures the link
+// with them: the pass of Reconcile and the first one of Connect. It
+// returns the address of the link and the port wireguard listens on,
+// retryable tells if the error is worth retrying.
+func (i *Interface) reconcile(ctx context.Context) (addr *netlink.Addr, listenPort int, retryable bool, err error) {
+	// the link is configured at least once
+	i.appliedSHA = ""
 
-		// We don't change anything if the peers remain the same
-		newPeersSHA := extractPeersSHA(workingPeers)
-		if newPeersSHA == peersSHA {
-			time.Sleep(i.PeerCheckTTL)
+	addr, listenPort, retryable, err = i.setup(ctx)
+	if err != nil {
+		return nil, 0, retryable, err
+	}
+	peers, err := i.Backend.GetPeers(ctx, i.Name)
+	if err != nil {
+		i.backendFailed()
+		return nil, 0, true, err
+	}
+	if err := i.apply(addr, peers, listenPort); err != nil {
+		// retrying cannot load the module
+		return nil, 0, !errors.Is(err, ErrNoWireguard), err
+	}
+	return addr, listenPort, false, nil
+}
+
+// setup validates the configuration and joins the backend, it returns
+// the address of the link and the port wireguard listens on. retryable
+// tells if the error came from the backend and is worth retrying.
+func (i *Interface) setup(ctx context.Context) (addr *netlink.Addr, listenPort int, retryable bool, err error) {
+	if i.Pool != nil && i.LocalPeer.IP == nil {
+		if err := i.allocateIP(ctx); err != nil {
+			i.backendFailed()
+			return nil, 0, true, err
+		}
+	}
+
+	owner, err := i.addressOwner(ctx)
+	if err != nil {
+		i.backendFailed()
+		return nil, 0, true, err
+	}
+	if owner != nil {
+		return nil, 0, false, i.addressTaken(i.addressTakenError(*owner))
+	}
+
+	addr, listenPort, err = i.checkConfig()
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	// the port is bound by the link once created, check it only before that
+	if _, err := i.links().LinkByName(i.Name); err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			if err := checkUDPPort(listenPort); err != nil {
+				return nil, 0, false, err
+			}
+		}
+	}
+
+	if i.SigningKey != nil {
+		signer := base64.StdEncoding.EncodeToString(i.SigningKey.Public().(ed25519.PublicKey))
+		i.logger().Infof("Signing the peer with the key %s, trust it on the other peers", signer)
+	}
+
+	// Join, atomically checking the address when the backend can
+	err = i.join(ctx)
+	if taken, ok := err.(AddressTakenError); ok {
+		// CompareAndJoin does not tell who claimed the address meanwhile
+		if owner, err := i.addressOwner(ctx); err == nil && owner != nil {
+			taken = i.addressTakenError(*owner)
+		}
+		return nil, 0, false, i.addressTaken(taken)
+	}
+	if err != nil {
+		i.backendFailed()
+		return nil, 0, true, err
+	}
+	return addr, listenPort, false, nil
+}
+
+// usablePeers leaves out the peers read from the backend the link is not
+// configured with: the ones not authorized, not trusted, stale, not
+// selected, without an address or conflicting with another peer.
+func (i *Interface) usablePeers(peers []Peer) []Peer {
+	peers = freshPeers(i.withoutPreviousKeys(dedupPeers(i.trustedPeers(i.authorizedPeers(peers)))), time.Now(), i.PeerStaleness)
+	peers = i.addressedPeers(i.selectedPeers(peers))
+	return i.withoutConflicts(peers)
+}
+
+// apply configures the link with the peers read from the backend,
+// unless they are the ones it is configured with already.
+func (i *Interface) apply(addr *netlink.Addr, peers []Peer, listenPort int) error {
+	peers = i.chooseEndpoints(i.usablePeers(peers), time.Now())
+	i.probeEndpoints(peers)
+	i.setPeers(peers)
+	reconciliationsCounter.WithLabelValues(i.Name).Inc()
+	peersGauge.WithLabelValues(i.Name).Set(float64(len(peers)))
+
+	// We don't change anything if the peers remain the same
+	peersSHA := extractPeersSHA(peers)
+	if peersSHA == i.appliedSHA {
+		i.logger().Debugf("The peer list did not change, doing nothing")
+		i.retries = 0
+		i.stableCycle()
+		return nil
+	}
+	i.logger().Infof("The peer list changed, reconfiguring...")
+
+	if err := i.configureLink(addr, peers, listenPort); err != nil {
+		return err
+	}
+
+	i.appliedSHA = peersSHA
+	i.setPeersSHA(peersSHA)
+	i.logEvent("reconfigured", map[string]interface{}{"peer_count": len(peers), "sha": peersSHA}, "Link up")
+	i.retries = 0
+
+	diff := DiffPeers(i.appliedPeers, peers)
+	i.logPeerDiff(diff)
+	i.notifyPeerEvents(diff.Events())
+	i.appliedPeers = peers
+	return nil
+}
+
+// configuration returns the wireguard configuration of the link for the peers.
+func (i *Interface) configuration(peers []Peer, listenPort int) wireguard.Configuration {
+	conf := wireguard.Configuration{
+		Interface: wireguard.Interface{
+			ListenPort: listenPort,
+			PrivateKey: string(i.privateKey),
+			FwMark:     i.FwMark,
+			MTU:        i.mtu(),
+		},
+		Peers: []wireguard.Peer{},
+	}
+	if i.LocalPeer.IP != nil {
+		conf.Interface.Address = fmt.Sprintf("%s/%d", i.LocalPeer.IP.String(), i.PrefixLen)
+	}
+	if len(i.LocalPeer.ManagementAddr) > 0 {
+		conf.Interface.Address = strings.TrimPrefix(conf.Interface.Address+","+i.LocalPeer.ManagementAddr, ",")
+	}
+	conf.Interface.DNS = dnsString(i.DNS)
+
+	for _, p := range peers {
+		if bytes.Equal(p.PublicKey, i.LocalPeer.PublicKey) {
 			continue
 		}
-		log.Println("The peer list changed, reconfiguring...")
-		peersSHA = newPeersSHA
+		conf.Peers = append(conf.Peers, wireguard.Peer{
+			PublicKey:           string(p.PublicKey),
+			AllowedIPs:          strings.Join(i.peerAllowedIPs(p), ","),
+			Endpoint:            p.Endpoint,
+			PresharedKey:        string(i.presharedKey),
+			PersistentKeepalive: i.PersistentKeepalive,
+		})
+	}
+	return conf
+}
 
-		log.Println("Delete old link")
-		// delete any old link
-		link, _ := netlink.LinkByName(i.Name)
-		if link != nil {
-			netlink.LinkDel(link)
+// configureLink applies the configuration of the peers to the link. When the
+// link is created here and a later step fails, it is deleted with its
+// addresses and routes, so that the next attempt does not find it half done.
+// A link that was already there is kept to not break the established tunnels.
+func (i *Interface) configureLink(addr *netlink.Addr, peers []Peer, listenPort int) error {
+	// reuse the link if already there to keep the established tunnels
+	wirelink, created, err := i.ensureLink()
+	if err != nil {
+		return err
+	}
+	if created {
+		// the resolver forgets the servers of a deleted link
+		i.dnsSet = ""
+	}
+
+	if err := i.setupLink(wirelink, addr, peers, listenPort); err != nil {
+		if created {
+			i.logger().Infof("Delete the link created for the failed configuration")
+			if err := i.links().LinkDel(wirelink); err != nil {
+				i.logger().Errorf("%s", fmt.Errorf(errDelLink, err))
+			}
+			i.routes = nil
 		}
+		return err
+	}
+	return nil
+}
+
+func (i *Interface) setupLink(wirelink netlink.Link, addr *netlink.Addr, peers []Peer, listenPort int) error {
+	// Configure wireguard
+	conf := i.configuration(peers, listenPort)
+	if err := i.links().SetConf(i.Name, conf); err != nil {
+		return err
+	}
+
+	// Add the actual addresses to the link
+	managementAddr, err := i.managementAddr()
+	if err != nil {
+		return err
+	}
+	if err := i.syncAddrs(wirelink, addr, managementAddr); err != nil {
+		return err
+	}
 
-		// create the actual link
-		wirelink := &netlink.GenericLink{
-			LinkAttrs: netlink.LinkAttrs{
-				Name: i.Name,
-			},
-			LinkType: "wireguard",
+	// Up the link, a link reused from a previous run could be down
+	if wirelink.Attrs().Flags&net.FlagUp == 0 {
+		i.logger().Infof("Bringing the link %s up", i.Name)
+	}
+	if err := i.links().LinkSetUp(wirelink); err != nil {
+		return err
+	}
+
+	if err := i.installRoutes(wirelink, addr.IPNet, peers); err != nil {
+		return err
+	}
+	return i.setDNS()
+}
+
+// ensureLink returns the wireguard link of the interface, creating it when
+// missing and recreating it when a link with the same name has another type.
+// created tells if the returned link is a new one.
+func (i *Interface) ensureLink() (link netlink.Link, created bool, err error) {
+	link, err = i.links().LinkByName(i.Name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); !ok {
+			return nil, false, fmt.Errorf(errGetLink, err)
 		}
-		err = netlink.LinkAdd(wirelink)
-		if err != nil {
-			return i.retryConnection(fmt.Sprintf(errAddLink, err.Error()))
+	}
+	if err == nil && isWireguardLink(link) {
+		if link.Attrs().MTU != i.mtu() {
+			if err := i.links().LinkSetMTU(link, i.mtu()); err != nil {
+				return nil, false, fmt.Errorf(errSetMTU, err)
+			}
 		}
+		return link, false, nil
+	}
 
-		// Add the actual address to the link
-		addr, err := netlink.ParseAddr(fmt.Sprintf("%s/24", i.LocalPeer.IP.String()))
-		if err != nil {
-			return i.retryConnection(fmt.Sprintf("error parsing the new ip address: %s", err.Error()))
+	if link != nil {
+		i.logger().Infof("Delete old link of type %s", link.Type())
+		if err := i.links().LinkDel(link); err != nil {
+			return nil, false, fmt.Errorf(errDelLink, err)
 		}
+	}
 
-		// Configure wireguard
-		s := strings.Split(i.LocalPeer.Endpoint, ":")
-		port, err := strconv.Atoi(s[1])
-		if err != nil {
-			return fmt.Errorf(errIntConversionPort, err.Error())
+	// create the actual link
+	wirelink := &netlink.GenericLink{
+		LinkAttrs: netlink.LinkAttrs{
+			Name: i.Name,
+			MTU:  i.mtu(),
+		},
+		LinkType: wireguardLinkType,
+	}
+	if err := i.addLink(wirelink); err != nil {
+		if errors.Is(err, ErrNoWireguard) {
+			return nil, false, err
 		}
-		conf := wireguard.Configuration{
-			Interface: wireguard.Interface{
-				ListenPort: port,
-				PrivateKey: string(i.privateKey),
-			},
-			Peers: []wireguard.Peer{},
+		return nil, false, fmt.Errorf(errAddLink, err)
+	}
+	i.logEvent("link_created", map[string]interface{}{}, "Link created")
+	return wirelink, true, nil
+}
+
+// isWireguardLink tells if the link is a wireguard one, a link with
+// the same name could be a leftover bridge or dummy.
+func isWireguardLink(link netlink.Link) bool {
+	return link != nil && link.Type() == wireguardLinkType
+}
+
+// FreeLinkName returns the first <base>N name, starting from 0,
+// that is not used by any of the links of the host.
+func FreeLinkName(base string) (string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return "", fmt.Errorf(errListLinks, err)
+	}
+	return nextLinkName(base, links)
+}
+
+// autoLinkName returns the name of the wireguard <base>N link of the host
+// with the public key, e.g: left by a crash, so that a restart keeps the
+// name, and the namespace of the peers with it. Otherwise the first free
+// <base>N name.
+func autoLinkName(base string, publicKey []byte) (string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return "", fmt.Errorf(errListLinks, err)
+	}
+	if name, ok := ownLinkName(base, publicKey, links, wireguard.DevicePublicKey); ok {
+		return name, nil
+	}
+	return nextLinkName(base, links)
+}
+
+// ownLinkName looks up the wireguard <base>N link configured with the
+// public key, the ones whose key cannot be read are skipped.
+func ownLinkName(base string, publicKey []byte, links []netlink.Link, deviceKey func(string) (string, error)) (string, bool) {
+	for _, l := range links {
+		name := l.Attrs().Name
+		if l.Type() != wireguardLinkType || !strings.HasPrefix(name, base) {
+			continue
+		}
+		if key, err := deviceKey(name); err == nil && key == string(publicKey) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func nextLinkName(base string, links []netlink.Link) (string, error) {
+	taken := map[string]bool{}
+	for _, l := range links {
+		taken[l.Attrs().Name] = true
+	}
+	for n := 0; ; n++ {
+		name := fmt.Sprintf("%s%d", base, n)
+		if len(name) > ifnamesiz {
+			return "", fmt.Errorf(errInterfaceNameLength, ifnamesiz)
+		}
+		if !taken[name] {
+			return name, nil
+		}
+	}
+}
+
+// listenPort returns the configured ListenPort or the port of the local endpoint when not set.
+func (i *Interface) listenPort() (int, error) {
+	if i.ListenPort != 0 {
+		if i.ListenPort < 1 || i.ListenPort > 65535 {
+			return 0, fmt.Errorf(errListenPortNotValid, i.ListenPort)
+		}
+		return i.ListenPort, nil
+	}
+	_, p, err := net.SplitHostPort(i.LocalPeer.Endpoint)
+	if err != nil {
+		return 0, fmt.Errorf(errEndpointFormatNotValid, ErrInvalidEndpoint)
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return 0, fmt.Errorf(errIntConversionPort, err)
+	}
+	return port, nil
+}
+
+// mtu returns the configured MTU or the default one when not set.
+func (i *Interface) mtu() int {
+	if i.MTU == 0 {
+		return defaultMTU
+	}
+	return i.MTU
+}
+
+// peerCheckTTL returns the configured PeerCheckTTL or the default one when not set.
+func (i *Interface) peerCheckTTL() time.Duration {
+	if i.PeerCheckTTL == 0 {
+		return defaultPeerCheckTTL
+	}
+	return i.PeerCheckTTL
+}
+
+func (i *Interface) peerCheckMaxTTL() time.Duration {
+	if i.PeerCheckMaxTTL < i.peerCheckTTL() {
+		return i.peerCheckTTL()
+	}
+	return i.PeerCheckMaxTTL
+}
+
+// syncAddrs adds the wanted addresses, the nil ones skipped, missing from
+// the link and, when the LinkManager is an AddrDeleter, deletes the stale
+// ones: the addresses added before, or overlapping the network of a wanted
+// one, e.g: left by a previous address or prefix length of a reused link.
+// The other addresses, e.g: added by an operator, are kept.
+func (i *Interface) syncAddrs(link netlink.Link, wanted ...*netlink.Addr) error {
+	current, err := i.links().AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf(errListAddrs, err)
+	}
+	has := func(addrs []netlink.Addr, addr netlink.Addr) bool {
+		for _, a := range addrs {
+			if a.Equal(addr) {
+				return true
+			}
 		}
+		return false
+	}
 
-		for _, p := range workingPeers {
-			if bytes.Equal(p.PublicKey, i.LocalPeer.PublicKey) {
+	keep := []netlink.Addr{}
+	for _, a := range wanted {
+		if a != nil {
+			keep = append(keep, *a)
+		}
+	}
+	stale := func(a netlink.Addr) bool {
+		if has(keep, a) || a.IP.IsLinkLocalUnicast() {
+			return false
+		}
+		if has(i.addrs, a) {
+			return true
+		}
+		for _, k := range keep {
+			if overlaps(a.IPNet, k.IPNet) {
+				return true
+			}
+		}
+		return false
+	}
+	if deleter, ok := i.links().(AddrDeleter); ok {
+		for _, a := range current {
+			if !stale(a) {
 				continue
 			}
-			conf.Peers = append(conf.Peers, wireguard.Peer{
-				PublicKey:  string(p.PublicKey),
-				AllowedIPs: fmt.Sprintf("%s/32", p.IP.String()),
-				Endpoint:   p.Endpoint,
-			})
+			i.logger().Infof("Deleting the stale address %s from the link", a.IPNet.String())
+			a := a
+			if err := deleter.AddrDel(link, &a); err != nil {
+				return fmt.Errorf(errDelAddr, a.IPNet.String(), err)
+			}
+		}
+	}
+	for _, a := range keep {
+		if has(current, a) {
+			continue
+		}
+		a := a
+		if err := i.links().AddrAdd(link, &a); err != nil {
+			return fmt.Errorf(errAddAddr, a.String(), err)
 		}
+	}
+	i.addrs = keep
+	return nil
+}
 
-		_, err = wireguard.SetConf(i.Name, conf)
+// Disconnect removes the local peer from the backend and deletes the
+// wireguard link, it is safe to call even if the link was never created.
+// A LinkManager that is an io.Closer is closed, e.g: NetnsLinkManager.
+func (i *Interface) Disconnect() error {
+	defer i.closeLinks()
+	// the link is deleted even when the backend is down
+	leaveErr := i.leaveBackend(context.Background(), i.Backend)
+	linkErr := i.deleteLink()
+	if leaveErr != nil && linkErr != nil {
+		return fmt.Errorf(errLeaveAndDelLink, leaveErr, linkErr.Error())
+	}
+	if leaveErr != nil {
+		return fmt.Errorf(errLeave, leaveErr)
+	}
+	return linkErr
+}
 
-		if err != nil {
-			return i.retryConnection(err.Error())
+// closeLinks closes the LinkManager when it is an io.Closer, e.g: to
+// release the namespace of the link.
+func (i *Interface) closeLinks() {
+	if c, ok := i.LinkManager.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			i.logger().Errorf("error closing the link manager: %s", err.Error())
 		}
+	}
+}
 
-		netlink.AddrAdd(wirelink, addr)
-
-		// Up the link
-		err = netlink.LinkSetUp(wirelink)
-		if err != nil {
-			return err
+// deleteLink deletes the wireguard link of the interface, if any.
+func (i *Interface) deleteLink() error {
+	link, err := i.links().LinkByName(i.Name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
 		}
+		return err
+	}
 
-		log.Println("Link up")
+	// never delete a link we did not create, even if it has our name
+	if !isWireguardLink(link) {
+		i.logger().Infof("Link of type %s left in place, it is not a wireguard link", link.Type())
+		return nil
 	}
 
+	i.revertDNS()
+
+	// the routes through the link are removed by the kernel with it
+	err = i.links().LinkDel(link)
+	if err != nil {
+		return fmt.Errorf(errDelLink, err)
+	}
+	i.routes = nil
+
+	i.logEvent("link_deleted", map[string]interface{}{}, "Link deleted")
 	return nil
 }
 
 func validatePort(port string) error {
-	if port != "" {
-		v, err := strconv.Atoi(port)
-		if err != nil {
-			return err
-		}
-		if v < 0 || v > 65535 {
-			return fmt.Errorf("port not valid %q", port)
-		}
+	v, err := strconv.Atoi(port)
+	if err != nil || v < 1 || v > 65535 {
+		return fmt.Errorf(errPortNotValid, ErrInvalidEndpoint, port)
 	}
 	return nil
 }
+
+// checkUDPPort fails when the udp port is already bound by another
+// process, that wireguard would only report later as an opaque error.
+func checkUDPPort(port int) error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return fmt.Errorf(errPortInUse, port, err)
+	}
+	return conn.Close()
+}
@@ -0,0 +1,577 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestAddressAlreadyTaken(t *testing.T) {
+	b := NewMemoryBackend()
+	logs := &bytes.Buffer{}
+	i := &Interface{
+		Backend:   b,
+		Name:      "wgtaken",
+		LocalPeer: testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		Logger:    JSONLogger{Output: logs},
+	}
+
+	owner, err := i.addressOwner(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, owner)
+
+	// the local peer itself does not take the address
+	assert.NoError(t, b.Join(context.Background(), "wgtaken", i.LocalPeer))
+	owner, err = i.addressOwner(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, owner)
+
+	other := testPeer("other", "10.0.0.1", "192.168.1.2:2345")
+	assert.NoError(t, b.Join(context.Background(), "wgtaken", other))
+	owner, err = i.addressOwner(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, &other, owner)
+
+	err = i.Connect(context.Background())
+	assert.True(t, errors.Is(err, ErrAddressTaken))
+	assert.Equal(t, AddressTakenError{IP: "10.0.0.1", Fingerprint: fingerprint([]byte("other"))}, err)
+	assert.EqualError(t, err, "address already taken: 10.0.0.1 by the peer "+fingerprint([]byte("other")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(addressTakenCounter.WithLabelValues("wgtaken")))
+
+	lines := bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n"))
+	event := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(lines[len(lines)-1], &event))
+	assert.Equal(t, "address_taken", event["event"])
+	assert.Equal(t, "10.0.0.1", event["ip"])
+	assert.Equal(t, fingerprint([]byte("other")), event["peer"])
+
+	// the one of CompareAndJoin too
+	assert.True(t, errors.Is(AddressTakenError{IP: "10.0.0.1"}, ErrAddressTaken))
+	assert.EqualError(t, AddressTakenError{IP: "10.0.0.1"}, "address already taken: 10.0.0.1 by the peer unknown")
+}
+
+func TestAddressTakenConcurrently(t *testing.T) {
+	logs := &bytes.Buffer{}
+	// the address looks free until the local peer joins
+	b := &racingBackend{MemoryBackend: NewMemoryBackend()}
+	assert.NoError(t, b.Join(context.Background(), "wgrace", testPeer("other", "10.0.0.1", "192.168.1.2:2345")))
+	i := &Interface{
+		Backend:     b,
+		Name:        "wgrace",
+		PrefixLen:   24,
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: newFakeLinkManager(),
+		Logger:      JSONLogger{Output: logs},
+	}
+
+	_, _, retryable, err := i.setup(context.Background())
+	assert.False(t, retryable)
+	assert.Equal(t, AddressTakenError{IP: "10.0.0.1", Fingerprint: fingerprint([]byte("other"))}, err)
+
+	lines := bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n"))
+	event := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(lines[len(lines)-1], &event))
+	assert.Equal(t, "address_taken", event["event"])
+	assert.Equal(t, fingerprint([]byte("other")), event["peer"])
+}
+
+func TestExtractPeersSHAIsOrderIndependent(t *testing.T) {
+	peers := []Peer{
+		testPeer("key1", "10.0.0.1", "192.168.1.1:2345"),
+		testPeer("key2", "10.0.0.2", "192.168.1.2:2345"),
+		testPeer("key3", "10.0.0.3", "192.168.1.3:2345"),
+		testPeer("key4", "10.0.0.4", "192.168.1.4:2345"),
+	}
+	expected := extractPeersSHA(peers)
+
+	for n := 0; n < 20; n++ {
+		shuffled := append([]Peer{}, peers...)
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		assert.Equal(t, expected, extractPeersSHA(shuffled))
+	}
+}
+
+func TestExtractPeersSHAKeysDoNotCollide(t *testing.T) {
+	a := []Peer{
+		testPeer("AB", "10.0.0.1", "192.168.1.1:2345"),
+		testPeer("C", "10.0.0.2", "192.168.1.2:2345"),
+	}
+	b := []Peer{
+		testPeer("A", "10.0.0.1", "192.168.1.1:2345"),
+		testPeer("BC", "10.0.0.2", "192.168.1.2:2345"),
+	}
+
+	assert.NotEqual(t, extractPeersSHA(a), extractPeersSHA(b))
+}
+
+func TestExtractPeersSHAEndpointChange(t *testing.T) {
+	peers := []Peer{
+		testPeer("a", "10.0.0.1", "192.168.1.1:2345"),
+		testPeer("b", "10.0.0.2", "192.168.1.2:2345"),
+	}
+	moved := []Peer{
+		testPeer("a", "10.0.0.1", "192.168.1.1:2345"),
+		testPeer("b", "10.0.0.2", "192.168.1.20:2345"),
+	}
+
+	assert.NotEqual(t, extractPeersSHA(peers), extractPeersSHA(moved))
+	events := DiffPeers(peers, moved).Events()
+	assert.Len(t, events, 1)
+	assert.Equal(t, PeerUpdated, events[0].Type)
+	assert.Equal(t, "192.168.1.20:2345", events[0].Peer.Endpoint)
+}
+
+func TestExtractPeersSHAIgnoresLastSeen(t *testing.T) {
+	before := time.Now()
+	after := before.Add(time.Minute)
+	a := testPeer("a", "10.0.0.1", "192.168.1.1:2345")
+	a.LastSeen = &before
+	b := testPeer("a", "10.0.0.1", "192.168.1.1:2345")
+	b.LastSeen = &after
+
+	assert.Equal(t, extractPeersSHA([]Peer{a}), extractPeersSHA([]Peer{b}))
+	assert.Equal(t, &before, a.LastSeen)
+}
+
+func TestExtractPeersSHAIgnoresHostname(t *testing.T) {
+	a := testPeer("a", "10.0.0.1", "192.168.1.1:2345")
+	b := testPeer("a", "10.0.0.1", "192.168.1.1:2345")
+	b.Hostname = "node1"
+
+	assert.Equal(t, extractPeersSHA([]Peer{a}), extractPeersSHA([]Peer{b}))
+}
+
+func TestFreshPeers(t *testing.T) {
+	now := time.Now()
+	seen := now.Add(-time.Minute)
+	stale := now.Add(-10 * time.Minute)
+	recent := testPeer("recent", "10.0.0.1", "192.168.1.1:2345")
+	recent.LastSeen = &seen
+	dead := testPeer("dead", "10.0.0.2", "192.168.1.2:2345")
+	dead.LastSeen = &stale
+	untracked := testPeer("untracked", "10.0.0.3", "192.168.1.3:2345")
+	peers := []Peer{recent, dead, untracked}
+
+	assert.Equal(t, []Peer{recent, untracked}, freshPeers(peers, now, 5*time.Minute))
+	assert.Equal(t, peers, freshPeers(peers, now, 0))
+}
+
+func TestApplySkipsPeersWithoutAddress(t *testing.T) {
+	links := newFakeLinkManager()
+	local := testPeer("local", "10.0.0.1", "192.168.1.1:2345")
+	remote := testPeer("remote", "10.0.0.2", "192.168.1.2:2345")
+	unaddressed := testPeer("unaddressed", "10.0.0.3", "192.168.1.3:2345")
+	unaddressed.IP = nil
+	i := &Interface{
+		Name:        "wg0",
+		PrefixLen:   24,
+		LocalPeer:   local,
+		LinkManager: links,
+	}
+	addr, err := i.localAddr()
+	assert.NoError(t, err)
+
+	assert.NoError(t, i.apply(addr, []Peer{local, remote, unaddressed}, 2345))
+	assert.Len(t, links.confs["wg0"].Peers, 1)
+	assert.Equal(t, "remote", links.confs["wg0"].Peers[0].PublicKey)
+}
+
+func TestJoinRefreshesLastSeen(t *testing.T) {
+	b := NewMemoryBackend()
+	i := &Interface{
+		Backend:       b,
+		Name:          "wg0",
+		PeerStaleness: time.Minute,
+		LocalPeer:     testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+	}
+
+	before := time.Now()
+	assert.NoError(t, i.join(context.Background()))
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 1)
+	assert.False(t, peers[0].LastSeen.Before(before.UTC()))
+
+	// without a staleness the peer does not track it
+	i.PeerStaleness = 0
+	i.LocalPeer.LastSeen = nil
+	assert.NoError(t, i.join(context.Background()))
+	peers, err = b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Nil(t, peers[0].LastSeen)
+}
+
+func TestConfigurationSkipsLocalPeer(t *testing.T) {
+	i := &Interface{
+		PrefixLen: 24,
+		LocalPeer: testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+	}
+	conf := i.configuration([]Peer{
+		testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		testPeer("remote", "10.0.0.2", "192.168.1.2:2345"),
+	}, 2345)
+
+	assert.Len(t, conf.Peers, 1)
+	assert.Equal(t, "remote", conf.Peers[0].PublicKey)
+	assert.Equal(t, "10.0.0.2/32", conf.Peers[0].AllowedIPs)
+	assert.Equal(t, "10.0.0.1/24", conf.Interface.Address)
+	assert.Equal(t, defaultMTU, conf.Interface.MTU)
+}
+
+func TestConfigurationPresharedKey(t *testing.T) {
+	i := &Interface{
+		LocalPeer:    testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		presharedKey: []byte("FpCyhws9cxwWoV4xELtfJvjJN+zQVRPISllRWgeopVE="),
+	}
+	conf := i.configuration([]Peer{
+		testPeer("remote1", "10.0.0.2", "192.168.1.2:2345"),
+		testPeer("remote2", "10.0.0.3", "192.168.1.3:2345"),
+	}, 2345)
+
+	for _, p := range conf.Peers {
+		assert.Equal(t, "FpCyhws9cxwWoV4xELtfJvjJN+zQVRPISllRWgeopVE=", p.PresharedKey)
+	}
+	pj, err := json.Marshal(i.LocalPeer)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(pj), "FpCyhws9cxwWoV4xELtfJvjJN+zQVRPISllRWgeopVE=", "never sent to the backend")
+}
+
+func TestDedupPeers(t *testing.T) {
+	peers := dedupPeers([]Peer{
+		testPeer("a", "10.0.0.1", "192.168.1.1:2345"),
+		testPeer("b", "10.0.0.2", "192.168.1.2:2345"),
+		testPeer("a", "10.0.0.3", "192.168.1.3:2345"),
+	})
+
+	assert.Equal(t, []Peer{
+		testPeer("a", "10.0.0.3", "192.168.1.3:2345"),
+		testPeer("b", "10.0.0.2", "192.168.1.2:2345"),
+	}, peers)
+	assert.Equal(t, extractPeersSHA(peers), extractPeersSHA(dedupPeers(peers)))
+}
+
+func TestLoadOrGenerateKeyTrimsWhitespace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wirey")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	existing := filepath.Join(dir, "existing")
+	assert.NoError(t, ioutil.WriteFile(existing, []byte("iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=\n"), 0600))
+	key, err := loadOrGenerateKey(existing, nil, true, StdLogger{})
+	assert.NoError(t, err)
+	assert.Equal(t, "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=", string(key))
+
+	generated := filepath.Join(dir, "generated")
+	key, err = loadOrGenerateKey(generated, func() (string, error) {
+		return "Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=", nil
+	}, true, StdLogger{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=", string(key))
+	written, err := ioutil.ReadFile(generated)
+	assert.NoError(t, err)
+	assert.Equal(t, key, written)
+}
+
+func TestLoadOrGenerateKeyPermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wirey")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "privkey")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k="), 0600))
+	assert.NoError(t, os.Chmod(path, 0644))
+
+	_, err = loadOrGenerateKey(path, nil, true, StdLogger{})
+	assert.EqualError(t, err, fmt.Sprintf(errKeyPermissions, path, 0644))
+
+	// by default the key is used and the permissions are logged
+	out := &bytes.Buffer{}
+	key, err := loadOrGenerateKey(path, nil, false, JSONLogger{Output: out})
+	assert.NoError(t, err)
+	assert.Equal(t, "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=", string(key))
+	assert.Contains(t, out.String(), fmt.Sprintf(errKeyPermissions, path, 0644))
+}
+
+func TestNewInterfaceInvalidIP(t *testing.T) {
+	for _, ipaddr := range []string{"", "10.0.0.300", "not-an-ip"} {
+		_, err := NewInterface(NewMemoryBackend(), "wg0", "192.168.1.1:2345", ipaddr, "", "", time.Second)
+		assert.EqualError(t, err, fmt.Errorf(errIPNotValid, ErrInvalidIP, ipaddr).Error())
+		assert.True(t, errors.Is(err, ErrInvalidIP))
+	}
+}
+
+func TestNewInterfaceWithKeyInvalidIP(t *testing.T) {
+	key := []byte("iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=")
+	_, err := NewInterfaceWithKey(NewMemoryBackend(), "wg0", "192.168.1.1:2345", "10.0.0.300", key, nil, time.Second)
+	assert.EqualError(t, err, fmt.Errorf(errIPNotValid, ErrInvalidIP, "10.0.0.300").Error())
+}
+
+func TestLocalAddrIPv6(t *testing.T) {
+	i := &Interface{
+		PrefixLen: 64,
+		LocalPeer: testPeer("local", "fd00::1", "[2001:db8::1]:2345"),
+	}
+	addr, err := i.localAddr()
+	assert.NoError(t, err)
+	assert.Equal(t, "fd00::1/64", addr.IPNet.String())
+
+	i.PrefixLen = 129
+	_, err = i.localAddr()
+	assert.EqualError(t, err, fmt.Sprintf(errPrefixLenNotValid, "fd00::1", 129))
+}
+
+func TestMTUDefault(t *testing.T) {
+	i := &Interface{}
+	assert.Equal(t, 1420, i.mtu())
+
+	i.MTU = 1380
+	assert.Equal(t, 1380, i.mtu())
+}
+
+func TestRetryBackoff(t *testing.T) {
+	i := &Interface{}
+	i.retries = 1
+	assert.Equal(t, 5*time.Second, i.retryBackoff())
+	i.retries = 3
+	assert.Equal(t, 20*time.Second, i.retryBackoff())
+	i.retries = 100
+	assert.Equal(t, 2*time.Minute, i.retryBackoff())
+
+	i.RetryBackoff = time.Second
+	i.retries = 2
+	assert.Equal(t, 2*time.Second, i.retryBackoff())
+}
+
+// depthBackend fails every read of the peers, recording the depth of the
+// stack of the calls.
+type depthBackend struct {
+	*MemoryBackend
+	depths []int
+}
+
+func (d *depthBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	d.depths = append(d.depths, runtime.Callers(0, make([]uintptr, 256)))
+	return nil, errors.New("unreachable")
+}
+
+func TestConnectRetriesWithoutRecursion(t *testing.T) {
+	b := &depthBackend{MemoryBackend: NewMemoryBackend()}
+	i := &Interface{
+		Backend:      b,
+		Name:         "wg0",
+		LocalPeer:    testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager:  newFakeLinkManager(),
+		MaxRetries:   4,
+		RetryBackoff: time.Millisecond,
+	}
+
+	err := i.Connect(context.Background())
+	assert.Contains(t, err.Error(), errMaxRetriesReached)
+	assert.Len(t, b.depths, 4)
+	for _, depth := range b.depths {
+		assert.Equal(t, b.depths[0], depth)
+	}
+}
+
+func TestListenPort(t *testing.T) {
+	i := &Interface{
+		LocalPeer: testPeer("local", "10.0.0.1", "203.0.113.1:51820"),
+	}
+	port, err := i.listenPort()
+	assert.NoError(t, err)
+	assert.Equal(t, 51820, port)
+
+	i.ListenPort = 2345
+	port, err = i.listenPort()
+	assert.NoError(t, err)
+	assert.Equal(t, 2345, port)
+
+	i.ListenPort = 70000
+	_, err = i.listenPort()
+	assert.EqualError(t, err, fmt.Sprintf(errListenPortNotValid, 70000))
+}
+
+func TestPeerCheckTTL(t *testing.T) {
+	i := &Interface{}
+	assert.Equal(t, defaultPeerCheckTTL, i.peerCheckTTL())
+
+	i.PeerCheckTTL = time.Second
+	assert.Equal(t, time.Second, i.peerCheckTTL())
+}
+
+func TestValidatePort(t *testing.T) {
+	assert.NoError(t, validatePort("1"))
+	assert.NoError(t, validatePort("65535"))
+	for _, port := range []string{"0", "70000", "abc", "", "-1"} {
+		assert.EqualError(t, validatePort(port), fmt.Errorf(errPortNotValid, ErrInvalidEndpoint, port).Error())
+	}
+
+	_, err := NewInterface(NewMemoryBackend(), "wg0", "192.168.1.1:0", "10.0.0.1", "", "", time.Second)
+	assert.EqualError(t, err, fmt.Errorf(errPortNotValid, ErrInvalidEndpoint, "0").Error())
+	assert.True(t, errors.Is(err, ErrInvalidEndpoint))
+}
+
+func TestCheckUDPPort(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	assert.NoError(t, err)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	assert.Contains(t, checkUDPPort(port).Error(), fmt.Sprintf("the udp port %d is already in use", port))
+
+	conn.Close()
+	assert.NoError(t, checkUDPPort(port))
+}
+
+func TestIsWireguardLink(t *testing.T) {
+	attrs := netlink.LinkAttrs{Name: "wg0"}
+	assert.True(t, isWireguardLink(&netlink.GenericLink{LinkAttrs: attrs, LinkType: "wireguard"}))
+	assert.False(t, isWireguardLink(&netlink.Dummy{LinkAttrs: attrs}))
+	assert.False(t, isWireguardLink(&netlink.Bridge{LinkAttrs: attrs}))
+	assert.False(t, isWireguardLink(nil))
+}
+
+func TestNextLinkName(t *testing.T) {
+	links := []netlink.Link{
+		&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "lo"}},
+		&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "wg0"}},
+		&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "wg1"}},
+		&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "wg3"}},
+		&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "mesh0"}},
+	}
+
+	name, err := nextLinkName("wg", links)
+	assert.Nil(t, err)
+	assert.Equal(t, "wg2", name)
+
+	name, err = nextLinkName("mesh", links)
+	assert.Nil(t, err)
+	assert.Equal(t, "mesh1", name)
+
+	name, err = nextLinkName("wg", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "wg0", name)
+
+	_, err = nextLinkName("averyverylongname", nil)
+	assert.EqualError(t, err, fmt.Sprintf(errInterfaceNameLength, ifnamesiz))
+}
+
+func TestOwnLinkName(t *testing.T) {
+	links := []netlink.Link{
+		&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "wg0"}},
+		&netlink.GenericLink{LinkAttrs: netlink.LinkAttrs{Name: "wg1"}, LinkType: wireguardLinkType},
+		&netlink.GenericLink{LinkAttrs: netlink.LinkAttrs{Name: "wg2"}, LinkType: wireguardLinkType},
+		&netlink.GenericLink{LinkAttrs: netlink.LinkAttrs{Name: "mesh0"}, LinkType: wireguardLinkType},
+	}
+	keys := map[string]string{"wg0": "local", "wg1": "other", "wg2": "local", "mesh0": "mine"}
+	deviceKey := func(name string) (string, error) { return keys[name], nil }
+
+	name, ok := ownLinkName("wg", []byte("local"), links, deviceKey)
+	assert.True(t, ok)
+	assert.Equal(t, "wg2", name, "the dummy link is not a wireguard one")
+
+	_, ok = ownLinkName("wg", []byte("mine"), links, deviceKey)
+	assert.False(t, ok, "only the links with the base")
+
+	_, ok = ownLinkName("wg", []byte("local"), links, func(string) (string, error) { return "", errors.New("no device") })
+	assert.False(t, ok)
+}
+
+func TestConnectRejectsSmallMTU(t *testing.T) {
+	i := &Interface{
+		Backend:   NewMemoryBackend(),
+		Name:      "wg0",
+		PrefixLen: 24,
+		MTU:       1000,
+		LocalPeer: testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+	}
+
+	err := i.Connect(context.Background())
+	assert.EqualError(t, err, fmt.Sprintf(errMTUNotValid, minMTU, 1000))
+}
+
+func TestConnectRejectsNegativeMaxRetries(t *testing.T) {
+	i := &Interface{
+		Backend:    NewMemoryBackend(),
+		Name:       "wg0",
+		PrefixLen:  24,
+		MaxRetries: -1,
+		LocalPeer:  testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+	}
+
+	err := i.Connect(context.Background())
+	assert.EqualError(t, err, fmt.Sprintf(errMaxRetriesNotValid, -1))
+}
+
+// joinFailingBackend reads the peers but fails to join, e.g: a transient
+// error of the store.
+type joinFailingBackend struct{}
+
+func (joinFailingBackend) Join(ctx context.Context, ifname string, p Peer) error {
+	return errors.New("unreachable")
+}
+
+func (joinFailingBackend) Leave(ctx context.Context, ifname string, p Peer) error {
+	return nil
+}
+
+func (joinFailingBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	return nil, nil
+}
+
+func TestSetupRetriesJoinFailure(t *testing.T) {
+	i := &Interface{
+		Backend:     joinFailingBackend{},
+		Name:        "wg0",
+		PrefixLen:   24,
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: newFakeLinkManager(),
+	}
+
+	_, _, retryable, err := i.setup(context.Background())
+	assert.EqualError(t, err, "unreachable")
+	assert.True(t, retryable)
+}
+
+func TestConfigureLinkRollback(t *testing.T) {
+	links := newFakeLinkManager()
+	links.setConfErr = fmt.Errorf("setconf failed")
+	i := &Interface{
+		Name:        "wg0",
+		PrefixLen:   24,
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: links,
+	}
+	addr, err := i.localAddr()
+	assert.NoError(t, err)
+
+	// the link created for the failed configuration is deleted
+	err = i.configureLink(addr, nil, 2345)
+	assert.EqualError(t, err, "setconf failed")
+	assert.Empty(t, links.links)
+
+	// an existing link is kept
+	existing := &netlink.GenericLink{
+		LinkAttrs: netlink.LinkAttrs{Name: "wg0", MTU: defaultMTU},
+		LinkType:  wireguardLinkType,
+	}
+	links.links["wg0"] = existing
+	err = i.configureLink(addr, nil, 2345)
+	assert.EqualError(t, err, "setconf failed")
+	assert.Equal(t, existing, links.links["wg0"])
+}
@@ -0,0 +1,83 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+)
+
+// AllowedIPsPolicy tells which networks every peer is allowed to send
+// traffic from, and so which traffic the link sends to it.
+type AllowedIPsPolicy string
+
+// The policies of the allowed ips of the peers.
+const (
+	// AllowedIPsMesh allows every peer its own tunnel network, management
+	// address and advertised subnets, so that the peers reach each other
+	AllowedIPsMesh AllowedIPsPolicy = "mesh"
+	// AllowedIPsGateway also allows the peers flagged as Gateway the
+	// default route, so that the others send all their traffic through
+	// them, e.g: for a hub and spoke topology
+	AllowedIPsGateway AllowedIPsPolicy = "gateway"
+	// AllowedIPsCustom takes the allowed ips of every peer from the
+	// AllowedIPsFunc of the interface
+	AllowedIPsCustom AllowedIPsPolicy = "custom"
+)
+
+const (
+	errAllowedIPsPolicyNotValid = "the allowed ips policy must be %s, %s or %s, got: %q"
+	errNoAllowedIPsFunc         = "the custom allowed ips policy needs an AllowedIPsFunc"
+)
+
+func validateAllowedIPsPolicy(policy AllowedIPsPolicy) error {
+	switch policy {
+	case "", AllowedIPsMesh, AllowedIPsGateway, AllowedIPsCustom:
+		return nil
+	}
+	return fmt.Errorf(errAllowedIPsPolicyNotValid, AllowedIPsMesh, AllowedIPsGateway, AllowedIPsCustom, policy)
+}
+
+// checkAllowedIPsPolicy validates the policy of the interface.
+func (i *Interface) checkAllowedIPsPolicy() error {
+	if err := validateAllowedIPsPolicy(i.AllowedIPsPolicy); err != nil {
+		return err
+	}
+	if i.AllowedIPsPolicy == AllowedIPsCustom && i.AllowedIPsFunc == nil {
+		return fmt.Errorf(errNoAllowedIPsFunc)
+	}
+	return nil
+}
+
+// defaultRoute returns the default route of the family of the address
+// of the peer, IPv4 when it has none.
+func defaultRoute(p Peer) string {
+	if p.IP != nil && p.IP.To4() == nil {
+		return "::/0"
+	}
+	return "0.0.0.0/0"
+}
+
+// advertisedIPs returns the subnets routed through the peer in addition
+// to its tunnel network and management address: the ones it advertises
+// and, with the gateway policy, the default route of a gateway. The
+// ExcludedIPs are cut out of them.
+func (i *Interface) advertisedIPs(p Peer) []string {
+	advertised := p.AllowedIPs
+	if i.AllowedIPsPolicy == AllowedIPsGateway && p.Gateway {
+		advertised = append(append([]string{}, p.AllowedIPs...), defaultRoute(p))
+	}
+	return i.excludeIPs(advertised)
+}
+
+// customAllowedIPs returns the valid networks of AllowedIPsFunc for the
+// peer, logging the ones not valid.
+func (i *Interface) customAllowedIPs(p Peer) []string {
+	allowedIPs := []string{}
+	for _, a := range i.AllowedIPsFunc(p) {
+		if _, _, err := net.ParseCIDR(a); err != nil {
+			i.logger().Errorf("Ignoring the allowed ip %q of peer %s: %s", a, p.Endpoint, err.Error())
+			continue
+		}
+		allowedIPs = append(allowedIPs, a)
+	}
+	return allowedIPs
+}
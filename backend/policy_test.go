@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func policyRoutes(i *Interface, peers []Peer) []string {
+	_, local, _ := net.ParseCIDR("10.0.0.0/24")
+	routes := []string{}
+	for _, r := range i.peerRoutes(peers, local) {
+		routes = append(routes, r.String())
+	}
+	return routes
+}
+
+func TestAllowedIPsMesh(t *testing.T) {
+	i := &Interface{LocalPeer: testPeer("local", "10.0.0.1", "192.168.1.1:2345")}
+	gateway := testPeer("key1", "10.0.0.2", "192.168.1.2:2345")
+	gateway.Gateway = true
+
+	// the gateway flag is ignored
+	assert.Equal(t, []string{"10.0.0.2/32"}, i.peerAllowedIPs(gateway))
+	assert.Empty(t, policyRoutes(i, []Peer{gateway}))
+}
+
+func TestAllowedIPsGateway(t *testing.T) {
+	i := &Interface{
+		LocalPeer:        testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		AllowedIPsPolicy: AllowedIPsGateway,
+	}
+	gateway := testPeer("key1", "10.0.0.2", "192.168.1.2:2345")
+	gateway.Gateway = true
+	spoke := testPeer("key2", "10.0.0.3", "192.168.1.3:2345")
+	spoke.AllowedIPs = []string{"192.168.10.0/24"}
+
+	assert.Equal(t, []string{"10.0.0.2/32", "0.0.0.0/0"}, i.peerAllowedIPs(gateway))
+	assert.Equal(t, []string{"10.0.0.3/32", "192.168.10.0/24"}, i.peerAllowedIPs(spoke))
+	routes := policyRoutes(i, []Peer{i.LocalPeer, gateway, spoke})
+	// the default route of the host is kept, see mainTableRoutes
+	assert.NotContains(t, routes, "0.0.0.0/0")
+	assert.Contains(t, routes, "0.0.0.0/1")
+	assert.Contains(t, routes, "192.168.10.0/24")
+	i.RouteTable = 200
+	assert.Equal(t, []string{"0.0.0.0/0", "192.168.10.0/24"}, policyRoutes(i, []Peer{i.LocalPeer, gateway, spoke}))
+
+	gateway6 := testPeer("key3", "fd00::2", "192.168.1.4:2345")
+	gateway6.Gateway = true
+	assert.Equal(t, []string{"fd00::2/128", "::/0"}, i.peerAllowedIPs(gateway6))
+}
+
+func TestAllowedIPsCustom(t *testing.T) {
+	i := &Interface{
+		LocalPeer:        testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		AllowedIPsPolicy: AllowedIPsCustom,
+		AllowedIPsFunc: func(p Peer) []string {
+			return []string{p.IP.String() + "/32", "172.16.0.0/12", "not-a-network"}
+		},
+	}
+	p := testPeer("key1", "10.0.0.2", "192.168.1.2:2345")
+	p.AllowedIPs = []string{"192.168.10.0/24"}
+
+	assert.Equal(t, []string{"10.0.0.2/32", "172.16.0.0/12"}, i.peerAllowedIPs(p))
+	assert.Equal(t, []string{"172.16.0.0/12"}, policyRoutes(i, []Peer{i.LocalPeer, p}))
+}
+
+func TestCheckAllowedIPsPolicy(t *testing.T) {
+	i := &Interface{AllowedIPsPolicy: "star"}
+	assert.EqualError(t, i.checkAllowedIPsPolicy(),
+		fmt.Sprintf(errAllowedIPsPolicyNotValid, AllowedIPsMesh, AllowedIPsGateway, AllowedIPsCustom, "star"))
+
+	i.AllowedIPsPolicy = AllowedIPsCustom
+	assert.EqualError(t, i.checkAllowedIPsPolicy(), errNoAllowedIPsFunc)
+	i.AllowedIPsFunc = func(Peer) []string { return nil }
+	assert.NoError(t, i.checkAllowedIPsPolicy())
+
+	i.AllowedIPsPolicy = ""
+	assert.NoError(t, i.checkAllowedIPsPolicy())
+}
@@ -0,0 +1,46 @@
+package backend
+
+import (
+	"math/rand"
+	"time"
+)
+
+// pollJitter is the fraction of the interval the polls are randomly moved
+// by, so that the peers started together do not poll the backend together.
+const pollJitter = 0.1
+
+// pollSchedule spaces the reads of the peers: ttl apart while the peers
+// change, growing by half on every read without change up to maxTTL.
+type pollSchedule struct {
+	ttl      time.Duration
+	maxTTL   time.Duration
+	interval time.Duration
+	sha      string
+}
+
+func newPollSchedule(ttl, maxTTL time.Duration) *pollSchedule {
+	if maxTTL < ttl {
+		maxTTL = ttl
+	}
+	return &pollSchedule{ttl: ttl, maxTTL: maxTTL, interval: ttl}
+}
+
+// next returns the wait before the next read after reading the peers.
+func (s *pollSchedule) next(peers []Peer) time.Duration {
+	sha := extractPeersSHA(peers)
+	if sha != s.sha {
+		s.sha = sha
+		s.interval = s.ttl
+	} else if s.interval < s.maxTTL {
+		s.interval += s.interval / 2
+		if s.interval > s.maxTTL {
+			s.interval = s.maxTTL
+		}
+	}
+	return jitter(s.interval)
+}
+
+// jitter moves d randomly by up to pollJitter of it.
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration((rand.Float64()*2-1)*pollJitter*float64(d))
+}
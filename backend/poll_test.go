@@ -0,0 +1,44 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJitter(t *testing.T) {
+	for n := 0; n < 100; n++ {
+		d := jitter(10 * time.Second)
+		assert.True(t, d >= 9*time.Second && d <= 11*time.Second, d.String())
+	}
+}
+
+func TestPollScheduleBackoff(t *testing.T) {
+	s := newPollSchedule(10*time.Second, 30*time.Second)
+	peers := []Peer{testPeer("key1", "10.0.0.1", "192.168.1.1:2345")}
+
+	s.next(peers)
+	assert.Equal(t, 10*time.Second, s.interval)
+	s.next(peers)
+	assert.Equal(t, 15*time.Second, s.interval)
+	s.next(peers)
+	assert.Equal(t, 22500*time.Millisecond, s.interval)
+	s.next(peers)
+	assert.Equal(t, 30*time.Second, s.interval)
+	s.next(peers)
+	assert.Equal(t, 30*time.Second, s.interval)
+
+	// any change resets it
+	s.next(append(peers, testPeer("key2", "10.0.0.2", "192.168.1.2:2345")))
+	assert.Equal(t, 10*time.Second, s.interval)
+}
+
+func TestPollScheduleWithoutBackoff(t *testing.T) {
+	s := newPollSchedule(10*time.Second, 0)
+	peers := []Peer{testPeer("key1", "10.0.0.1", "192.168.1.1:2345")}
+
+	s.next(peers)
+	s.next(peers)
+	assert.Equal(t, 10*time.Second, s.interval)
+}
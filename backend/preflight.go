@@ -0,0 +1,210 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/influxdata/wirey/pkg/wireguard"
+	"github.com/vishvananda/netlink"
+)
+
+// PreflightFromConfig runs Preflight for the interface of the config
+// without changing the host: the key files are only read, the missing ones
+// are generated in memory for the other checks, and every key file gets a
+// check that it is readable with the right permissions. A config the
+// interface cannot be created from fails a check too.
+func PreflightFromConfig(b Backend, c *Config) PreflightReport {
+	keys := &preflightKeys{}
+	i, err := newInterfaceFromConfig(b, c, keys.load)
+	if err != nil {
+		return append(keys.report, PreflightCheck{
+			Name: "interface created",
+			Err:  err,
+			Hint: "fix the configuration, or the key file when it does not hold a valid key",
+		})
+	}
+	return append(keys.report, i.Preflight()...)
+}
+
+// preflightKeys is the keyLoader of PreflightFromConfig, it records a
+// check per key file.
+type preflightKeys struct {
+	report PreflightReport
+}
+
+func (k *preflightKeys) load(path string, generate func() (string, error), strict bool, logger Logger) ([]byte, error) {
+	check := PreflightCheck{Name: fmt.Sprintf("key file %s readable", path)}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		// generated there on the first start
+		k.report = append(k.report, check)
+		key, err := generate()
+		return []byte(key), err
+	}
+	if err == nil && strict && info.Mode().Perm()&0077 != 0 {
+		check.Err = fmt.Errorf(errKeyPermissions, path, info.Mode().Perm())
+		check.Hint = fmt.Sprintf("restrict the key file with: chmod 600 %s", path)
+	} else if key, err := ioutil.ReadFile(path); err != nil {
+		check.Err = fmt.Errorf(errPrivateKeyOpening, err)
+		check.Hint = "run wirey as a user able to read the key file"
+	} else {
+		k.report = append(k.report, check)
+		return bytes.TrimSpace(key), nil
+	}
+	k.report = append(k.report, check)
+	// the other checks go on with a key that is not stored
+	key, err := generate()
+	return []byte(key), err
+}
+
+// preflightLink is the name of the link created and deleted by Preflight,
+// apart from the one of the interface to not touch a running tunnel.
+const preflightLink = "wirey-preflight"
+
+// PreflightCheck is the outcome of one of the checks of Preflight.
+type PreflightCheck struct {
+	Name string
+	// Err is nil when the check passed
+	Err error
+	// Hint tells how to fix the failure
+	Hint string
+}
+
+// Passed tells if the check succeeded.
+func (c PreflightCheck) Passed() bool {
+	return c.Err == nil
+}
+
+func (c PreflightCheck) String() string {
+	if c.Passed() {
+		return fmt.Sprintf("[ok]   %s", c.Name)
+	}
+	return fmt.Sprintf("[fail] %s: %s\n       %s", c.Name, c.Err.Error(), c.Hint)
+}
+
+// PreflightReport holds the checks in the order they ran.
+type PreflightReport []PreflightCheck
+
+// Passed tells if all the checks succeeded.
+func (r PreflightReport) Passed() bool {
+	for _, c := range r {
+		if !c.Passed() {
+			return false
+		}
+	}
+	return true
+}
+
+func (r PreflightReport) String() string {
+	lines := make([]string, len(r))
+	for n, c := range r {
+		lines[n] = c.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Preflight checks that the host can run the interface without joining
+// the backend: that the key is valid, the backend answers, a wireguard
+// link can be created, configured and deleted, and the port is free. A
+// test link is used, the link of the interface is left untouched.
+// Observers only check the backend.
+func (i *Interface) Preflight() PreflightReport {
+	report := PreflightReport{i.preflightBackend()}
+	if i.Observer {
+		return report
+	}
+	report = append(report, i.preflightKey())
+	report = append(report, i.preflightLinks()...)
+	return append(report, i.preflightPort())
+}
+
+func (i *Interface) preflightBackend() PreflightCheck {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	err := i.PingBackend(ctx)
+	return PreflightCheck{
+		Name: "backend reachable",
+		Err:  err,
+		Hint: "check the address and the credentials of the backend, and that the host can reach it",
+	}
+}
+
+func (i *Interface) preflightKey() PreflightCheck {
+	_, err := wireguard.Pubkey(string(i.privateKey))
+	return PreflightCheck{
+		Name: "private key valid",
+		Err:  err,
+		Hint: "the key file must hold a key generated with wg genkey, delete it to generate a new one",
+	}
+}
+
+// preflightLinks creates the test link, configures it and deletes it.
+func (i *Interface) preflightLinks() PreflightReport {
+	link := &netlink.GenericLink{
+		LinkAttrs: netlink.LinkAttrs{Name: preflightLink, MTU: i.mtu()},
+		LinkType:  wireguardLinkType,
+	}
+	if existing, err := i.links().LinkByName(preflightLink); err == nil {
+		// left by a preflight that was interrupted
+		i.links().LinkDel(existing)
+	}
+
+	add := PreflightCheck{Name: "wireguard link created", Err: i.addLink(link)}
+	if add.Err != nil {
+		add.Hint = linkHint(add.Err)
+		return PreflightReport{add}
+	}
+	report := PreflightReport{add}
+
+	err := i.links().SetConf(preflightLink, wireguard.Configuration{
+		Interface: wireguard.Interface{PrivateKey: string(i.privateKey)},
+	})
+	report = append(report, PreflightCheck{
+		Name: "wireguard link configured",
+		Err:  err,
+		Hint: "the wireguard netlink api must be available, update the kernel or the wireguard module",
+	})
+
+	report = append(report, PreflightCheck{
+		Name: "wireguard link deleted",
+		Err:  i.links().LinkDel(link),
+		Hint: fmt.Sprintf("delete the link by hand with: ip link del %s", preflightLink),
+	})
+	return report
+}
+
+// linkHint tells why a link could not be created.
+func linkHint(err error) string {
+	switch {
+	case errors.Is(err, syscall.EPERM):
+		return "run wirey as root or grant it CAP_NET_ADMIN, e.g: setcap cap_net_admin+ep wirey"
+	case errors.Is(err, ErrNoWireguard), noWireguardSupport(err):
+		return "the kernel has no wireguard support, load it with: modprobe wireguard, or let wirey load it with the load module option"
+	}
+	return "check that the kernel supports wireguard links: ip link add wg-test type wireguard"
+}
+
+// preflightPort checks the port wireguard listens on, unless bound
+// already by the link of the interface.
+func (i *Interface) preflightPort() PreflightCheck {
+	check := PreflightCheck{
+		Name: "listen port free",
+		Hint: "stop the process using the port or set another one with the listen port",
+	}
+	port, err := i.listenPort()
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	if _, err := i.links().LinkByName(i.Name); err == nil {
+		return check
+	}
+	check.Err = checkUDPPort(port)
+	return check
+}
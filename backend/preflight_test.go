@@ -0,0 +1,103 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+type failingLinkManager struct {
+	*fakeLinkManager
+	err error
+}
+
+func (f *failingLinkManager) LinkAdd(link netlink.Link) error {
+	return f.err
+}
+
+func TestPreflight(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	assert.NoError(t, err)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	links := newFakeLinkManager()
+	i := &Interface{
+		Backend:     NewMemoryBackend(),
+		Name:        "wg0",
+		LocalPeer:   testPeer("local", "10.0.0.1", net.JoinHostPort("192.168.1.1", strconv.Itoa(port))),
+		LinkManager: links,
+		privateKey:  []byte("iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k="),
+	}
+	report := i.Preflight()
+	assert.True(t, report.Passed(), report.String())
+	assert.Len(t, report, 6)
+	// the test link is gone and the one of the interface never created
+	assert.Empty(t, links.links)
+
+	i.privateKey = []byte("not-a-key")
+	i.Backend = failingBackend{}
+	report = i.Preflight()
+	assert.False(t, report.Passed())
+	assert.False(t, report[0].Passed())
+	assert.False(t, report[1].Passed())
+	assert.Contains(t, report.String(), "[fail] backend reachable: unreachable")
+}
+
+func TestPreflightLinkHints(t *testing.T) {
+	i := &Interface{
+		Name:        "wg0",
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: &failingLinkManager{fakeLinkManager: newFakeLinkManager(), err: fmt.Errorf("operation not permitted: %w", syscall.EPERM)},
+	}
+	report := i.preflightLinks()
+	if assert.Len(t, report, 1) {
+		assert.True(t, errors.Is(report[0].Err, syscall.EPERM))
+		assert.Contains(t, report[0].Hint, "CAP_NET_ADMIN")
+	}
+
+	assert.Contains(t, linkHint(syscall.EOPNOTSUPP), "modprobe wireguard")
+}
+
+func TestPreflightKeysReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wirey")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	generate := func() (string, error) { return "generated", nil }
+	keys := &preflightKeys{}
+
+	// a missing key is generated in memory only
+	missing := filepath.Join(dir, "missing")
+	key, err := keys.load(missing, generate, true, StdLogger{})
+	assert.NoError(t, err)
+	assert.Equal(t, "generated", string(key))
+	_, err = os.Stat(missing)
+	assert.True(t, os.IsNotExist(err))
+
+	readable := filepath.Join(dir, "readable")
+	assert.NoError(t, ioutil.WriteFile(readable, []byte("stored\n"), 0600))
+	key, err = keys.load(readable, generate, true, StdLogger{})
+	assert.NoError(t, err)
+	assert.Equal(t, "stored", string(key))
+
+	open := filepath.Join(dir, "open")
+	assert.NoError(t, ioutil.WriteFile(open, []byte("stored\n"), 0644))
+	key, err = keys.load(open, generate, true, StdLogger{})
+	assert.NoError(t, err)
+	assert.Equal(t, "generated", string(key))
+
+	if assert.Len(t, keys.report, 3) {
+		assert.True(t, keys.report[0].Passed())
+		assert.True(t, keys.report[1].Passed())
+		assert.EqualError(t, keys.report[2].Err, fmt.Sprintf(errKeyPermissions, open, os.FileMode(0644)))
+	}
+}
@@ -0,0 +1,108 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// probeTimeout is how long a probe waits for the icmp error of an
+// unreachable endpoint.
+var probeTimeout = time.Second
+
+// UnreachablePeer is a peer whose endpoint refused the last probe.
+type UnreachablePeer struct {
+	Peer     Peer
+	Endpoint string
+	Err      string
+}
+
+// probeEndpoint sends a datagram to the udp endpoint and waits for an
+// icmp error. Wireguard never answers, so no answer within the timeout
+// means reachable: only the endpoints actively refusing, with nothing
+// listening or no route, are told apart, not the ones dropping packets.
+func probeEndpoint(endpoint string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("udp", endpoint, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{0}); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	_, err = conn.Read(make([]byte, 1))
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return nil
+	}
+	return err
+}
+
+// probeEndpoints probes the endpoints of the remote peers in the
+// background, when ProbeEndpoints is set, so that reading the peers does
+// not wait for the probe timeout. A read while a probe is running does
+// not start another one.
+func (i *Interface) probeEndpoints(peers []Peer) {
+	if !i.ProbeEndpoints {
+		return
+	}
+	i.stateMutex.Lock()
+	if i.probing {
+		i.stateMutex.Unlock()
+		return
+	}
+	i.probing = true
+	i.stateMutex.Unlock()
+
+	// captured here as a reload can change them while probing
+	local, logger := i.LocalPeer.PublicKey, i.logger()
+	go func() {
+		i.probePeers(peers, local, logger)
+		i.stateMutex.Lock()
+		i.probing = false
+		i.stateMutex.Unlock()
+	}()
+}
+
+// probePeers probes the endpoints of the peers but the local one
+// concurrently and records the ones unreachable.
+func (i *Interface) probePeers(peers []Peer, local []byte, logger Logger) {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	unreachable := []UnreachablePeer{}
+	for _, p := range peers {
+		if bytes.Equal(p.PublicKey, local) {
+			continue
+		}
+		wg.Add(1)
+		go func(p Peer) {
+			defer wg.Done()
+			if err := probeEndpoint(p.Endpoint, probeTimeout); err != nil {
+				mutex.Lock()
+				unreachable = append(unreachable, UnreachablePeer{Peer: p, Endpoint: p.Endpoint, Err: err.Error()})
+				mutex.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+	sort.Slice(unreachable, func(a, b int) bool {
+		return bytes.Compare(unreachable[a].Peer.PublicKey, unreachable[b].Peer.PublicKey) < 0
+	})
+
+	previous := map[string]bool{}
+	for _, u := range i.unreachable() {
+		previous[string(u.Peer.PublicKey)+u.Endpoint] = true
+	}
+	for _, u := range unreachable {
+		// log once, not on every probe
+		if !previous[string(u.Peer.PublicKey)+u.Endpoint] {
+			logger.Errorf("The endpoint %s of the peer %s is unreachable: %s", u.Endpoint, peerName(u.Peer), u.Err)
+		}
+	}
+	i.setUnreachable(unreachable)
+	unreachablePeersGauge.WithLabelValues(i.Name).Set(float64(len(unreachable)))
+}
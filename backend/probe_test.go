@@ -0,0 +1,63 @@
+package backend
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func closedUDPPort(t *testing.T) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(t, err)
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	return addr
+}
+
+func TestProbeEndpoint(t *testing.T) {
+	listening, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(t, err)
+	defer listening.Close()
+
+	// nothing answers but nothing refuses either
+	assert.NoError(t, probeEndpoint(listening.LocalAddr().String(), 100*time.Millisecond))
+	assert.Error(t, probeEndpoint(closedUDPPort(t), 100*time.Millisecond))
+}
+
+func TestProbeEndpoints(t *testing.T) {
+	probeTimeout = 100 * time.Millisecond
+	defer func() { probeTimeout = time.Second }()
+
+	listening, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(t, err)
+	defer listening.Close()
+
+	closed := closedUDPPort(t)
+	i := &Interface{Name: "wg0", LocalPeer: testPeer("key1", "10.0.0.1", closed)}
+	peers := []Peer{
+		i.LocalPeer,
+		testPeer("key2", "10.0.0.2", listening.LocalAddr().String()),
+		testPeer("key3", "10.0.0.3", closed),
+	}
+
+	i.probeEndpoints(peers)
+	assert.Empty(t, i.unreachable(), "not probed unless enabled")
+
+	i.ProbeEndpoints = true
+	start := time.Now()
+	i.probeEndpoints(peers)
+	assert.True(t, time.Since(start) < probeTimeout, "probed in the background")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(i.unreachable()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status, err := i.Status()
+	assert.NoError(t, err)
+	if assert.Len(t, status.Unreachable, 1) {
+		assert.Equal(t, "key3", string(status.Unreachable[0].Peer.PublicKey))
+		assert.Equal(t, closed, status.Unreachable[0].Endpoint)
+	}
+}
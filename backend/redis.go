@@ -0,0 +1,266 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	redisWireyPrefix   = "wirey"
+	redisAddressPrefix = "wirey-addresses"
+	redisScanCount     = 100
+)
+
+// RedisBackend stores every peer in its own key with a ttl,
+// the ttl is refreshed until the peer leaves so that the keys
+// of the dead nodes expire. The redis client has no context, the
+// contexts are checked before every command and the timeouts of
+// the client bound the commands themselves.
+type RedisBackend struct {
+	client     *redis.Client
+	ttl        time.Duration
+	mutex      *sync.Mutex
+	keepalives map[string]chan struct{}
+	// Logger reports the failed refreshes of the keys, StdLogger when nil
+	Logger Logger
+}
+
+func NewRedisBackend(addr, password string, ttl time.Duration) (*RedisBackend, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("the redis ttl must be positive, got: %s", ttl)
+	}
+	cli := redis.NewClient(&redis.Options{
+		Addr:        addr,
+		Password:    password,
+		DialTimeout: 5 * time.Second,
+	})
+	return &RedisBackend{
+		client:     cli,
+		ttl:        ttl,
+		mutex:      &sync.Mutex{},
+		keepalives: map[string]chan struct{}{},
+	}, nil
+}
+
+// Ping sends a PING.
+func (r *RedisBackend) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.client.Ping().Err()
+}
+
+func redisPeerKey(ifname string, p Peer) string {
+	return fmt.Sprintf("%s/%s/%s", redisWireyPrefix, ifname, publicKeySHA256(p.PublicKey))
+}
+
+func redisAddressKey(ifname string, p Peer) string {
+	return fmt.Sprintf("%s/%s/%s", redisAddressPrefix, ifname, p.IP.String())
+}
+
+// EntryTTL is the ttl of the keys of the peers.
+func (r *RedisBackend) EntryTTL() time.Duration {
+	return r.ttl
+}
+
+func (r *RedisBackend) Join(ctx context.Context, ifname string, p Peer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	pj, err := MarshalPeer(p)
+	if err != nil {
+		return err
+	}
+
+	key := redisPeerKey(ifname, p)
+	err = r.client.Set(key, pj, r.ttl).Err()
+	if err != nil {
+		return err
+	}
+
+	r.keepalive(key, map[string][]byte{key: pj})
+	return nil
+}
+
+// redisCompareAndJoin sets the address claim KEYS[1] and the peer KEYS[2]
+// unless the address is claimed by another public key.
+var redisCompareAndJoin = redis.NewScript(`
+local owner = redis.call("GET", KEYS[1])
+if owner and owner ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[3])
+redis.call("SET", KEYS[2], ARGV[2], "PX", ARGV[3])
+return 1
+`)
+
+// CompareAndJoin claims the address of the peer in wirey-addresses/<ifname>/<ip>
+// atomically with the peer key, the claim expires and is refreshed with it.
+func (r *RedisBackend) CompareAndJoin(ctx context.Context, ifname string, p Peer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	pj, err := MarshalPeer(p)
+	if err != nil {
+		return err
+	}
+
+	key := redisPeerKey(ifname, p)
+	addressKey := redisAddressKey(ifname, p)
+	joined, err := redisCompareAndJoin.Run(r.client, []string{addressKey, key}, string(p.PublicKey), string(pj), r.ttl.Nanoseconds()/int64(time.Millisecond)).Int()
+	if err != nil {
+		return err
+	}
+	if joined == 0 {
+		return AddressTakenError{IP: p.IP.String()}
+	}
+
+	r.keepalive(key, map[string][]byte{key: pj, addressKey: p.PublicKey})
+	return nil
+}
+
+// keepalive refreshes the values of the peer key until it leaves,
+// replacing the refresh started by a previous join.
+func (r *RedisBackend) keepalive(key string, values map[string][]byte) {
+	r.mutex.Lock()
+	if stop, ok := r.keepalives[key]; ok {
+		close(stop)
+	}
+	stop := make(chan struct{})
+	r.keepalives[key] = stop
+	r.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(r.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				for k, v := range values {
+					if err := r.client.Set(k, v, r.ttl).Err(); err != nil {
+						orStdLogger(r.Logger).Errorf("error refreshing the redis key %s: %s", k, err.Error())
+					}
+				}
+			}
+		}
+	}()
+}
+
+// redisLeave deletes the peer KEYS[2] and the address claim KEYS[1] when
+// it is still the one of the peer.
+var redisLeave = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+end
+redis.call("DEL", KEYS[2])
+return 1
+`)
+
+// Leave deletes the peer key and the address claimed by CompareAndJoin,
+// unless another peer claimed it meanwhile.
+func (r *RedisBackend) Leave(ctx context.Context, ifname string, p Peer) error {
+	key := redisPeerKey(ifname, p)
+
+	r.mutex.Lock()
+	if stop, ok := r.keepalives[key]; ok {
+		close(stop)
+		delete(r.keepalives, key)
+	}
+	r.mutex.Unlock()
+
+	// the refresh is stopped anyway, the key expires with its ttl
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if p.IP == nil {
+		return r.client.Del(key).Err()
+	}
+	return redisLeave.Run(r.client, []string{redisAddressKey(ifname, p), key}, string(p.PublicKey)).Err()
+}
+
+// Close stops refreshing the keys, the ones of the peers that did not
+// leave expire with their ttl, and closes the client.
+func (r *RedisBackend) Close() error {
+	r.mutex.Lock()
+	for key, stop := range r.keepalives {
+		close(stop)
+		delete(r.keepalives, key)
+	}
+	r.mutex.Unlock()
+	return r.client.Close()
+}
+
+// ListInterfaces scans the keys under wirey/.
+func (r *RedisBackend) ListInterfaces(ctx context.Context) ([]string, error) {
+	prefix := redisWireyPrefix + "/"
+	names := []string{}
+	var cursor uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		res, next, err := r.client.Scan(cursor, prefix+"*", redisScanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range res {
+			names = append(names, interfaceFromKey(key, prefix))
+		}
+		if next == 0 {
+			return names, nil
+		}
+		cursor = next
+	}
+}
+
+func (r *RedisBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	keys := []string{}
+	match := fmt.Sprintf("%s/%s/*", redisWireyPrefix, ifname)
+	var cursor uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		res, next, err := r.client.Scan(cursor, match, redisScanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, res...)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	peers := []Peer{}
+	if len(keys) == 0 {
+		return peers, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	values, err := r.client.MGet(keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		// the key expired between the scan and the get
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		peer, err := UnmarshalPeer([]byte(s))
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
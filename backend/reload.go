@@ -0,0 +1,168 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	errNoConfig      = "the interface was not created from a config"
+	errNotReloadable = "the %s cannot change without a restart"
+)
+
+// UpdateConfig makes Connect apply the config, validated against the one
+// the interface was created from with NewInterfaceFromConfig, on its next
+// iteration without leaving the backend. The peer joins again with its new
+// fields, the peers are read again and the link is reconfigured. It is only
+// recreated when its addresses change: the prefixlen or the managementaddr,
+// or the routetable of its routes.
+// b, when not nil, replaces the backend, e.g: for new backend parameters.
+//
+// The ifname, the ipaddr, the keys, the netns and the observer mode
+// cannot change, UpdateConfig fails without changing anything.
+func (i *Interface) UpdateConfig(b Backend, c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	i.stateMutex.Lock()
+	defer i.stateMutex.Unlock()
+	if i.config == nil {
+		return errors.New(errNoConfig)
+	}
+	if field := restartField(i.config, c); len(field) > 0 {
+		return fmt.Errorf(errNotReloadable, field)
+	}
+	i.pending = c
+	i.pendingBackend = b
+	i.signalReload()
+	return nil
+}
+
+// signalReload makes Connect apply what is pending on its next iteration,
+// it must be called with the state lock held.
+func (i *Interface) signalReload() {
+	if i.reload == nil {
+		i.reload = make(chan struct{}, 1)
+	}
+	select {
+	case i.reload <- struct{}{}:
+	default:
+		// a reload is pending already, it takes the last config
+	}
+}
+
+// restartField returns the name of the first field that changed and
+// needs a restart, empty if none.
+func restartField(current, c *Config) string {
+	fields := []struct {
+		name    string
+		changed bool
+	}{
+		{"ifname", current.Ifname != c.Ifname},
+		{"ipaddr", current.IPAddr != c.IPAddr},
+		{"privatekeypath", current.PrivateKeyPath != c.PrivateKeyPath},
+		{"privatekey", current.PrivateKey != c.PrivateKey},
+		{"presharedkeypath", current.PresharedKeyPath != c.PresharedKeyPath},
+		{"signingkeypath", current.SigningKeyPath != c.SigningKeyPath},
+		{"strictkeys", current.StrictKeys != c.StrictKeys},
+		{"netns", current.Netns != c.Netns},
+		{"observer", current.Observer != c.Observer},
+	}
+	for _, f := range fields {
+		if f.changed {
+			return f.name
+		}
+	}
+	return ""
+}
+
+// reloadc is ready when UpdateConfig was called.
+func (i *Interface) reloadc() <-chan struct{} {
+	i.stateMutex.Lock()
+	defer i.stateMutex.Unlock()
+	if i.reload == nil {
+		i.reload = make(chan struct{}, 1)
+	}
+	return i.reload
+}
+
+// applyPending applies the config passed to UpdateConfig, it tells if the
+// link must be recreated for its addresses or its routes. The peer leaves
+// the backend being replaced, which is closed, so that its lease, session
+// or refresh does not keep the entry alive there.
+func (i *Interface) applyPending(ctx context.Context) (recreate bool) {
+	i.stateMutex.Lock()
+	c, b := i.pending, i.pendingBackend
+	i.pending, i.pendingBackend = nil, nil
+	i.stateMutex.Unlock()
+	if c == nil {
+		return false
+	}
+
+	previous := i.Backend
+	if b != nil && !i.Observer {
+		if err := i.leaveBackend(ctx, previous); err != nil {
+			i.logger().Errorf("%s", fmt.Errorf(errLeave, err))
+		}
+	}
+
+	// the servers no longer wanted are reverted with the current ones
+	if dnsString(c.dns()) != dnsString(i.DNS) {
+		i.revertDNS()
+	}
+
+	i.stateMutex.Lock()
+	// deleting the link also deletes the routes in the previous table
+	recreate = c.PrefixLen != i.config.PrefixLen || c.ManagementAddr != i.config.ManagementAddr ||
+		c.RouteTable != i.config.RouteTable
+	if b != nil {
+		i.Backend = b
+	}
+	i.applyConfig(c)
+	i.stateMutex.Unlock()
+	if b != nil {
+		if err := closeBackend(previous); err != nil {
+			i.logger().Errorf("Error closing the previous backend: %s", err.Error())
+		}
+	}
+	i.logEvent("reloaded", map[string]interface{}{}, "Configuration reloaded")
+	return recreate
+}
+
+// refreshTicker returns the ticker refreshing LastSeen well before the
+// other peers consider us stale, nil without a PeerStaleness.
+func (i *Interface) refreshTicker() *time.Ticker {
+	if i.PeerStaleness <= 0 {
+		return nil
+	}
+	return time.NewTicker(i.PeerStaleness / 3)
+}
+
+// startWatch watches the peers until the returned function is called,
+// it is restarted on reload with the new backend and ttls.
+func (i *Interface) startWatch(ctx context.Context) (<-chan []Peer, context.CancelFunc, error) {
+	wctx, cancel := context.WithCancel(ctx)
+	peersc, err := watch(wctx, i.Backend, i.Name, i.peerCheckTTL(), i.peerCheckMaxTTL(), i.logger())
+	if err != nil {
+		cancel()
+		return nil, cancel, err
+	}
+	return debounce(wctx, peersc, i.PeerDebounce), cancel, nil
+}
+
+// tickerC returns the channel of the ticker, nil that never ticks
+// without a ticker.
+func tickerC(t *time.Ticker) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.C
+}
+
+func stopTicker(t *time.Ticker) {
+	if t != nil {
+		t.Stop()
+	}
+}
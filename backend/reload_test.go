@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func reloadableInterface(t *testing.T) (*Interface, *Config, *fakeLinkManager) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	assert.NoError(t, err)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	c := DefaultConfig()
+	c.Endpoint = net.JoinHostPort("192.168.1.1", strconv.Itoa(port))
+	c.IPAddr = "10.0.0.1"
+	links := newFakeLinkManager()
+	// as NewInterfaceFromConfig, without the wg command to read the key
+	i := &Interface{
+		Backend:     NewMemoryBackend(),
+		Name:        c.Ifname,
+		LocalPeer:   testPeer("local", c.IPAddr, c.Endpoint),
+		LinkManager: links,
+	}
+	i.applyConfig(c)
+	return i, c, links
+}
+
+func TestUpdateConfigRefused(t *testing.T) {
+	i := &Interface{Name: "wg0"}
+	c := DefaultConfig()
+	c.Endpoint = "192.168.1.1:2345"
+	c.IPAddr = "10.0.0.1"
+	assert.EqualError(t, i.UpdateConfig(nil, c), errNoConfig)
+
+	i, c, _ = reloadableInterface(t)
+	changed := *c
+	changed.Ifname = "wg1"
+	assert.EqualError(t, i.UpdateConfig(nil, &changed), fmt.Sprintf(errNotReloadable, "ifname"))
+
+	changed = *c
+	changed.MTU = 1000
+	assert.Error(t, i.UpdateConfig(nil, &changed))
+
+	// nothing was applied
+	assert.Equal(t, defaultMTU, i.MTU)
+	assert.Nil(t, i.pending)
+}
+
+func TestUpdateConfigWhileConnected(t *testing.T) {
+	i, c, links := reloadableInterface(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- i.Connect(ctx) }()
+	waitReady(t, i)
+
+	b := NewMemoryBackend()
+	changed := *c
+	changed.MTU = 1380
+	changed.PrefixLen = 16
+	changed.Hostname = "renamed"
+	assert.NoError(t, i.UpdateConfig(b, &changed))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		links.mutex.Lock()
+		link := links.links["wg0"]
+		addrs := links.addrs["wg0"]
+		links.mutex.Unlock()
+		if link != nil && link.Attrs().MTU == 1380 && len(addrs) == 1 && addrs[0].IPNet.String() == "10.0.0.1/16" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the new configuration was not applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// joined the new backend with the new fields
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	if assert.Len(t, peers, 1) {
+		assert.Equal(t, "renamed", peers[0].Hostname)
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
+
+// closingBackend records that it was closed.
+type closingBackend struct {
+	*MemoryBackend
+	closed bool
+}
+
+func (c *closingBackend) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestApplyPendingReplacesBackend(t *testing.T) {
+	i, c, _ := reloadableInterface(t)
+	previous := &closingBackend{MemoryBackend: NewMemoryBackend()}
+	i.Backend = previous
+	assert.NoError(t, i.join(context.Background()))
+
+	b := NewMemoryBackend()
+	assert.NoError(t, i.UpdateConfig(b, c))
+	assert.False(t, i.applyPending(context.Background()))
+
+	// the entry is not left behind in the previous store
+	peers, err := previous.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Empty(t, peers)
+	assert.True(t, previous.closed)
+	assert.Equal(t, b, i.Backend)
+}
@@ -0,0 +1,39 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+const (
+	errPeerNotFound    = "no peer with the public key %s in the interface %s"
+	errRemoveLocalPeer = "the peer %s is the local one, it leaves the interface on its own"
+)
+
+// RemovePeer deletes from the backend the peer of the interface with the
+// public key, e.g: a node that died without leaving. The peers drop its
+// tunnel on their next reconciliation, a node still running joins again.
+// The key is compared ignoring the surrounding whitespace.
+func RemovePeer(ctx context.Context, b Backend, ifname string, publicKey []byte) error {
+	peers, err := b.GetPeers(ctx, ifname)
+	if err != nil {
+		return err
+	}
+	for _, p := range peers {
+		if bytes.Equal(bytes.TrimSpace(p.PublicKey), bytes.TrimSpace(publicKey)) {
+			// the stored peer, the backends derive its key from the exact public key
+			return b.Leave(ctx, ifname, p)
+		}
+	}
+	return fmt.Errorf(errPeerNotFound, bytes.TrimSpace(publicKey), ifname)
+}
+
+// RemovePeer deletes the peer with the public key from the backend,
+// refusing to remove the local peer.
+func (i *Interface) RemovePeer(ctx context.Context, publicKey []byte) error {
+	if bytes.Equal(bytes.TrimSpace(i.LocalPeer.PublicKey), bytes.TrimSpace(publicKey)) {
+		return fmt.Errorf(errRemoveLocalPeer, bytes.TrimSpace(publicKey))
+	}
+	return RemovePeer(ctx, i.Backend, i.Name, publicKey)
+}
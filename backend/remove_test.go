@@ -0,0 +1,41 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemovePeer(t *testing.T) {
+	b := NewMemoryBackend()
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("key1\n", "10.0.0.1", "192.168.1.1:2345")))
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("key2\n", "10.0.0.2", "192.168.1.2:2345")))
+
+	assert.NoError(t, RemovePeer(context.Background(), b, "wg0", []byte("key1")))
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 1)
+	assert.Equal(t, []byte("key2\n"), peers[0].PublicKey)
+
+	assert.EqualError(t, RemovePeer(context.Background(), b, "wg0", []byte("key1")), fmt.Sprintf(errPeerNotFound, "key1", "wg0"))
+}
+
+func TestInterfaceRemovePeerKeepsLocalPeer(t *testing.T) {
+	b := NewMemoryBackend()
+	i := &Interface{
+		Backend:   b,
+		Name:      "wg0",
+		LocalPeer: testPeer("local\n", "10.0.0.1", "192.168.1.1:2345"),
+	}
+	assert.NoError(t, b.Join(context.Background(), "wg0", i.LocalPeer))
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("remote\n", "10.0.0.2", "192.168.1.2:2345")))
+
+	assert.EqualError(t, i.RemovePeer(context.Background(), []byte("local")), fmt.Sprintf(errRemoveLocalPeer, "local"))
+	assert.NoError(t, i.RemovePeer(context.Background(), []byte("remote")))
+
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Equal(t, []Peer{i.LocalPeer}, peers)
+}
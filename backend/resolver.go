@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+const (
+	errDNSNotValid = "the dns server must be an ip address, got: %q"
+	errNoResolver  = "neither resolvectl nor resolvconf is in the PATH"
+	errSetDNS      = "error setting the dns servers of the link: %w"
+)
+
+// Resolver is implemented by the LinkManagers able to make the system use
+// the dns servers through the link, e.g: for a full tunnel.
+type Resolver interface {
+	SetDNS(ifname string, servers []net.IP) error
+	RevertDNS(ifname string) error
+}
+
+// validateDNS checks that every dns server is an ip address.
+func validateDNS(servers []string) error {
+	for _, s := range servers {
+		if net.ParseIP(s) == nil {
+			return fmt.Errorf(errDNSNotValid, s)
+		}
+	}
+	return nil
+}
+
+// SetDNS uses systemd-resolved when available, otherwise resolvconf with
+// the interface name wg-quick uses. With systemd-resolved the link is
+// also made the route of all the domains, otherwise the queries keep
+// going to the servers of the other links.
+func (m *NetlinkLinkManager) SetDNS(ifname string, servers []net.IP) error {
+	if _, err := exec.LookPath("resolvectl"); err == nil {
+		args := []string{"dns", ifname}
+		for _, s := range servers {
+			args = append(args, s.String())
+		}
+		if err := runResolver(nil, "resolvectl", args...); err != nil {
+			return err
+		}
+		if err := runResolver(nil, "resolvectl", "domain", ifname, "~."); err != nil {
+			return err
+		}
+		// unknown before systemd 240, where the ~. domain is enough
+		runResolver(nil, "resolvectl", "default-route", ifname, "true")
+		return nil
+	}
+	conf := &bytes.Buffer{}
+	for _, s := range servers {
+		fmt.Fprintf(conf, "nameserver %s\n", s)
+	}
+	return runResolver(conf, "resolvconf", "-a", "tun."+ifname, "-m", "0", "-x")
+}
+
+func (m *NetlinkLinkManager) RevertDNS(ifname string) error {
+	if _, err := exec.LookPath("resolvectl"); err == nil {
+		return runResolver(nil, "resolvectl", "revert", ifname)
+	}
+	return runResolver(nil, "resolvconf", "-d", "tun."+ifname, "-f")
+}
+
+func runResolver(stdin *bytes.Buffer, name string, arg ...string) error {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return fmt.Errorf(errNoResolver)
+	}
+	cmd := exec.Command(path, arg...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %w - %s", name, strings.Join(arg, " "), err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+// setDNS points the resolver of the system to the DNS servers, if any.
+// They are only set again when they change or the link is created.
+func (i *Interface) setDNS() error {
+	if len(i.DNS) == 0 || dnsString(i.DNS) == i.dnsSet {
+		return nil
+	}
+	resolver, ok := i.links().(Resolver)
+	if !ok {
+		i.logger().Errorf("The dns servers are not set, the link manager cannot configure the resolver")
+		return nil
+	}
+	if err := resolver.SetDNS(i.Name, i.DNS); err != nil {
+		return fmt.Errorf(errSetDNS, err)
+	}
+	i.dnsSet = dnsString(i.DNS)
+	return nil
+}
+
+// revertDNS undoes setDNS, the errors are only logged to not prevent
+// the deletion of the link.
+func (i *Interface) revertDNS() {
+	i.dnsSet = ""
+	if len(i.DNS) == 0 {
+		return
+	}
+	resolver, ok := i.links().(Resolver)
+	if !ok {
+		return
+	}
+	if err := resolver.RevertDNS(i.Name); err != nil {
+		i.logger().Errorf("Error reverting the dns servers of the link: %s", err.Error())
+	}
+}
+
+// dnsString returns the DNS servers as wg-quick lists them.
+func dnsString(servers []net.IP) string {
+	s := make([]string, len(servers))
+	for n, server := range servers {
+		s[n] = server.String()
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type resolverLinkManager struct {
+	*fakeLinkManager
+	dns  map[string][]net.IP
+	sets int
+}
+
+func (r *resolverLinkManager) SetDNS(ifname string, servers []net.IP) error {
+	r.dns[ifname] = servers
+	r.sets++
+	return nil
+}
+
+func (r *resolverLinkManager) RevertDNS(ifname string) error {
+	delete(r.dns, ifname)
+	return nil
+}
+
+func TestValidateDNS(t *testing.T) {
+	assert.NoError(t, validateDNS(nil))
+	assert.NoError(t, validateDNS([]string{"1.1.1.1", "2606:4700:4700::1111"}))
+	assert.EqualError(t, validateDNS([]string{"1.1.1.1", "dns.example.com"}), `the dns server must be an ip address, got: "dns.example.com"`)
+}
+
+func TestDNSSetAndReverted(t *testing.T) {
+	links := &resolverLinkManager{fakeLinkManager: newFakeLinkManager(), dns: map[string][]net.IP{}}
+	i := &Interface{
+		Backend:     NewMemoryBackend(),
+		Name:        "wg0",
+		PrefixLen:   24,
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: links,
+	}
+	addr, err := i.localAddr()
+	assert.NoError(t, err)
+
+	// nothing is set unless asked
+	assert.NoError(t, i.configureLink(addr, nil, 2345))
+	assert.Empty(t, links.dns)
+
+	i.DNS = []net.IP{net.ParseIP("10.0.0.53")}
+	assert.NoError(t, i.configureLink(addr, nil, 2345))
+	assert.Equal(t, i.DNS, links.dns["wg0"])
+	assert.Equal(t, "10.0.0.53", i.configuration(nil, 2345).Interface.DNS)
+
+	// set again only when they change
+	assert.NoError(t, i.configureLink(addr, nil, 2345))
+	assert.Equal(t, 1, links.sets)
+	i.DNS = []net.IP{net.ParseIP("10.0.0.54")}
+	assert.NoError(t, i.configureLink(addr, nil, 2345))
+	assert.Equal(t, 2, links.sets)
+
+	assert.NoError(t, i.Disconnect())
+	assert.Empty(t, links.dns)
+
+	// and when the link is created again
+	assert.NoError(t, i.configureLink(addr, nil, 2345))
+	assert.Equal(t, 3, links.sets)
+}
+
+func TestDNSWithoutResolver(t *testing.T) {
+	i := &Interface{
+		Name:        "wg0",
+		PrefixLen:   24,
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: newFakeLinkManager(),
+		DNS:         []net.IP{net.ParseIP("10.0.0.53")},
+	}
+	addr, err := i.localAddr()
+	assert.NoError(t, err)
+	assert.NoError(t, i.configureLink(addr, nil, 2345))
+}
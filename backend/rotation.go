@@ -0,0 +1,221 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/wirey/pkg/wireguard"
+)
+
+const (
+	errRotationObserver   = "an observer has no key to rotate"
+	errRotationSameKey    = "the new private key is the current one"
+	errRotationInProgress = "the previous key is advertised until %s, rotate again after that"
+	errLeavePrevious      = "error leaving the backend with the previous key: %w"
+)
+
+// KeyRotationState is the step of the rotation of the private key of the
+// interface, see RotateKey.
+type KeyRotationState string
+
+// The steps of the rotation of the private key.
+const (
+	// KeyRotationIdle is the state of an interface with a single key
+	KeyRotationIdle KeyRotationState = "idle"
+	// KeyRotationPending is the state after RotateKey, until Connect
+	// switches to the new key
+	KeyRotationPending KeyRotationState = "pending"
+	// KeyRotationOverlap is the state while the link uses the new key
+	// and the backend still lists the peer with the previous one
+	KeyRotationOverlap KeyRotationState = "overlap"
+)
+
+// keyRotation holds the pending key until Connect switches to it, then
+// the end of the overlap.
+type keyRotation struct {
+	state      KeyRotationState
+	privateKey []byte
+	publicKey  []byte
+	overlap    time.Duration
+	ends       time.Time
+	timer      *time.Timer
+}
+
+// RotateKey makes Connect switch to the private key on its next iteration:
+// the link is configured with it and the peer joins with its public key,
+// naming the previous one in PreviousPublicKey, so that the other peers
+// replace the previous entry as soon as they read the new one. The entry
+// with the previous key stays in the backend for the overlap, for the peers
+// that did not read the new one yet, then it leaves. A zero overlap
+// defaults to twice the PeerCheckMaxTTL.
+//
+// The new key is not stored, save it, e.g: to the key file, so that the
+// next start uses it. The peers with AuthorizedKeys must allow it first.
+func (i *Interface) RotateKey(privateKey []byte, overlap time.Duration) error {
+	if i.Observer {
+		return errors.New(errRotationObserver)
+	}
+	publicKey, err := wireguard.ExtractPubKey(privateKey)
+	if err != nil {
+		return err
+	}
+	return i.rotateKey(privateKey, publicKey, overlap)
+}
+
+func (i *Interface) rotateKey(privateKey, publicKey []byte, overlap time.Duration) error {
+	i.stateMutex.Lock()
+	defer i.stateMutex.Unlock()
+	if bytes.Equal(publicKey, i.LocalPeer.PublicKey) {
+		return errors.New(errRotationSameKey)
+	}
+	if i.rotation.state == KeyRotationOverlap {
+		return fmt.Errorf(errRotationInProgress, i.rotation.ends.Format(time.RFC3339))
+	}
+	if overlap <= 0 {
+		overlap = 2 * i.peerCheckMaxTTL()
+	}
+	// a key pending already is replaced
+	i.rotation = keyRotation{
+		state:      KeyRotationPending,
+		privateKey: privateKey,
+		publicKey:  publicKey,
+		overlap:    overlap,
+	}
+	i.signalReload()
+	return nil
+}
+
+// startRotation switches to the pending key, if any, and starts the
+// overlap. The link is configured with the key on the next apply.
+func (i *Interface) startRotation() bool {
+	i.stateMutex.Lock()
+	r := &i.rotation
+	if r.state != KeyRotationPending {
+		i.stateMutex.Unlock()
+		return false
+	}
+	i.LocalPeer.PreviousPublicKey = i.LocalPeer.PublicKey
+	i.LocalPeer.PublicKey = r.publicKey
+	i.privateKey = r.privateKey
+	*r = keyRotation{
+		state: KeyRotationOverlap,
+		ends:  time.Now().Add(r.overlap),
+		timer: time.NewTimer(r.overlap),
+	}
+	ends := r.ends
+	i.stateMutex.Unlock()
+
+	i.logEvent("key_rotation_started", map[string]interface{}{"overlap_ends": ends.Format(time.RFC3339)},
+		"Switching to the new key, the previous one is advertised until %s", ends.Format(time.RFC3339))
+	return true
+}
+
+// rotationc is ready when the overlap of the rotation is over.
+func (i *Interface) rotationc() <-chan time.Time {
+	i.stateMutex.RLock()
+	defer i.stateMutex.RUnlock()
+	if i.rotation.timer == nil {
+		return nil
+	}
+	return i.rotation.timer.C
+}
+
+// finishRotation leaves the backend with the previous key and joins again,
+// claiming the address with the new one. When leaving fails it is tried
+// again after the PeerCheckTTL.
+func (i *Interface) finishRotation(ctx context.Context) error {
+	previous, ok := i.previousPeer()
+	if !ok {
+		return nil
+	}
+	if err := i.Backend.Leave(ctx, i.Name, previous); err != nil {
+		i.stateMutex.Lock()
+		i.rotation.timer = time.NewTimer(i.peerCheckTTL())
+		i.stateMutex.Unlock()
+		return fmt.Errorf(errLeavePrevious, err)
+	}
+
+	i.stateMutex.Lock()
+	i.LocalPeer.PreviousPublicKey = nil
+	i.rotation = keyRotation{}
+	i.stateMutex.Unlock()
+	i.logEvent("key_rotation_finished", map[string]interface{}{}, "Left the backend with the previous key")
+	return i.join(ctx)
+}
+
+// leaveBackend removes the local peer from b, and its entry with the
+// previous key during the overlap of a rotation.
+func (i *Interface) leaveBackend(ctx context.Context, b Backend) error {
+	if previous, ok := i.previousPeer(); ok {
+		if err := b.Leave(ctx, i.Name, previous); err != nil {
+			return fmt.Errorf(errLeavePrevious, err)
+		}
+	}
+	return b.Leave(ctx, i.Name, i.LocalPeer)
+}
+
+// rotationState returns the step of the rotation, it must be called
+// with the state lock held.
+func (i *Interface) rotationState() KeyRotationState {
+	if len(i.rotation.state) == 0 {
+		return KeyRotationIdle
+	}
+	return i.rotation.state
+}
+
+// previousPeer returns the local peer with the key it rotates from,
+// during the overlap of a rotation.
+func (i *Interface) previousPeer() (Peer, bool) {
+	if len(i.LocalPeer.PreviousPublicKey) == 0 {
+		return Peer{}, false
+	}
+	p := i.LocalPeer
+	p.PublicKey = p.PreviousPublicKey
+	p.PreviousPublicKey = nil
+	return p, true
+}
+
+// withoutPreviousKeys leaves out the peers whose key is the PreviousPublicKey
+// of another peer with the same address: the entry a peer rotating its key
+// keeps for the peers that did not read the new one yet. Anyone writing to
+// the backend could name the key of another peer, so the claim is only
+// honoured with the TrustedSigners, when both entries are signed by the
+// same signer. The previous entry of the local peer is always left out.
+func (i *Interface) withoutPreviousKeys(peers []Peer) []Peer {
+	previous := map[string]bool{}
+	if len(i.LocalPeer.PreviousPublicKey) > 0 {
+		previous[string(i.LocalPeer.PreviousPublicKey)] = true
+	}
+	if len(i.TrustedSigners) > 0 {
+		byKey := map[string]Peer{}
+		for _, p := range peers {
+			byKey[string(p.PublicKey)] = p
+		}
+		for _, p := range peers {
+			if len(p.PreviousPublicKey) == 0 || bytes.Equal(p.PreviousPublicKey, p.PublicKey) {
+				continue
+			}
+			old, ok := byKey[string(p.PreviousPublicKey)]
+			if !ok || !ipEqual(old.IP, p.IP) {
+				continue
+			}
+			signer := peerSigner(p, i.TrustedSigners)
+			if signer != nil && bytes.Equal(signer, peerSigner(old, i.TrustedSigners)) {
+				previous[string(p.PreviousPublicKey)] = true
+			}
+		}
+	}
+	if len(previous) == 0 {
+		return peers
+	}
+	kept := []Peer{}
+	for _, p := range peers {
+		if !previous[string(p.PublicKey)] {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,168 @@
+package backend
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rotationTestInterface(b Backend) *Interface {
+	return &Interface{
+		Backend:    b,
+		Name:       "wg0",
+		LocalPeer:  testPeer("old", "10.0.0.1", "192.168.1.1:2345"),
+		privateKey: []byte("old-private"),
+	}
+}
+
+func TestRotateKeyOverlap(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	i := rotationTestInterface(b)
+	assert.NoError(t, i.join(ctx))
+
+	assert.NoError(t, i.rotateKey([]byte("new-private"), []byte("new"), time.Hour))
+	status, err := i.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, KeyRotationPending, status.KeyRotation)
+	assert.Equal(t, "old", string(i.LocalPeer.PublicKey))
+	select {
+	case <-i.reloadc():
+	default:
+		t.Fatal("the rotation did not signal Connect")
+	}
+
+	assert.True(t, i.startRotation())
+	assert.Equal(t, "new", string(i.LocalPeer.PublicKey))
+	assert.Equal(t, "old", string(i.LocalPeer.PreviousPublicKey))
+	assert.Equal(t, "new-private", i.configuration(nil, 51820).Interface.PrivateKey)
+	assert.Equal(t, KeyRotationOverlap, i.rotationState())
+
+	// both keys are advertised, the peers keep the new one
+	assert.NoError(t, i.join(ctx))
+	peers, err := b.GetPeers(ctx, "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 2)
+	kept := i.withoutPreviousKeys(dedupPeers(peers))
+	assert.Len(t, kept, 1)
+	assert.Equal(t, "new", string(kept[0].PublicKey))
+
+	owner, err := i.addressOwner(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, owner)
+
+	assert.Error(t, i.rotateKey([]byte("other-private"), []byte("other"), time.Hour))
+
+	assert.NoError(t, i.finishRotation(ctx))
+	peers, err = b.GetPeers(ctx, "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 1)
+	assert.Equal(t, "new", string(peers[0].PublicKey))
+	assert.Nil(t, peers[0].PreviousPublicKey)
+	assert.Equal(t, KeyRotationIdle, i.rotationState())
+	assert.Nil(t, i.rotationc())
+}
+
+func TestRotateKeyErrors(t *testing.T) {
+	i := rotationTestInterface(NewMemoryBackend())
+	assert.Error(t, i.rotateKey([]byte("old-private"), []byte("old"), time.Hour))
+	assert.False(t, i.startRotation())
+
+	i.Observer = true
+	assert.Error(t, i.RotateKey([]byte("new-private"), time.Hour))
+}
+
+func TestRotateKeyDefaultOverlap(t *testing.T) {
+	i := rotationTestInterface(NewMemoryBackend())
+	i.PeerCheckTTL = time.Minute
+	assert.NoError(t, i.rotateKey([]byte("new-private"), []byte("new"), 0))
+	assert.Equal(t, 2*time.Minute, i.rotation.overlap)
+}
+
+func TestFinishRotationRetriesLeave(t *testing.T) {
+	i := rotationTestInterface(NewMemoryBackend())
+	i.PeerCheckTTL = time.Hour
+	assert.NoError(t, i.rotateKey([]byte("new-private"), []byte("new"), time.Hour))
+	i.startRotation()
+
+	i.Backend = failingBackend{}
+	assert.Error(t, i.finishRotation(context.Background()))
+	assert.Equal(t, KeyRotationOverlap, i.rotationState())
+	assert.NotNil(t, i.rotationc())
+	assert.Equal(t, "old", string(i.LocalPeer.PreviousPublicKey))
+}
+
+func TestWithoutPreviousKeys(t *testing.T) {
+	key := testSigningKey(t)
+	rotated := testPeer("new", "10.0.0.1", "192.168.1.1:2345")
+	rotated.PreviousPublicKey = []byte("old")
+	// another peer reusing the key elsewhere is not the previous entry
+	moved := testPeer("moved", "10.0.0.3", "192.168.1.3:2345")
+	moved.PreviousPublicKey = []byte("b")
+	old := testPeer("old", "10.0.0.1", "192.168.1.1:2345")
+	b := testPeer("b", "10.0.0.2", "192.168.1.2:2345")
+	i := &Interface{
+		LocalPeer:      testPeer("local", "10.0.0.4", "192.168.1.4:2345"),
+		TrustedSigners: []ed25519.PublicKey{key.Public().(ed25519.PublicKey), testSigningKey(t).Public().(ed25519.PublicKey)},
+	}
+
+	peers := []Peer{SignPeer(old, key), SignPeer(b, key), SignPeer(rotated, key), SignPeer(moved, key)}
+	assert.Equal(t, []Peer{peers[1], peers[2], peers[3]}, i.withoutPreviousKeys(peers))
+
+	// a claim by another signer is not honoured
+	other := testSigningKey(t)
+	i.TrustedSigners = append(i.TrustedSigners, other.Public().(ed25519.PublicKey))
+	peers = []Peer{SignPeer(old, key), SignPeer(rotated, other)}
+	assert.Equal(t, peers, i.withoutPreviousKeys(peers))
+
+	// neither is one that cannot be verified
+	i.TrustedSigners = nil
+	peers = []Peer{old, rotated}
+	assert.Equal(t, peers, i.withoutPreviousKeys(peers))
+
+	// the local peer knows its previous key
+	i.LocalPeer.PreviousPublicKey = []byte("old")
+	assert.Equal(t, []Peer{rotated}, i.withoutPreviousKeys(peers))
+}
+
+func TestConnectRotatesKey(t *testing.T) {
+	b := NewMemoryBackend()
+	i, links := signalsTestInterface(t, b)
+	i.privateKey = []byte("old-private")
+	assert.NoError(t, b.Join(context.Background(), "wg0", testPeer("remote", "10.0.0.2", "192.168.1.2:2345")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- i.Connect(ctx) }()
+	waitReady(t, i)
+
+	assert.NoError(t, i.rotateKey([]byte("new-private"), []byte("new"), 100*time.Millisecond))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		i.stateMutex.RLock()
+		state := i.rotationState()
+		i.stateMutex.RUnlock()
+		if state == KeyRotationIdle {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the rotation did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	keys := []string{}
+	for _, p := range peers {
+		keys = append(keys, string(p.PublicKey))
+	}
+	assert.ElementsMatch(t, []string{"new", "remote"}, keys)
+
+	cancel()
+	<-done
+	assert.Equal(t, "new-private", links.confs["wg0"].Interface.PrivateKey)
+}
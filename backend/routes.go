@@ -0,0 +1,238 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	errAllowedIPNotValid  = "allowed ip not valid %q: %w"
+	errRouteTableNotValid = "the route table cannot be negative, got: %d"
+)
+
+// validateAllowedIPs checks that every advertised subnet is in CIDR notation.
+func validateAllowedIPs(allowedIPs []string) error {
+	for _, a := range allowedIPs {
+		if _, _, err := net.ParseCIDR(a); err != nil {
+			return fmt.Errorf(errAllowedIPNotValid, a, err)
+		}
+	}
+	return nil
+}
+
+// validatePeerPrefixLen checks that the prefix length of the peer, when
+// set, fits the family of its address.
+func validatePeerPrefixLen(p Peer) error {
+	if p.PrefixLen == 0 {
+		return nil
+	}
+	if p.IP == nil || p.PrefixLen < 0 || p.PrefixLen > hostBits(*p.IP) {
+		return fmt.Errorf(errPrefixLenNotValid, ipString(p.IP), p.PrefixLen)
+	}
+	return nil
+}
+
+// peerNetwork returns the tunnel network of the peer: the subnet of its
+// PrefixLen when set, otherwise its single address, a /32 or a /128.
+func peerNetwork(p Peer) *net.IPNet {
+	bits := hostBits(*p.IP)
+	ones := bits
+	if p.PrefixLen > 0 && validatePeerPrefixLen(p) == nil {
+		ones = p.PrefixLen
+	}
+	mask := net.CIDRMask(ones, bits)
+	return &net.IPNet{IP: p.IP.Mask(mask), Mask: mask}
+}
+
+// peerAllowedIPs returns the networks the peer is allowed to send traffic
+// from: its own tunnel network, see peerNetwork, the single address of its
+// management address, plus the valid subnets it advertises, see
+// advertisedIPs. The custom policy replaces all of them.
+func (i *Interface) peerAllowedIPs(p Peer) []string {
+	if i.AllowedIPsPolicy == AllowedIPsCustom {
+		return i.customAllowedIPs(p)
+	}
+	if err := validatePeerPrefixLen(p); err != nil {
+		i.logger().Errorf("Ignoring the prefix length of peer %s: %s", p.Endpoint, err.Error())
+	}
+	allowedIPs := []string{peerNetwork(p).String()}
+	if err := validateManagementAddr(p.ManagementAddr); err != nil {
+		i.logger().Errorf("Ignoring the management address of peer %s: %s", p.Endpoint, err.Error())
+	}
+	if host := managementHost(p); host != nil {
+		allowedIPs = append(allowedIPs, host.String())
+	}
+	for _, a := range i.advertisedIPs(p) {
+		if _, _, err := net.ParseCIDR(a); err != nil {
+			i.logger().Errorf("Ignoring the allowed ip %q of peer %s: %s", a, p.Endpoint, err.Error())
+			continue
+		}
+		allowedIPs = append(allowedIPs, a)
+	}
+	return allowedIPs
+}
+
+// peerRoutes returns the destinations to route through the link: the
+// valid subnets advertised by the remote peers, their tunnel networks and
+// management addresses not within the local networks, the ones inside are
+// on-link already. With the custom policy, the networks of AllowedIPsFunc
+// not within the local networks.
+func (i *Interface) peerRoutes(peers []Peer, local *net.IPNet) []*net.IPNet {
+	locals := []*net.IPNet{local}
+	if _, management, err := net.ParseCIDR(i.LocalPeer.ManagementAddr); err == nil {
+		locals = append(locals, management)
+	}
+	onLink := func(network *net.IPNet) bool {
+		for _, l := range locals {
+			if contains(l, network) {
+				return true
+			}
+		}
+		return false
+	}
+
+	routes := []*net.IPNet{}
+	for _, p := range peers {
+		if bytes.Equal(p.PublicKey, i.LocalPeer.PublicKey) {
+			continue
+		}
+		if i.AllowedIPsPolicy == AllowedIPsCustom {
+			for _, a := range i.customAllowedIPs(p) {
+				if _, dst, _ := net.ParseCIDR(a); !onLink(dst) {
+					routes = append(routes, dst)
+				}
+			}
+			continue
+		}
+		if p.IP != nil {
+			if network := peerNetwork(p); !onLink(network) {
+				routes = append(routes, network)
+			}
+		}
+		if host := managementHost(p); host != nil && !onLink(host) {
+			routes = append(routes, host)
+		}
+		for _, a := range i.advertisedIPs(p) {
+			_, dst, err := net.ParseCIDR(a)
+			if err != nil {
+				continue
+			}
+			routes = append(routes, dst)
+		}
+	}
+	if i.RouteTable == 0 {
+		return mainTableRoutes(routes, peers)
+	}
+	return routes
+}
+
+// mainTableRoutes keeps the routes through the link from taking over the
+// traffic of the host in the main table: a default route is installed as
+// its two halves, so that the one of the host is never replaced nor lost
+// with the link, and the endpoints of the peers are cut out, so that the
+// encrypted packets keep the route of the host instead of looping into
+// the tunnel.
+func mainTableRoutes(routes []*net.IPNet, peers []Peer) []*net.IPNet {
+	halves := []*net.IPNet{}
+	for _, r := range routes {
+		if ones, bits := r.Mask.Size(); ones == 0 {
+			mask := net.CIDRMask(1, bits)
+			low := &net.IPNet{IP: r.IP.Mask(mask), Mask: mask}
+			high := &net.IPNet{IP: append(net.IP{}, low.IP...), Mask: mask}
+			high.IP[0] |= 0x80
+			halves = append(halves, low, high)
+			continue
+		}
+		halves = append(halves, r)
+	}
+	return excludeNetworks(halves, endpointHosts(peers))
+}
+
+// endpointHosts returns the single address networks of the endpoints of
+// the peers given as ip addresses, the hostnames are skipped.
+func endpointHosts(peers []Peer) []*net.IPNet {
+	hosts := []*net.IPNet{}
+	for _, p := range peers {
+		for _, e := range append([]string{p.Endpoint}, p.Endpoints...) {
+			host, _, err := net.SplitHostPort(e)
+			if err != nil {
+				continue
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				continue
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			bits := len(ip) * 8
+			hosts = append(hosts, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+	return hosts
+}
+
+// contains tells if the network b is within the network a.
+func contains(a, b *net.IPNet) bool {
+	aOnes, _ := a.Mask.Size()
+	bOnes, _ := b.Mask.Size()
+	return a.Contains(b.IP) && aOnes <= bOnes
+}
+
+// staleRoutes returns the routes in installed that are not in routes anymore.
+func staleRoutes(installed, routes []*net.IPNet) []*net.IPNet {
+	current := map[string]bool{}
+	for _, r := range routes {
+		current[r.String()] = true
+	}
+	stale := []*net.IPNet{}
+	for _, r := range installed {
+		if !current[r.String()] {
+			stale = append(stale, r)
+		}
+	}
+	return stale
+}
+
+// validateRouteTable checks the id of the routing table, 0 is the main one.
+func validateRouteTable(table int) error {
+	if table < 0 {
+		return fmt.Errorf(errRouteTableNotValid, table)
+	}
+	return nil
+}
+
+// route returns the route to dst through the link, in the RouteTable.
+func (i *Interface) route(link netlink.Link, dst *net.IPNet) *netlink.Route {
+	return &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Scope:     netlink.SCOPE_LINK,
+		Dst:       dst,
+		Table:     i.RouteTable,
+	}
+}
+
+// installRoutes routes the peers destinations through the link and removes
+// the routes of the peers that left. Deleting the link removes all of them.
+func (i *Interface) installRoutes(link netlink.Link, local *net.IPNet, peers []Peer) error {
+	routes := i.peerRoutes(peers, local)
+	for _, dst := range routes {
+		if err := i.links().RouteReplace(i.route(link, dst)); err != nil {
+			return fmt.Errorf("error adding the route to %s: %w", dst.String(), err)
+		}
+	}
+
+	for _, dst := range staleRoutes(i.routes, routes) {
+		// the route could be gone already, e.g: with the link
+		if err := i.links().RouteDel(i.route(link, dst)); err != nil && !os.IsNotExist(err) && err != syscall.ESRCH {
+			return fmt.Errorf("error deleting the route to %s: %w", dst.String(), err)
+		}
+	}
+	i.routes = routes
+	return nil
+}
@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestValidateAllowedIPs(t *testing.T) {
+	assert.NoError(t, validateAllowedIPs(nil))
+	assert.NoError(t, validateAllowedIPs([]string{"192.168.10.0/24", "fd00::/64"}))
+	assert.Error(t, validateAllowedIPs([]string{"192.168.10.0/24", "192.168.11.0"}))
+}
+
+func TestPeerAllowedIPs(t *testing.T) {
+	i := &Interface{}
+	p := testPeer("key1", "10.0.0.2", "192.168.1.2:2345")
+	p.AllowedIPs = []string{"192.168.10.0/24", "not a cidr", "192.168.11.0/24"}
+
+	assert.Equal(t, []string{"10.0.0.2/32", "192.168.10.0/24", "192.168.11.0/24"}, i.peerAllowedIPs(p))
+}
+
+func TestPeerAllowedIPsIPv6(t *testing.T) {
+	i := &Interface{}
+	p := testPeer("key1", "fd00::2", "[2001:db8::2]:2345")
+	p.AllowedIPs = []string{"fd01::/64"}
+
+	assert.Equal(t, []string{"fd00::2/128", "fd01::/64"}, i.peerAllowedIPs(p))
+}
+
+func TestPeerAllowedIPsPrefixLen(t *testing.T) {
+	i := &Interface{}
+	p := testPeer("key1", "10.0.0.18", "192.168.1.2:2345")
+	p.PrefixLen = 28
+	assert.Equal(t, []string{"10.0.0.16/28"}, i.peerAllowedIPs(p))
+
+	// not valid for the family, the single address is used
+	p = testPeer("key1", "10.0.0.18", "192.168.1.2:2345")
+	p.PrefixLen = 64
+	assert.Error(t, validatePeerPrefixLen(p))
+	assert.Equal(t, []string{"10.0.0.18/32"}, i.peerAllowedIPs(p))
+
+	p = testPeer("key1", "fd00::2", "[2001:db8::2]:2345")
+	p.PrefixLen = 64
+	assert.NoError(t, validatePeerPrefixLen(p))
+	assert.Equal(t, []string{"fd00::/64"}, i.peerAllowedIPs(p))
+}
+
+func TestPeerAllowedIPsManagementAddr(t *testing.T) {
+	i := &Interface{}
+	p := testPeer("key1", "10.0.0.2", "192.168.1.2:2345")
+	p.ManagementAddr = "10.99.0.2/24"
+	p.AllowedIPs = []string{"192.168.10.0/24"}
+	assert.Equal(t, []string{"10.0.0.2/32", "10.99.0.2/32", "192.168.10.0/24"}, i.peerAllowedIPs(p))
+
+	p.ManagementAddr = "10.99.0.2"
+	assert.Error(t, validateManagementAddr(p.ManagementAddr))
+	assert.Equal(t, []string{"10.0.0.2/32", "192.168.10.0/24"}, i.peerAllowedIPs(p))
+}
+
+func TestPeerRoutesManagementAddr(t *testing.T) {
+	i := &Interface{LocalPeer: testPeer("local", "10.0.0.1", "192.168.1.1:2345")}
+	i.LocalPeer.ManagementAddr = "10.99.0.1/24"
+	_, local, _ := net.ParseCIDR("10.0.0.0/24")
+
+	// on-link through the local management network
+	inside := testPeer("key1", "10.0.0.2", "192.168.1.2:2345")
+	inside.ManagementAddr = "10.99.0.2/24"
+	outside := testPeer("key2", "10.0.0.3", "192.168.1.3:2345")
+	outside.ManagementAddr = "10.98.0.3/24"
+
+	routes := []string{}
+	for _, r := range i.peerRoutes([]Peer{i.LocalPeer, inside, outside}, local) {
+		routes = append(routes, r.String())
+	}
+	assert.Equal(t, []string{"10.98.0.3/32"}, routes)
+}
+
+func TestPeerRoutes(t *testing.T) {
+	i := &Interface{LocalPeer: testPeer("local", "10.0.0.1", "192.168.1.1:2345")}
+	_, local, _ := net.ParseCIDR("10.0.0.0/24")
+
+	inside := testPeer("key1", "10.0.0.2", "192.168.1.2:2345")
+	inside.AllowedIPs = []string{"192.168.10.0/24"}
+	outside := testPeer("key2", "10.0.1.3", "192.168.1.3:2345")
+	// a subnet inside the local network is on-link, a bigger one is not
+	insideSubnet := testPeer("key3", "10.0.0.18", "192.168.1.4:2345")
+	insideSubnet.PrefixLen = 28
+	biggerSubnet := testPeer("key4", "10.0.2.5", "192.168.1.5:2345")
+	biggerSubnet.PrefixLen = 16
+
+	routes := []string{}
+	for _, r := range i.peerRoutes([]Peer{i.LocalPeer, inside, outside, insideSubnet, biggerSubnet}, local) {
+		routes = append(routes, r.String())
+	}
+	assert.Equal(t, []string{"192.168.10.0/24", "10.0.1.3/32", "10.0.0.0/16"}, routes)
+}
+
+func TestMainTableRoutes(t *testing.T) {
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+	_, all6, _ := net.ParseCIDR("::/0")
+	_, lan, _ := net.ParseCIDR("192.168.10.0/24")
+	gateway := testPeer("key1", "10.0.0.2", "198.51.100.7:51820")
+	gateway.Endpoints = []string{"[2001:db8::7]:51820", "gateway.example.com:51820"}
+
+	routes := mainTableRoutes([]*net.IPNet{all, all6, lan}, []Peer{gateway})
+	kept := map[string]bool{}
+	for _, r := range routes {
+		kept[r.String()] = true
+		ones, _ := r.Mask.Size()
+		assert.NotZero(t, ones, "the default route of the host is replaced")
+		assert.False(t, r.Contains(net.ParseIP("198.51.100.7")), "the endpoint is routed through the link")
+		assert.False(t, r.Contains(net.ParseIP("2001:db8::7")), "the endpoint is routed through the link")
+	}
+	assert.True(t, kept["0.0.0.0/1"])
+	assert.True(t, kept["8000::/1"])
+	assert.True(t, kept["192.168.10.0/24"])
+	// the halves without the endpoint address
+	assert.Len(t, routes, 1+31+1+127+1)
+}
+
+func TestStaleRoutes(t *testing.T) {
+	_, a, _ := net.ParseCIDR("192.168.10.0/24")
+	_, b, _ := net.ParseCIDR("192.168.11.0/24")
+	_, c, _ := net.ParseCIDR("192.168.12.0/24")
+
+	assert.Equal(t, []*net.IPNet{a}, staleRoutes([]*net.IPNet{a, b}, []*net.IPNet{b, c}))
+	assert.Empty(t, staleRoutes(nil, []*net.IPNet{a}))
+}
+
+func TestInstallRoutesTable(t *testing.T) {
+	links := newFakeLinkManager()
+	i := &Interface{
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: links,
+		RouteTable:  200,
+	}
+	link := &netlink.GenericLink{LinkAttrs: netlink.LinkAttrs{Name: "wg0", Index: 7}}
+	_, local, _ := net.ParseCIDR("10.0.0.0/24")
+	p := testPeer("key1", "10.0.0.2", "192.168.1.2:2345")
+	p.AllowedIPs = []string{"192.168.10.0/24"}
+
+	assert.NoError(t, i.installRoutes(link, local, []Peer{i.LocalPeer, p}))
+	if assert.Contains(t, links.routes, "192.168.10.0/24") {
+		route := links.routes["192.168.10.0/24"]
+		assert.Equal(t, 200, route.Table)
+		assert.Equal(t, 7, route.LinkIndex)
+	}
+
+	assert.EqualError(t, validateRouteTable(-1), fmt.Sprintf(errRouteTableNotValid, -1))
+}
@@ -0,0 +1,110 @@
+package backend
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+const (
+	errSigningKeyNotValid    = "the signing key must be a base64 ed25519 seed: %w"
+	errTrustedSignerNotValid = "the trusted signer must be a base64 ed25519 public key, got: %q"
+)
+
+// GenerateSigningKey returns a new ed25519 seed, base64 encoded, for SigningKey.
+func GenerateSigningKey() (string, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(seed), nil
+}
+
+// ParseSigningKey decodes a key returned by GenerateSigningKey.
+func ParseSigningKey(key []byte) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(string(key))
+	if err != nil {
+		return nil, fmt.Errorf(errSigningKeyNotValid, err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf(errSigningKeyNotValid, fmt.Errorf("got %d bytes instead of %d", len(seed), ed25519.SeedSize))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ParseTrustedSigner decodes a base64 ed25519 public key for TrustedSigners.
+func ParseTrustedSigner(signer string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(signer)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf(errTrustedSignerNotValid, signer)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// signedPayload is what the signature of the peer covers:
+// its json encoding without the signature.
+func signedPayload(p Peer) []byte {
+	p.Signature = nil
+	pj, _ := MarshalPeer(p)
+	return pj
+}
+
+// SignPeer returns the peer with the Signature of all its fields by key.
+func SignPeer(p Peer, key ed25519.PrivateKey) Peer {
+	p.Signature = ed25519.Sign(key, signedPayload(p))
+	return p
+}
+
+// VerifyPeer tells if the peer is signed by one of the signers.
+func VerifyPeer(p Peer, signers []ed25519.PublicKey) bool {
+	return peerSigner(p, signers) != nil
+}
+
+// peerSigner returns the one of the signers the peer is signed by, nil
+// when none.
+func peerSigner(p Peer, signers []ed25519.PublicKey) ed25519.PublicKey {
+	if len(p.Signature) == 0 {
+		return nil
+	}
+	payload := signedPayload(p)
+	for _, signer := range signers {
+		if ed25519.Verify(signer, payload, p.Signature) {
+			return signer
+		}
+	}
+	return nil
+}
+
+// signed returns the local peer p as it is stored in the backend,
+// signed when the interface has a SigningKey.
+func (i *Interface) signed(p Peer) Peer {
+	if i.SigningKey == nil {
+		return p
+	}
+	return SignPeer(p, i.SigningKey)
+}
+
+// trustedPeers leaves out the peers not signed by any of the
+// TrustedSigners, all the peers are trusted when there are none.
+func (i *Interface) trustedPeers(peers []Peer) []Peer {
+	if len(i.TrustedSigners) == 0 {
+		return peers
+	}
+	trusted := []Peer{}
+	untrusted := map[string]bool{}
+	for _, p := range peers {
+		if VerifyPeer(p, i.TrustedSigners) {
+			trusted = append(trusted, p)
+			continue
+		}
+		key := string(p.PublicKey)
+		untrusted[key] = true
+		// log once, not on every read of the peers
+		if !i.untrusted[key] {
+			i.logger().Errorf("Ignoring the peer %s at %s, it is not signed by a trusted signer", peerName(p), p.Endpoint)
+		}
+	}
+	i.untrusted = untrusted
+	return trusted
+}
@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSigningKey(t *testing.T) ed25519.PrivateKey {
+	seed, err := GenerateSigningKey()
+	assert.NoError(t, err)
+	key, err := ParseSigningKey([]byte(seed))
+	assert.NoError(t, err)
+	return key
+}
+
+func TestSignPeer(t *testing.T) {
+	key := testSigningKey(t)
+	signers := []ed25519.PublicKey{testSigningKey(t).Public().(ed25519.PublicKey), key.Public().(ed25519.PublicKey)}
+
+	p := testPeer("key1", "10.0.0.1", "192.168.1.1:2345")
+	p.AllowedIPs = []string{"192.168.10.0/24"}
+	now := time.Now().UTC()
+	p.LastSeen = &now
+	signed := SignPeer(p, key)
+	assert.True(t, VerifyPeer(signed, signers))
+	assert.False(t, VerifyPeer(p, signers))
+
+	// the signature survives the backends
+	pj, err := json.Marshal(signed)
+	assert.NoError(t, err)
+	stored := Peer{}
+	assert.NoError(t, json.Unmarshal(pj, &stored))
+	assert.True(t, VerifyPeer(stored, signers))
+
+	// any change invalidates it
+	stored.AllowedIPs = []string{"0.0.0.0/0"}
+	assert.False(t, VerifyPeer(stored, signers))
+	assert.False(t, VerifyPeer(signed, signers[:1]))
+}
+
+func TestParseSigningKey(t *testing.T) {
+	_, err := ParseSigningKey([]byte("not base64"))
+	assert.Error(t, err)
+	_, err = ParseSigningKey([]byte("c2hvcnQ="))
+	assert.Error(t, err)
+
+	_, err = ParseTrustedSigner("c2hvcnQ=")
+	assert.EqualError(t, err, `the trusted signer must be a base64 ed25519 public key, got: "c2hvcnQ="`)
+}
+
+func TestTrustedPeers(t *testing.T) {
+	key := testSigningKey(t)
+	i := &Interface{}
+	signed := SignPeer(testPeer("key1", "10.0.0.1", "192.168.1.1:2345"), key)
+	forged := testPeer("key2", "10.0.0.2", "192.168.1.2:2345")
+	forged.Signature = signed.Signature
+	unsigned := testPeer("key3", "10.0.0.3", "192.168.1.3:2345")
+	peers := []Peer{signed, forged, unsigned}
+
+	// no signers, all the peers are trusted
+	assert.Equal(t, peers, i.trustedPeers(peers))
+
+	i.TrustedSigners = []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}
+	assert.Equal(t, []Peer{signed}, i.trustedPeers(peers))
+	assert.Equal(t, map[string]bool{"key2": true, "key3": true}, i.untrusted)
+}
+
+func TestJoinSignsLocalPeer(t *testing.T) {
+	key := testSigningKey(t)
+	b := NewMemoryBackend()
+	i := &Interface{
+		Backend:    b,
+		Name:       "wg0",
+		LocalPeer:  testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		SigningKey: key,
+	}
+	assert.NoError(t, i.join(context.Background()))
+
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Len(t, peers, 1)
+	assert.True(t, VerifyPeer(peers[0], []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}))
+}
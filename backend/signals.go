@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// RunWithSignals runs Connect until the process receives SIGINT or SIGTERM,
+// then leaves the backend and deletes the link with Disconnect, so that the
+// node does not stay registered after it is stopped. A second signal exits
+// the process right away with status 1, e.g: when the backend hangs.
+// It returns nil when stopped by a signal, otherwise the error of Connect.
+func RunWithSignals(i *Interface) error {
+	sigc := make(chan os.Signal, 2)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	return runWithSignals(i, sigc, os.Exit)
+}
+
+func runWithSignals(i *Interface, sigc <-chan os.Signal, exit func(int)) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case sig := <-sigc:
+			i.logger().Infof("Received %s, leaving", sig)
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-sigc:
+			i.logger().Errorf("Received %s while leaving, exiting", sig)
+			exit(1)
+		case <-done:
+		}
+	}()
+
+	err := i.Connect(ctx)
+	if ctx.Err() == nil || i.DryRun {
+		return err
+	}
+	// the backend has been left already when Connect returned, leaving
+	// again is harmless and Disconnect also deletes the link
+	if !i.Observer {
+		if err := i.Disconnect(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingBackend blocks the leaves until released, it tells on leaving
+// when one starts.
+type blockingBackend struct {
+	Backend
+	leaving chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBackend) Leave(ctx context.Context, ifname string, p Peer) error {
+	b.leaving <- struct{}{}
+	<-b.release
+	return b.Backend.Leave(ctx, ifname, p)
+}
+
+func signalsTestInterface(t *testing.T, b Backend) (*Interface, *fakeLinkManager) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	assert.NoError(t, err)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	links := newFakeLinkManager()
+	return &Interface{
+		Backend:      b,
+		Name:         "wg0",
+		PeerCheckTTL: time.Second,
+		PrefixLen:    24,
+		LocalPeer:    testPeer("local", "10.0.0.1", net.JoinHostPort("192.168.1.1", strconv.Itoa(port))),
+		LinkManager:  links,
+	}, links
+}
+
+func waitReady(t *testing.T, i *Interface) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := i.Status()
+		if err == nil && status.Ready() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the link was not configured")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunWithSignals(t *testing.T) {
+	b := NewMemoryBackend()
+	i, links := signalsTestInterface(t, b)
+
+	sigc := make(chan os.Signal, 2)
+	done := make(chan error)
+	go func() { done <- runWithSignals(i, sigc, func(int) { t.Error("exited on the first signal") }) }()
+	waitReady(t, i)
+
+	sigc <- syscall.SIGTERM
+	assert.NoError(t, <-done)
+
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	assert.NoError(t, err)
+	assert.Empty(t, peers)
+	_, err = links.LinkByName("wg0")
+	assert.Error(t, err)
+}
+
+func TestRunWithSignalsSecondSignalExits(t *testing.T) {
+	b := &blockingBackend{Backend: NewMemoryBackend(), leaving: make(chan struct{}, 2), release: make(chan struct{})}
+	i, _ := signalsTestInterface(t, b)
+
+	sigc := make(chan os.Signal, 2)
+	exited := make(chan int, 1)
+	done := make(chan error)
+	go func() { done <- runWithSignals(i, sigc, func(code int) { exited <- code }) }()
+	waitReady(t, i)
+
+	sigc <- syscall.SIGINT
+	<-b.leaving
+	sigc <- syscall.SIGINT
+	assert.Equal(t, 1, <-exited)
+
+	// the exit is faked, let the leaves go through
+	close(b.release)
+	assert.NoError(t, <-done)
+}
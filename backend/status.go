@@ -0,0 +1,225 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"time"
+
+	"github.com/influxdata/wirey/pkg/wireguard"
+	"github.com/vishvananda/netlink"
+)
+
+// Status is a snapshot of the state of an Interface.
+type Status struct {
+	// Build is the build of wirey running the interface
+	Build     BuildInfo
+	LocalPeer Peer
+	// Peers are the peers returned by the last successful poll of the backend
+	Peers []Peer
+	// PeersSHA identifies the peers the link is currently configured with,
+	// see the PeersSHA function
+	PeersSHA string
+	// PeersSHAChanged is when PeersSHA last changed, zero before the first
+	PeersSHAChanged time.Time
+	// StableCycles is the number of reads of the peers in a row that found
+	// the peers of PeersSHA since it changed
+	StableCycles int
+	// Converged is true once StableCycles reaches the ConvergenceCycles
+	Converged bool
+	// OperState is the state of the link, OperNotPresent if it does not exist
+	OperState netlink.LinkOperState
+	// Up tells if the link is administratively up, wireguard links usually
+	// report an unknown OperState even when they are working
+	Up bool
+	// BackendHealthy is true when the last call to the backend succeeded
+	BackendHealthy bool
+	// Observer is true when the interface only watches the peers
+	Observer bool
+	// PeerStats is the state of the tunnels read from the device, nil
+	// when the LinkManager is not a StatsReader or the link is missing
+	PeerStats []wireguard.PeerStats
+	// Conflicts are the peers left out of the link for their networks
+	// overlapping the ones of another peer
+	Conflicts []PeerConflict
+	// Unreachable are the peers whose endpoint refused the last probe,
+	// empty without ProbeEndpoints
+	Unreachable []UnreachablePeer
+	// KeyRotation is the step of the rotation of the private key
+	KeyRotation KeyRotationState
+}
+
+// Healthy tells if the last call to the backend succeeded and the link is up,
+// observers have no link.
+func (s *Status) Healthy() bool {
+	if s.Observer {
+		return s.BackendHealthy
+	}
+	return s.BackendHealthy && s.Up && s.OperState != netlink.OperDown
+}
+
+// SilentPeers returns the peers in the backend without a handshake in the
+// last maxAge, e.g: the ones unreachable or with a wrong key. It is empty
+// when the PeerStats are not known. Wireguard only handshakes while the
+// peers exchange packets, set a PersistentKeepalive to tell them apart
+// from the idle ones.
+func (s *Status) SilentPeers(maxAge time.Duration, now time.Time) []Peer {
+	silent := []Peer{}
+	if s.PeerStats == nil {
+		return silent
+	}
+	handshakes := map[string]time.Time{}
+	for _, stats := range s.PeerStats {
+		handshakes[stats.PublicKey] = stats.LastHandshake
+	}
+	for _, p := range s.Peers {
+		if bytes.Equal(p.PublicKey, s.LocalPeer.PublicKey) {
+			continue
+		}
+		if now.Sub(handshakes[string(bytes.TrimSpace(p.PublicKey))]) > maxAge {
+			silent = append(silent, p)
+		}
+	}
+	return silent
+}
+
+// Ready tells if the link has been configured with the peers at least once,
+// or for observers if the peers have been read.
+func (s *Status) Ready() bool {
+	return len(s.PeersSHA) > 0
+}
+
+// Status returns the current state of the interface, it can be called
+// while Connect is running to check if the node converged.
+func (i *Interface) Status() (*Status, error) {
+	i.stateMutex.RLock()
+	status := &Status{
+		Build:           GetBuildInfo(),
+		LocalPeer:       i.LocalPeer,
+		Peers:           append([]Peer{}, i.peers...),
+		PeersSHA:        i.peersSHA,
+		PeersSHAChanged: i.shaChanged,
+		StableCycles:    i.stableCycles,
+		Converged:       i.converged(),
+		OperState:       netlink.OperNotPresent,
+		BackendHealthy:  i.backendHealthy,
+		Observer:        i.Observer,
+		Conflicts:       append([]PeerConflict{}, i.peerConflicts...),
+		Unreachable:     append([]UnreachablePeer{}, i.probeFailures...),
+		KeyRotation:     i.rotationState(),
+	}
+	i.stateMutex.RUnlock()
+	if i.Observer {
+		return status, nil
+	}
+
+	link, err := i.links().LinkByName(i.Name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return status, nil
+		}
+		return nil, err
+	}
+	status.OperState = link.Attrs().OperState
+	status.Up = link.Attrs().Flags&net.FlagUp != 0
+	status.PeerStats = i.peerStats()
+	return status, nil
+}
+
+// peerStats reads the state of the tunnels from the device, nil when it
+// cannot be read.
+func (i *Interface) peerStats() []wireguard.PeerStats {
+	reader, ok := i.links().(StatsReader)
+	if !ok {
+		return nil
+	}
+	stats, err := reader.PeerStats(i.Name)
+	if err != nil {
+		i.logger().Debugf("Cannot read the state of the peers from the device: %s", err.Error())
+		return nil
+	}
+	return stats
+}
+
+// setPeers records the peers returned by a successful poll of the backend.
+func (i *Interface) setPeers(peers []Peer) {
+	i.stateMutex.Lock()
+	i.peers = peers
+	i.backendHealthy = true
+	i.stateMutex.Unlock()
+}
+
+// setConflicts records the peers left out of the link.
+func (i *Interface) setConflicts(conflicts []PeerConflict) {
+	i.stateMutex.Lock()
+	i.peerConflicts = conflicts
+	i.stateMutex.Unlock()
+}
+
+func (i *Interface) conflicts() []PeerConflict {
+	i.stateMutex.RLock()
+	defer i.stateMutex.RUnlock()
+	return i.peerConflicts
+}
+
+// setUnreachable records the peers whose endpoint refused the probe.
+func (i *Interface) setUnreachable(unreachable []UnreachablePeer) {
+	i.stateMutex.Lock()
+	i.probeFailures = unreachable
+	i.stateMutex.Unlock()
+}
+
+func (i *Interface) unreachable() []UnreachablePeer {
+	i.stateMutex.RLock()
+	defer i.stateMutex.RUnlock()
+	return i.probeFailures
+}
+
+// PingBackend checks that the backend answers, without reading the peers
+// of the interface when the backend is a Pinger.
+func (i *Interface) PingBackend(ctx context.Context) error {
+	return ping(ctx, i.Backend)
+}
+
+// backendFailed records a failed call to the backend.
+func (i *Interface) backendFailed() {
+	i.stateMutex.Lock()
+	i.backendHealthy = false
+	i.stateMutex.Unlock()
+	backendErrorsCounter.WithLabelValues(i.Name).Inc()
+}
+
+// setPeersSHA records the peers the link has been reconfigured with,
+// the count of the stable cycles starts again when they changed.
+func (i *Interface) setPeersSHA(sha string) {
+	i.stateMutex.Lock()
+	previous := i.peersSHA
+	i.peersSHA = sha
+	if sha != previous {
+		i.shaChanged = time.Now()
+		i.stableCycles = 0
+	}
+	changed, converged := i.shaChanged, i.converged()
+	i.stateMutex.Unlock()
+	observeReconfiguration(i.Name, previous, sha)
+	observeConvergence(i.Name, changed, converged)
+}
+
+// stableCycle records a read of the peers that found the peers of the
+// current PeersSHA.
+func (i *Interface) stableCycle() {
+	i.stateMutex.Lock()
+	i.stableCycles++
+	changed, converged := i.shaChanged, i.converged()
+	i.stateMutex.Unlock()
+	observeConvergence(i.Name, changed, converged)
+}
+
+// converged must be called holding the stateMutex.
+func (i *Interface) converged() bool {
+	cycles := i.ConvergenceCycles
+	if cycles <= 0 {
+		cycles = defaultConvergenceCycles
+	}
+	return len(i.peersSHA) > 0 && i.stableCycles >= cycles
+}
@@ -0,0 +1,123 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestStatus(t *testing.T) {
+	i := &Interface{
+		Name:      "wireytest0",
+		LocalPeer: testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+	}
+	peers := []Peer{i.LocalPeer, testPeer("other", "10.0.0.2", "192.168.1.2:2345")}
+	i.setPeers(peers)
+	i.setPeersSHA(extractPeersSHA(peers))
+
+	status, err := i.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, i.LocalPeer, status.LocalPeer)
+	assert.Equal(t, peers, status.Peers)
+	assert.Equal(t, extractPeersSHA(peers), status.PeersSHA)
+	assert.Equal(t, netlink.LinkOperState(netlink.OperNotPresent), status.OperState)
+	assert.Equal(t, GetBuildInfo(), status.Build)
+}
+
+func TestStatusPeerStats(t *testing.T) {
+	now := time.Now()
+	links := &handshakeLinkManager{
+		fakeLinkManager: newFakeLinkManager(),
+		handshakes:      map[string]time.Time{"peer2": now.Add(-time.Minute), "peer3": now.Add(-time.Hour)},
+	}
+	i := &Interface{
+		Name:        "wg0",
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: links,
+	}
+	peers := []Peer{
+		i.LocalPeer,
+		testPeer("peer2\n", "10.0.0.2", "192.168.1.2:2345"),
+		testPeer("peer3\n", "10.0.0.3", "192.168.1.3:2345"),
+		testPeer("peer4\n", "10.0.0.4", "192.168.1.4:2345"),
+	}
+	i.setPeers(peers)
+
+	// no link, no stats
+	status, err := i.Status()
+	assert.NoError(t, err)
+	assert.Nil(t, status.PeerStats)
+	assert.Empty(t, status.SilentPeers(5*time.Minute, now))
+
+	links.links["wg0"] = &netlink.GenericLink{LinkAttrs: netlink.LinkAttrs{Name: "wg0"}, LinkType: wireguardLinkType}
+	status, err = i.Status()
+	assert.NoError(t, err)
+	assert.Len(t, status.PeerStats, 2)
+	// peer3 handshaked too long ago, peer4 never did
+	assert.Equal(t, []Peer{peers[2], peers[3]}, status.SilentPeers(5*time.Minute, now))
+}
+
+func TestStatusConverged(t *testing.T) {
+	i := &Interface{
+		Name:              "wireytest0",
+		LocalPeer:         testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		ConvergenceCycles: 2,
+	}
+	status, err := i.Status()
+	assert.NoError(t, err)
+	assert.False(t, status.Converged)
+	assert.True(t, status.PeersSHAChanged.IsZero())
+
+	peers := []Peer{i.LocalPeer}
+	i.setPeersSHA(extractPeersSHA(peers))
+	i.stableCycle()
+	status, err = i.Status()
+	assert.NoError(t, err)
+	assert.False(t, status.Converged)
+	assert.Equal(t, 1, status.StableCycles)
+	changed := status.PeersSHAChanged
+	assert.False(t, changed.IsZero())
+
+	// reconfigured with the same peers, e.g: after a reconnection
+	i.setPeersSHA(extractPeersSHA(peers))
+	i.stableCycle()
+	status, err = i.Status()
+	assert.NoError(t, err)
+	assert.True(t, status.Converged)
+	assert.Equal(t, changed, status.PeersSHAChanged)
+
+	peers = append(peers, testPeer("other", "10.0.0.2", "192.168.1.2:2345"))
+	i.setPeersSHA(extractPeersSHA(peers))
+	status, err = i.Status()
+	assert.NoError(t, err)
+	assert.False(t, status.Converged)
+	assert.Equal(t, 0, status.StableCycles)
+}
+
+func TestApplyCountsStableCycles(t *testing.T) {
+	links := newFakeLinkManager()
+	i := &Interface{
+		Name:        "wg0",
+		PrefixLen:   24,
+		LocalPeer:   testPeer("local", "10.0.0.1", "192.168.1.1:2345"),
+		LinkManager: links,
+	}
+	addr, err := i.localAddr()
+	assert.NoError(t, err)
+	peers := []Peer{i.LocalPeer, testPeer("other", "10.0.0.2", "192.168.1.2:2345")}
+
+	for n := 0; n < defaultConvergenceCycles; n++ {
+		assert.NoError(t, i.apply(addr, peers, 2345))
+		status, err := i.Status()
+		assert.NoError(t, err)
+		assert.Equal(t, n, status.StableCycles)
+		assert.False(t, status.Converged)
+	}
+	assert.NoError(t, i.apply(addr, peers, 2345))
+	status, err := i.Status()
+	assert.NoError(t, err)
+	assert.True(t, status.Converged)
+	assert.Equal(t, PeersSHA(peers), status.PeersSHA)
+}
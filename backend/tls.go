@@ -0,0 +1,59 @@
+package backend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+const (
+	errTLSCertKey    = "the tls cert and key must be set together, got cert: %q and key: %q"
+	errTLSCA         = "error reading the tls ca: %w"
+	errTLSCANotValid = "no pem certificate found in the tls ca %s"
+	errTLSKeyPair    = "error loading the tls cert and key: %w"
+)
+
+// TLSConfig holds the paths of the pem files to connect to a backend over
+// tls: the CA verifies the server, when empty the ones of the system do,
+// the cert and key authenticate the client.
+type TLSConfig struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// Enabled tells if any of the files is set.
+func (t TLSConfig) Enabled() bool {
+	return t.CAFile != "" || t.CertFile != "" || t.KeyFile != ""
+}
+
+// Load reads and checks the files, it returns nil when none is set.
+func (t TLSConfig) Load() (*tls.Config, error) {
+	if !t.Enabled() {
+		return nil, nil
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return nil, fmt.Errorf(errTLSCertKey, t.CertFile, t.KeyFile)
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if t.CAFile != "" {
+		ca, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf(errTLSCA, err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf(errTLSCANotValid, t.CAFile)
+		}
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf(errTLSKeyPair, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCert writes a self signed cert and its key in dir.
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "wirey"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestTLSConfigLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wirey-tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+
+	config, err := TLSConfig{}.Load()
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+
+	config, err = TLSConfig{CAFile: certFile, CertFile: certFile, KeyFile: keyFile}.Load()
+	assert.NoError(t, err)
+	assert.NotNil(t, config.RootCAs)
+	assert.Len(t, config.Certificates, 1)
+}
+
+func TestTLSConfigLoadNotValid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wirey-tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+
+	_, err = TLSConfig{CertFile: certFile}.Load()
+	assert.EqualError(t, err, `the tls cert and key must be set together, got cert: "`+certFile+`" and key: ""`)
+
+	_, err = TLSConfig{CAFile: keyFile}.Load()
+	assert.EqualError(t, err, "no pem certificate found in the tls ca "+keyFile)
+
+	_, err = TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}.Load()
+	assert.Error(t, err)
+
+	_, err = TLSConfig{CertFile: keyFile, KeyFile: certFile}.Load()
+	assert.Error(t, err)
+}
+
+func TestNewConsulBackendTLSNotValid(t *testing.T) {
+	_, err := NewConsulBackendWithTLS("127.0.0.1:8500", "", "", TLSConfig{KeyFile: "key.pem"}, consulMinTTL)
+	assert.EqualError(t, err, `error configuring the consul tls: the tls cert and key must be set together, got cert: "" and key: "key.pem"`)
+}
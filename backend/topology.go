@@ -0,0 +1,94 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The formats of WriteTopology.
+const (
+	TopologyJSON = "json"
+	TopologyDOT  = "dot"
+)
+
+const errTopologyFormatNotValid = "the topology format must be %s or %s, got: %q"
+
+// TopologyNode is a peer of the mesh as exported by WriteTopology.
+type TopologyNode struct {
+	// Name is the hostname of the peer, its fingerprint when unknown
+	Name     string
+	IP       string `json:",omitempty"`
+	Endpoint string
+	// Fingerprint identifies the public key without exposing it in full
+	Fingerprint string
+}
+
+// fingerprint is the start of the hex sha256 of the public key.
+func fingerprint(publicKey []byte) string {
+	sum := sha256.Sum256(bytes.TrimSpace(publicKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Topology returns the nodes of the mesh formed by the peers, as returned
+// by GetPeers, a single node per public key ordered by public key.
+func Topology(peers []Peer) []TopologyNode {
+	peers = dedupPeers(peers)
+	sortPeers(peers)
+	nodes := make([]TopologyNode, len(peers))
+	for n, p := range peers {
+		nodes[n] = TopologyNode{
+			Name:        p.Hostname,
+			Endpoint:    p.Endpoint,
+			Fingerprint: fingerprint(p.PublicKey),
+		}
+		if len(p.Hostname) == 0 {
+			nodes[n].Name = nodes[n].Fingerprint
+		}
+		if p.IP != nil {
+			nodes[n].IP = p.IP.String()
+		}
+	}
+	return nodes
+}
+
+// WriteTopology writes the mesh formed by the peers as JSON or as a
+// Graphviz DOT graph, where every peer is connected to all the others.
+// It only needs the peers in the backend, e.g: from an observer.
+func WriteTopology(w io.Writer, peers []Peer, format string) error {
+	nodes := Topology(peers)
+	switch format {
+	case TopologyJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(nodes)
+	case TopologyDOT:
+		return writeDOT(w, nodes)
+	}
+	return fmt.Errorf(errTopologyFormatNotValid, TopologyJSON, TopologyDOT, format)
+}
+
+func writeDOT(w io.Writer, nodes []TopologyNode) error {
+	dot := &strings.Builder{}
+	dot.WriteString("graph mesh {\n")
+	for _, n := range nodes {
+		label := []string{n.Name}
+		if len(n.IP) > 0 {
+			label = append(label, n.IP)
+		}
+		label = append(label, n.Endpoint)
+		fmt.Fprintf(dot, "  %q [label=%q];\n", n.Fingerprint, strings.Join(label, "\n"))
+	}
+	for a := range nodes {
+		for b := a + 1; b < len(nodes); b++ {
+			fmt.Fprintf(dot, "  %q -- %q;\n", nodes[a].Fingerprint, nodes[b].Fingerprint)
+		}
+	}
+	dot.WriteString("}\n")
+	_, err := io.WriteString(w, dot.String())
+	return err
+}
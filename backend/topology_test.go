@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func topologyPeers() []Peer {
+	a := testPeer("key2", "10.0.0.2", "192.168.1.2:2345")
+	a.Hostname = "node-2"
+	b := testPeer("key1", "10.0.0.1", "192.168.1.1:2345")
+	return []Peer{a, b}
+}
+
+func TestTopology(t *testing.T) {
+	assert.Equal(t, []TopologyNode{
+		{Name: fingerprint([]byte("key1")), IP: "10.0.0.1", Endpoint: "192.168.1.1:2345", Fingerprint: fingerprint([]byte("key1"))},
+		{Name: "node-2", IP: "10.0.0.2", Endpoint: "192.168.1.2:2345", Fingerprint: fingerprint([]byte("key2"))},
+	}, Topology(append(topologyPeers(), testPeer("key1", "10.0.0.1", "192.168.1.1:2345"))))
+}
+
+func TestWriteTopologyDOT(t *testing.T) {
+	out := &bytes.Buffer{}
+	assert.NoError(t, WriteTopology(out, topologyPeers(), TopologyDOT))
+	key1, key2 := fingerprint([]byte("key1")), fingerprint([]byte("key2"))
+	assert.Equal(t, fmt.Sprintf(`graph mesh {
+  "%s" [label="%s\n10.0.0.1\n192.168.1.1:2345"];
+  "%s" [label="node-2\n10.0.0.2\n192.168.1.2:2345"];
+  "%s" -- "%s";
+}
+`, key1, key1, key2, key1, key2), out.String())
+}
+
+func TestWriteTopologyJSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	assert.NoError(t, WriteTopology(out, topologyPeers(), TopologyJSON))
+	assert.Contains(t, out.String(), `"Name": "node-2"`)
+	assert.NotContains(t, out.String(), "PublicKey")
+}
+
+func TestWriteTopologyInvalidFormat(t *testing.T) {
+	err := WriteTopology(&bytes.Buffer{}, nil, "svg")
+	assert.EqualError(t, err, fmt.Sprintf(errTopologyFormatNotValid, TopologyJSON, TopologyDOT, "svg"))
+}
@@ -0,0 +1,36 @@
+package backend
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// The build of wirey, set with -ldflags, e.g:
+// -X github.com/influxdata/wirey/backend.Version=v1.2.0
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// BuildInfo identifies the running build of wirey.
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	BuildDate string
+	GoVersion string
+}
+
+func (b BuildInfo) String() string {
+	return fmt.Sprintf("%s (commit: %s, built: %s, %s)", b.Version, b.Commit, b.BuildDate, b.GoVersion)
+}
+
+// GetBuildInfo returns the version, commit and build date set at build time.
+func GetBuildInfo() BuildInfo {
+	return BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
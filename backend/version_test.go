@@ -0,0 +1,24 @@
+package backend
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBuildInfo(t *testing.T) {
+	defer func(version, commit, date string) {
+		Version, Commit, BuildDate = version, commit, date
+	}(Version, Commit, BuildDate)
+	Version, Commit, BuildDate = "v1.2.0", "abc1234", "2020-01-02T03:04:05Z"
+
+	build := GetBuildInfo()
+	assert.Equal(t, BuildInfo{
+		Version:   "v1.2.0",
+		Commit:    "abc1234",
+		BuildDate: "2020-01-02T03:04:05Z",
+		GoVersion: runtime.Version(),
+	}, build)
+	assert.Equal(t, "v1.2.0 (commit: abc1234, built: 2020-01-02T03:04:05Z, "+runtime.Version()+")", build.String())
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/influxdata/wirey/backend"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "print the interfaces with peers in the backend and their number of peers",
+	Run: func(cmd *cobra.Command, args []string) {
+		// only the backend is read, like an observer does
+		viper.Set("observer", true)
+		c, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		b, err := c.NewBackend(backend.Version)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ctx := context.Background()
+		names, err := backend.ListInterfaces(ctx, b)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, name := range names {
+			peers, err := b.GetPeers(ctx, name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("%s\t%d\n", name, len(peers))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+}
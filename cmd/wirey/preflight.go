@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/influxdata/wirey/backend"
+	"github.com/spf13/cobra"
+)
+
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "check that this host can run wirey with the given flags, without joining the backend nor writing the key files",
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		b, err := c.NewBackend(backend.Version)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		setWgBinary()
+		report := backend.PreflightFromConfig(b, c)
+		fmt.Println(report.String())
+		if !report.Passed() {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(preflightCmd)
+}
@@ -2,95 +2,238 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"reflect"
 	"strings"
-	"time"
+	"syscall"
 
 	"github.com/influxdata/wirey/backend"
+	"github.com/influxdata/wirey/pkg/wireguard"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-var Version string
-
 var rootCmd = &cobra.Command{
 	Use:   "wirey",
 	Short: "manage local wireguard interfaces in a distributed system",
 	Run: func(cmd *cobra.Command, args []string) {
-		b, err := backendFactory()
+		c, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		b, err := c.NewBackend(backend.Version)
+		if err != nil {
+			log.Fatal(err)
+		}
 
+		ensureKeyDir(c)
+		setWgBinary()
+
+		i, err := backend.NewInterfaceFromConfig(b, c)
 		if err != nil {
 			log.Fatal(err)
 		}
+		i.DryRun = viper.GetBool("dryrun")
+		if c.LogFormat == backend.LogJSON {
+			// the backends log with the standard logger
+			log.SetFlags(0)
+			log.SetOutput(backend.JSONLogger{Debug: c.Debug, Interface: i.Name})
+		}
 
-		privateKeyPath := viper.GetString("privatekeypath")
-		privKeyBaseDir := filepath.Dir(privateKeyPath)
-		if _, err := os.Stat(privKeyBaseDir); os.IsNotExist(err) {
-			if err := os.Mkdir(privKeyBaseDir, 0600); err != nil {
-				log.Fatalf("Unable to create the base directory for the wirey private key: %s - %s", privKeyBaseDir, err.Error())
+		metricsAddr := viper.GetString("metricsaddr")
+		if len(metricsAddr) > 0 {
+			if err := backend.RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+				log.Fatal(err)
 			}
+			if err := prometheus.Register(i.StatsCollector()); err != nil {
+				log.Fatal(err)
+			}
+			go func() {
+				mux := http.NewServeMux()
+				mux.Handle("/metrics", promhttp.Handler())
+				log.Fatal(http.ListenAndServe(metricsAddr, mux))
+			}()
 		}
 
-		ifname := viper.GetString("ifname")
-		endpoint := viper.GetString("endpoint")
-		endpointPort := viper.GetString("endpoint-port")
-		ipAddr := viper.GetString("ipaddr")
-		peerDiscoveryTTL, err := time.ParseDuration(viper.GetString("peerdiscoveryttl"))
-		if err != nil {
-			log.Fatalf("The passed duration cannot be parsed: %s", err.Error())
+		healthAddr := viper.GetString("healthaddr")
+		if len(healthAddr) > 0 {
+			go func() {
+				log.Fatal(http.ListenAndServe(healthAddr, backend.HealthHandler(i)))
+			}()
 		}
-		i, err := backend.NewInterface(
-			b,
-			ifname,
-			fmt.Sprintf("%s:%s", endpoint, endpointPort),
-			ipAddr,
-			privateKeyPath,
-			peerDiscoveryTTL,
-		)
 
-		if err != nil {
-			log.Fatal(err)
+		if viper.GetBool("once") {
+			if err := i.Reconcile(); err != nil {
+				log.Fatal(err)
+			}
+			return
 		}
 
-		log.Fatal(i.Connect())
+		if len(viper.GetString("config")) > 0 {
+			go reloadOnSighup(i, c)
+		}
+		if err := backend.RunWithSignals(i); err != nil {
+			log.Fatal(err)
+		}
 	},
 }
 
-func backendFactory() (backend.Backend, error) {
-	// etcd backend
-	etcdBackend := viper.GetStringSlice("etcd")
-	if len(etcdBackend) > 0 {
-		b, err := backend.NewEtcdBackend(etcdBackend)
+// reloadOnSighup applies the config file again on every SIGHUP, the
+// backend is created again only when its parameters changed.
+func reloadOnSighup(i *backend.Interface, current *backend.Config) {
+	hupc := make(chan os.Signal, 1)
+	signal.Notify(hupc, syscall.SIGHUP)
+	for range hupc {
+		c, err := loadConfig()
 		if err != nil {
-			return nil, err
+			log.Printf("Error reloading the configuration: %s", err.Error())
+			continue
+		}
+		var b backend.Backend
+		if !reflect.DeepEqual(c.Backend, current.Backend) {
+			b, err = c.NewBackend(backend.Version)
+			if err != nil {
+				log.Printf("Error reloading the configuration: %s", err.Error())
+				continue
+			}
 		}
-		return b, nil
+		if err := i.UpdateConfig(b, c); err != nil {
+			log.Printf("Error reloading the configuration: %s", err.Error())
+			continue
+		}
+		current = c
 	}
+}
 
-	httpBackend := viper.GetString("http")
-	if len(httpBackend) != 0 {
-		b, err := backend.NewHTTPBackend(httpBackend, Version)
-		if err != nil {
-			return nil, err
+// ensureKeyDir creates the directory of the private key when missing.
+func ensureKeyDir(c *backend.Config) {
+	privKeyBaseDir := filepath.Dir(c.PrivateKeyPath)
+	if _, err := os.Stat(privKeyBaseDir); len(c.PrivateKey) == 0 && !c.Observer && os.IsNotExist(err) {
+		if err := os.Mkdir(privKeyBaseDir, 0600); err != nil {
+			log.Fatalf("Unable to create the base directory for the wirey private key: %s - %s", privKeyBaseDir, err.Error())
 		}
-		httpBackendBasicAuth := viper.GetString("httpbasicauth")
-		if len(httpBackendBasicAuth) > 0 {
-			splitted := strings.Split(httpBackendBasicAuth, ":")
-			if len(splitted) != 2 {
-				return nil, fmt.Errorf("the provided basic auth credentials are not in format username:password")
-			}
-			username, password := splitted[0], splitted[1]
-			b.BasicAuth = &backend.BasicAuth{
-				Username: username,
-				Password: password,
-			}
+	}
+}
+
+// setWgBinary uses the wg binary of the wgbinary flag, failing right away
+// when it cannot be found rather than on the first key generation.
+func setWgBinary() {
+	name := viper.GetString("wgbinary")
+	if len(name) == 0 {
+		return
+	}
+	if err := wireguard.SetBinary(name); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadConfig reads the config file when passed, otherwise the config is built from the flags.
+func loadConfig() (*backend.Config, error) {
+	if path := viper.GetString("config"); len(path) != 0 {
+		return backend.LoadConfig(path)
+	}
+
+	observer := viper.GetBool("observer")
+	if !observer && (len(viper.GetString("endpoint")) == 0 || len(viper.GetString("ipaddr")) == 0) {
+		return nil, fmt.Errorf("the endpoint and ipaddr flags are required without a config file")
+	}
+
+	// the key is read from WIREY_PRIVATEKEY or from the stdin, never from a flag
+	// that would show it in the process list
+	if viper.GetString("privatekeypath") == "-" {
+		key, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the private key from the stdin: %s", err.Error())
 		}
-		return b, nil
+		viper.Set("privatekey", string(key))
 	}
 
-	return nil, fmt.Errorf("No storage backend selected, available backends: [etcd, http]")
+	c := &backend.Config{
+		Ifname:              viper.GetString("ifname"),
+		Endpoint:            net.JoinHostPort(viper.GetString("endpoint"), viper.GetString("endpoint-port")),
+		Endpoints:           viper.GetStringSlice("endpoints"),
+		IPAddr:              viper.GetString("ipaddr"),
+		Allocation:          viper.GetString("allocation"),
+		ManagementAddr:      viper.GetString("managementaddr"),
+		Hostname:            viper.GetString("hostname"),
+		PrivateKeyPath:      viper.GetString("privatekeypath"),
+		PrivateKey:          viper.GetString("privatekey"),
+		PresharedKeyPath:    viper.GetString("presharedkeypath"),
+		SigningKeyPath:      viper.GetString("signingkeypath"),
+		StrictKeys:          viper.GetBool("strictkeys"),
+		TrustedSigners:      viper.GetStringSlice("trustedsigners"),
+		AuthorizedKeys:      viper.GetString("authorizedkeys"),
+		PeerDiscoveryTTL:    viper.GetString("peerdiscoveryttl"),
+		PeerDiscoveryMaxTTL: viper.GetString("peerdiscoverymaxttl"),
+		PeerStaleness:       viper.GetString("peerstaleness"),
+		PeerDebounce:        viper.GetString("peerdebounce"),
+		ConvergenceCycles:   viper.GetInt("convergencecycles"),
+		PrefixLen:           viper.GetInt("prefixlen"),
+		PeerPrefixLen:       viper.GetInt("peerprefixlen"),
+		MTU:                 viper.GetInt("mtu"),
+		ListenPort:          viper.GetInt("listenport"),
+		FwMark:              viper.GetInt("fwmark"),
+		RouteTable:          viper.GetInt("routetable"),
+		LoadModule:          viper.GetBool("loadmodule"),
+		TunnelDNS:           viper.GetStringSlice("tunneldns"),
+		Netns:               viper.GetString("netns"),
+		PersistentKeepalive: viper.GetInt("persistentkeepalive"),
+		ProbeEndpoints:      viper.GetBool("probeendpoints"),
+		AllowedIPs:          viper.GetStringSlice("allowedips"),
+		AllowedIPsPolicy:    viper.GetString("allowedipspolicy"),
+		ExcludedIPs:         viper.GetStringSlice("excludedips"),
+		Gateway:             viper.GetBool("gateway"),
+		Labels:              viper.GetStringSlice("labels"),
+		PeerSelector:        viper.GetString("peerselector"),
+		MaxRetries:          viper.GetInt("maxretries"),
+		RetryBackoff:        viper.GetString("retrybackoff"),
+		Debug:               viper.GetBool("debug"),
+		LogFormat:           viper.GetString("logformat"),
+		Observer:            observer,
+		Backend: backend.BackendConfig{
+			Etcd:             viper.GetStringSlice("etcd"),
+			EtcdLeaseTTL:     viper.GetString("etcdleasettl"),
+			EtcdUsername:     viper.GetString("etcdusername"),
+			EtcdPassword:     viper.GetString("etcdpassword"),
+			EtcdCA:           viper.GetString("etcdca"),
+			EtcdCert:         viper.GetString("etcdcert"),
+			EtcdKey:          viper.GetString("etcdkey"),
+			HTTP:             viper.GetString("http"),
+			HTTPBasicAuth:    viper.GetString("httpbasicauth"),
+			HTTPBearerToken:  viper.GetString("httpbearertoken"),
+			Redis:            viper.GetString("redis"),
+			RedisPassword:    viper.GetString("redispassword"),
+			RedisTTL:         viper.GetString("redisttl"),
+			Kubernetes:       viper.GetString("kubernetes"),
+			Kubeconfig:       viper.GetString("kubeconfig"),
+			DNS:              viper.GetString("dns"),
+			DNSServer:        viper.GetString("dnsserver"),
+			DNSTSIGName:      viper.GetString("dnstsigname"),
+			DNSTSIGSecret:    viper.GetString("dnstsigsecret"),
+			Consul:           viper.GetString("consul"),
+			ConsulDatacenter: viper.GetString("consuldatacenter"),
+			ConsulToken:      viper.GetString("consultoken"),
+			ConsulTTL:        viper.GetString("consulttl"),
+			ConsulCA:         viper.GetString("consulca"),
+			ConsulCert:       viper.GetString("consulcert"),
+			ConsulKey:        viper.GetString("consulkey"),
+			File:             viper.GetString("file"),
+			WritePolicy:      viper.GetString("writepolicy"),
+		},
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -105,28 +248,157 @@ func Execute() {
 func init() {
 
 	pflags := rootCmd.PersistentFlags()
-	pflags.String("endpoint", "", "endpoint for this machine, e.g: 192.168.1.3")
+	pflags.String("allocation", "lowest", "the address claimed when the ipaddr is a pool: lowest for the lowest free one, hashed for the one the public key hashes to, or the next free, to tend to keep the address across restarts")
+	pflags.StringSlice("allowedips", nil, "comma separated subnets reachable through this node in addition to its ipaddr, e.g: 192.168.10.0/24")
+	pflags.String("allowedipspolicy", "mesh", "the traffic sent to the other peers: mesh for their addresses and subnets, gateway to also send all the traffic to the peers started with --gateway, e.g: for hub and spoke")
+	pflags.String("authorizedkeys", "", "the file of the public keys of the peers allowed on the link, one per line, the others are ignored. Leave empty to allow all the peers")
+	pflags.String("config", "", "the yaml or toml file to load the configuration from, e.g: /etc/wirey/config.yaml. When set the interface and backend flags are ignored")
+	pflags.String("consul", "", "the consul agent to use as backend, e.g: 127.0.0.1:8500")
+	pflags.String("consulca", "", "the pem file of the ca verifying the consul agent, setting any of the tls files makes consul be reached over https")
+	pflags.String("consulcert", "", "the pem file of the client certificate for consul, set with --consulkey")
+	pflags.String("consuldatacenter", "", "the consul datacenter, if empty the datacenter of the agent is used")
+	pflags.String("consulkey", "", "the pem file of the key of the client certificate for consul")
+	pflags.String("consultoken", "", "the consul acl token")
+	pflags.String("consulttl", "30s", "the ttl of the consul session holding the peer, renewed while wirey is running")
+	pflags.Int("convergencecycles", 3, "the reads of the peers in a row finding the same peers after which the interface is converged, see /convergedz")
+	pflags.Bool("debug", false, "log debug messages too")
+	pflags.String("dns", "", "the domain where to publish the peers as TXT records, see also dnsserver")
+	pflags.String("dnsserver", "", "the nameserver to query and update for the dns backend, e.g: 192.168.1.1:53")
+	pflags.String("dnstsigname", "", "the name of the TSIG key used to sign the dns updates, if empty the dns backend is read only")
+	pflags.String("dnstsigsecret", "", "the base64 hmac-sha256 secret of the TSIG key")
+	pflags.Bool("dryrun", false, "print the wireguard configuration and the link changes for the current peers, without applying them or joining the backend")
+	pflags.String("endpoint", "", "endpoint for this machine, e.g: 192.168.1.3, 2001:db8::1 or node1.example.com")
 	pflags.String("endpoint-port", "2345", "endpoint port for this machine")
+	pflags.StringSlice("endpoints", nil, "comma separated other endpoints of this machine in order of priority, the peers fail over to them when the endpoint stops answering, e.g: 10.1.0.3:2345. Needs persistentkeepalive on the peers")
 	pflags.StringSlice("etcd", nil, "array of etcd servers to connect to")
+	pflags.String("etcdca", "", "the pem file of the ca verifying the etcd servers")
+	pflags.String("etcdcert", "", "the pem file of the client certificate for etcd, set with --etcdkey")
+	pflags.String("etcdkey", "", "the pem file of the key of the client certificate for etcd")
+	pflags.String("etcdleasettl", "30s", "the ttl of the lease attached to the peer in etcd, kept alive while wirey is running")
+	pflags.String("etcdpassword", "", "the password of the etcd user")
+	pflags.String("etcdusername", "", "the etcd user, when the etcd authentication is enabled")
+	pflags.StringSlice("excludedips", nil, "comma separated subnets cut out of the ones advertised by the peers, the default route of the gateways included, e.g: 192.168.0.0/16 to keep the local network out of a full tunnel")
+	pflags.String("file", "", "the directory where to store the peers in json files, e.g: on a shared NFS mount")
+	pflags.Int("fwmark", 0, "the firewall mark of the packets sent by wireguard, for policy routing. 0 means unset")
+	pflags.Bool("gateway", false, "make the peers with the gateway allowedipspolicy send all their traffic through this node, see also fwmark")
+	pflags.String("healthaddr", "", "the address where to serve the /healthz and /readyz probes, e.g: 127.0.0.1:9110, disabled if empty")
+	pflags.String("hostname", "", "the name of this node shown to the other peers, defaults to the hostname of the machine")
 	pflags.String("http", "", "the http backend endpoint to use as backend, see also httpbasicauth if you need basic authentication")
 	pflags.String("httpbasicauth", "", "basic auth for the http backend, in form username:password")
-	pflags.String("ifname", "wg0", "the name to use for the interface (must be the same in all the peers)")
-	pflags.String("ipaddr", "", "the ip for this node inside the tunnel, e.g: 10.0.0.3")
-	pflags.String("peerdiscoveryttl", "30s", "the time to wait to discover new peers using the configured backend")
-	pflags.String("privatekeypath", "/etc/wirey/privkey", "the local path where to load the private key from, if empty, a private key will be generated.")
-
-	rootCmd.MarkFlagRequired("endpoint")
-	rootCmd.MarkFlagRequired("ipaddr")
+	pflags.String("redis", "", "the redis server to use as backend, e.g: 127.0.0.1:6379")
+	pflags.String("redispassword", "", "the password of the redis server")
+	pflags.String("redisttl", "30s", "the ttl of the peers in redis, refreshed while wirey is running")
+	pflags.String("kubernetes", "", "the kubernetes namespace where to store the peers, see also kubeconfig")
+	pflags.String("kubeconfig", "", "the kubeconfig to use for the kubernetes backend, if empty the in cluster service account is used")
+	pflags.String("httpbearertoken", "", "bearer token for the http backend, sent in the Authorization header")
+	pflags.String("ifname", "wg0", "the name to use for the interface (must be the same in all the peers), auto picks the first free wgN")
+	pflags.String("ipaddr", "", "the ip for this node inside the tunnel, e.g: 10.0.0.3, or a pool to allocate it from, e.g: 10.0.0.0/24")
+	pflags.Int("persistentkeepalive", 0, "interval in seconds of the keepalive packets sent to the peers, 0 disables it, 25 is a good value for nodes behind NAT")
+	pflags.Bool("probeendpoints", false, "send a datagram to the endpoint of every peer in the background after each read of the peers, to report the ones refusing it, e.g: unreachable from this node. Cannot detect the endpoints dropping the packets")
+	pflags.String("presharedkeypath", "", "the local path where to load the preshared key shared by every peer of the mesh from, if the file does not exist a preshared key will be generated: copy it to the other nodes. It is never sent to the backend. Leave empty to disable preshared keys")
+	pflags.Int("prefixlen", 0, "the prefix length of the tunnel network the ipaddr belongs to, e.g: 16 for a 10.0.0.0/16 mesh. Defaults to 24 for IPv4 and 64 for IPv6 addresses")
+	pflags.StringSlice("labels", nil, "comma separated key=value labels of this node, e.g: region=eu, matched by the peerselector of the other peers")
+	pflags.Int("listenport", 0, "the local port wireguard listens on, defaults to the endpoint port. Set it when the endpoint port is forwarded to a different one")
+	pflags.Bool("loadmodule", false, "load the wireguard kernel module with modprobe when the kernel cannot create the link")
+	pflags.String("logformat", "text", "the format of the logs, text or json for a line of json per message, e.g: for a log aggregator")
+	pflags.Int("maxretries", 5, "the number of consecutive failures, e.g: the backend being unreachable, after which wirey gives up")
+	pflags.String("managementaddr", "", "a second address of this machine in CIDR notation, assigned to the link and routed by the other peers, e.g: 10.99.0.5/24 for a control plane")
+	pflags.String("metricsaddr", "", "the address where to expose the prometheus metrics, e.g: 127.0.0.1:9109, disabled if empty")
+	pflags.Int("mtu", 1420, "the mtu of the wireguard link, cannot be less than 1280")
+	pflags.String("netns", "", "the network namespace the link is moved into, a name from ip netns or a path. The encrypted packets still go through the current one")
+	pflags.Bool("observer", false, "only watch the peers in the backend, without joining it or creating the link, e.g: for monitoring. The endpoint and ipaddr are not needed")
+	pflags.Bool("once", false, "join the backend and configure the link with the current peers once, then exit leaving both in place, e.g: to run wirey from cron. Refused with the etcd, consul and redis backends, their entries expire once wirey exits")
+	pflags.String("peerdebounce", "0s", "coalesce the changes of the peers within this window into one configuration of the link, e.g: 2s for meshes with churn. Delays the changes by up to it, 0 disables it")
+	pflags.String("peerdiscoveryttl", "30s", "the interval between the reads of the peers in the backend, the backends able to watch also read them at this interval in case a change is missed")
+	pflags.String("peerdiscoverymaxttl", "0s", "while the peers do not change, the interval between the reads of the peers grows by half on every read up to this, e.g: 5m for large meshes. Disabled when not above peerdiscoveryttl")
+	pflags.String("peerselector", "", "comma separated key=value labels, only the peers with all of them and the gateways are configured on the link, e.g: region=eu for a partial mesh. Empty keeps all the peers")
+	pflags.Int("peerprefixlen", 0, "the prefix length of the subnet of the ipaddr the other peers route to this one, e.g: 28 for a node on a bigger subnet than the others. Defaults to the single address")
+	pflags.String("peerstaleness", "0s", "leave out the peers not seen for longer, e.g: 5m for nodes that died without leaving. Must be the same on all the peers, 0 disables it")
+	pflags.String("privatekeypath", "/etc/wirey/privkey", "the local path where to load the private key from, if empty, a private key will be generated. With - the key is read from the stdin, the WIREY_PRIVATEKEY env variable can also hold the key")
+	pflags.Int("routetable", 0, "the id of the routing table of the routes through the link, 0 is the main table. Use it with ip rules for split tunnels, see also fwmark")
+	pflags.String("retrybackoff", "5s", "the wait before the first retry after a failure, doubled on every consecutive failure up to 2m")
+	pflags.String("signingkeypath", "", "the local path of the ed25519 key signing the peer in the backend, generated if the file does not exist. Leave empty to not sign")
+	pflags.Bool("strictkeys", false, "refuse the key files readable by the group or the others, like ssh does, by default they are only logged")
+	pflags.StringSlice("trustedsigners", nil, "comma separated base64 ed25519 public keys, the peers not signed by any of them are ignored. Leave empty to trust all the peers")
+	pflags.StringSlice("tunneldns", nil, "comma separated dns servers the system uses while the link is up, e.g: for a full tunnel, set with resolvectl or resolvconf and reverted on exit")
+	pflags.String("wgbinary", "", "the path of the wg binary, used to generate the keys and when the kernel cannot be configured through netlink, defaults to the WIREY_WG env variable or to wg in the PATH")
+	pflags.String("writepolicy", "all", "with more than one backend, all to fail the joins unless every backend stores the peer, any to fail them only when none does")
 
+	viper.BindPFlag("allocation", pflags.Lookup("allocation"))
+	viper.BindPFlag("allowedips", pflags.Lookup("allowedips"))
+	viper.BindPFlag("allowedipspolicy", pflags.Lookup("allowedipspolicy"))
+	viper.BindPFlag("authorizedkeys", pflags.Lookup("authorizedkeys"))
+	viper.BindPFlag("config", pflags.Lookup("config"))
+	viper.BindPFlag("consul", pflags.Lookup("consul"))
+	viper.BindPFlag("consulca", pflags.Lookup("consulca"))
+	viper.BindPFlag("consulcert", pflags.Lookup("consulcert"))
+	viper.BindPFlag("consuldatacenter", pflags.Lookup("consuldatacenter"))
+	viper.BindPFlag("consulkey", pflags.Lookup("consulkey"))
+	viper.BindPFlag("consultoken", pflags.Lookup("consultoken"))
+	viper.BindPFlag("consulttl", pflags.Lookup("consulttl"))
+	viper.BindPFlag("convergencecycles", pflags.Lookup("convergencecycles"))
+	viper.BindPFlag("debug", pflags.Lookup("debug"))
+	viper.BindPFlag("dns", pflags.Lookup("dns"))
+	viper.BindPFlag("dnsserver", pflags.Lookup("dnsserver"))
+	viper.BindPFlag("dnstsigname", pflags.Lookup("dnstsigname"))
+	viper.BindPFlag("dnstsigsecret", pflags.Lookup("dnstsigsecret"))
+	viper.BindPFlag("dryrun", pflags.Lookup("dryrun"))
 	viper.BindPFlag("endpoint", pflags.Lookup("endpoint"))
 	viper.BindPFlag("endpoint-port", pflags.Lookup("endpoint-port"))
+	viper.BindPFlag("endpoints", pflags.Lookup("endpoints"))
 	viper.BindPFlag("etcd", pflags.Lookup("etcd"))
+	viper.BindPFlag("etcdca", pflags.Lookup("etcdca"))
+	viper.BindPFlag("etcdcert", pflags.Lookup("etcdcert"))
+	viper.BindPFlag("etcdkey", pflags.Lookup("etcdkey"))
+	viper.BindPFlag("etcdleasettl", pflags.Lookup("etcdleasettl"))
+	viper.BindPFlag("etcdpassword", pflags.Lookup("etcdpassword"))
+	viper.BindPFlag("etcdusername", pflags.Lookup("etcdusername"))
+	viper.BindPFlag("excludedips", pflags.Lookup("excludedips"))
+	viper.BindPFlag("file", pflags.Lookup("file"))
+	viper.BindPFlag("fwmark", pflags.Lookup("fwmark"))
+	viper.BindPFlag("gateway", pflags.Lookup("gateway"))
+	viper.BindPFlag("healthaddr", pflags.Lookup("healthaddr"))
+	viper.BindPFlag("hostname", pflags.Lookup("hostname"))
 	viper.BindPFlag("http", pflags.Lookup("http"))
 	viper.BindPFlag("httpbasicauth", pflags.Lookup("httpbasicauth"))
+	viper.BindPFlag("redis", pflags.Lookup("redis"))
+	viper.BindPFlag("redispassword", pflags.Lookup("redispassword"))
+	viper.BindPFlag("redisttl", pflags.Lookup("redisttl"))
+	viper.BindPFlag("kubernetes", pflags.Lookup("kubernetes"))
+	viper.BindPFlag("kubeconfig", pflags.Lookup("kubeconfig"))
+	viper.BindPFlag("httpbearertoken", pflags.Lookup("httpbearertoken"))
 	viper.BindPFlag("ifname", pflags.Lookup("ifname"))
 	viper.BindPFlag("ipaddr", pflags.Lookup("ipaddr"))
+	viper.BindPFlag("persistentkeepalive", pflags.Lookup("persistentkeepalive"))
+	viper.BindPFlag("probeendpoints", pflags.Lookup("probeendpoints"))
+	viper.BindPFlag("presharedkeypath", pflags.Lookup("presharedkeypath"))
+	viper.BindPFlag("prefixlen", pflags.Lookup("prefixlen"))
 	viper.BindPFlag("privatekeypath", pflags.Lookup("privatekeypath"))
+	viper.BindPFlag("labels", pflags.Lookup("labels"))
+	viper.BindPFlag("listenport", pflags.Lookup("listenport"))
+	viper.BindPFlag("loadmodule", pflags.Lookup("loadmodule"))
+	viper.BindPFlag("logformat", pflags.Lookup("logformat"))
+	viper.BindPFlag("maxretries", pflags.Lookup("maxretries"))
+	viper.BindPFlag("managementaddr", pflags.Lookup("managementaddr"))
+	viper.BindPFlag("metricsaddr", pflags.Lookup("metricsaddr"))
+	viper.BindPFlag("mtu", pflags.Lookup("mtu"))
+	viper.BindPFlag("netns", pflags.Lookup("netns"))
+	viper.BindPFlag("observer", pflags.Lookup("observer"))
+	viper.BindPFlag("once", pflags.Lookup("once"))
+	viper.BindPFlag("peerdebounce", pflags.Lookup("peerdebounce"))
 	viper.BindPFlag("peerdiscoveryttl", pflags.Lookup("peerdiscoveryttl"))
+	viper.BindPFlag("peerdiscoverymaxttl", pflags.Lookup("peerdiscoverymaxttl"))
+	viper.BindPFlag("peerselector", pflags.Lookup("peerselector"))
+	viper.BindPFlag("peerprefixlen", pflags.Lookup("peerprefixlen"))
+	viper.BindPFlag("peerstaleness", pflags.Lookup("peerstaleness"))
+	viper.BindPFlag("routetable", pflags.Lookup("routetable"))
+	viper.BindPFlag("retrybackoff", pflags.Lookup("retrybackoff"))
+	viper.BindPFlag("signingkeypath", pflags.Lookup("signingkeypath"))
+	viper.BindPFlag("strictkeys", pflags.Lookup("strictkeys"))
+	viper.BindPFlag("trustedsigners", pflags.Lookup("trustedsigners"))
+	viper.BindPFlag("tunneldns", pflags.Lookup("tunneldns"))
+	viper.BindPFlag("wgbinary", pflags.Lookup("wgbinary"))
+	viper.BindPFlag("writepolicy", pflags.Lookup("writepolicy"))
 
 	viper.SetEnvPrefix("wirey")
 	viper.AutomaticEnv()
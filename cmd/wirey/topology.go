@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/influxdata/wirey/backend"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var topologyCmd = &cobra.Command{
+	Use:   "topology",
+	Short: "print the mesh of the peers in the backend as json or as a graphviz dot graph",
+	Run: func(cmd *cobra.Command, args []string) {
+		// only the backend is read, like an observer does
+		viper.Set("observer", true)
+		c, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		b, err := c.NewBackend(backend.Version)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		peers, err := b.GetPeers(context.Background(), c.Ifname)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		format, _ := cmd.Flags().GetString("format")
+		if err := backend.WriteTopology(os.Stdout, peers, format); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	topologyCmd.Flags().String("format", backend.TopologyJSON, "the output format, json or dot, e.g: wirey topology --format dot | dot -Tsvg > mesh.svg")
+	rootCmd.AddCommand(topologyCmd)
+}
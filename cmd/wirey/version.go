@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 
+	"github.com/influxdata/wirey/backend"
 	"github.com/spf13/cobra"
 )
 
@@ -10,7 +11,7 @@ var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "print the current wirey version",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Printf("%s\n", Version)
+		fmt.Printf("%s\n", backend.GetBuildInfo())
 	},
 }
 
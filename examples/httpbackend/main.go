@@ -13,9 +13,10 @@ import (
 )
 
 type Peer struct {
-	PublicKey []byte
-	Endpoint  string
-	IP        *net.IP
+	PublicKey  []byte
+	Endpoint   string
+	IP         *net.IP
+	AllowedIPs []string `json:",omitempty"`
 }
 
 type Store struct {
@@ -29,6 +30,12 @@ func (s *Store) write(key string, val Peer) {
 	s.mutex.Unlock()
 }
 
+func (s *Store) delete(key string) {
+	s.mutex.Lock()
+	delete(s.store, key)
+	s.mutex.Unlock()
+}
+
 func (s *Store) read() map[string]Peer {
 	s.mutex.RLock()
 	res := s.store
@@ -53,6 +60,14 @@ func joinHandler(s *Store) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
+func leaveHandler(s *Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sha := mux.Vars(r)["publickeysha"]
+		s.delete(sha)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 func getPeersHandler(s *Store) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -108,6 +123,14 @@ func main() {
 			password,
 		),
 	).Methods("POST")
+	r.HandleFunc(
+		"/{ifname}/{publickeysha}",
+		basicAuthMiddleware(
+			leaveHandler(store),
+			username,
+			password,
+		),
+	).Methods("DELETE")
 	r.HandleFunc("/{ifname}",
 		basicAuthMiddleware(
 			getPeersHandler(store),
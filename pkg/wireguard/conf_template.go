@@ -3,10 +3,25 @@ package wireguard
 const confTemplate = `[Interface]
 ListenPort = {{ .Interface.ListenPort  }}
 PrivateKey = {{ .Interface.PrivateKey }}
-{{ range .Peers }}
+{{ if .Interface.FwMark }}FwMark = {{ .Interface.FwMark }}
+{{ end }}` + peersTemplate
+
+// wgQuickTemplate adds the fields only known to wg-quick, that wg setconf refuses
+const wgQuickTemplate = `[Interface]
+{{ if .Interface.Address }}Address = {{ .Interface.Address }}
+{{ end }}ListenPort = {{ .Interface.ListenPort  }}
+PrivateKey = {{ .Interface.PrivateKey }}
+{{ if .Interface.MTU }}MTU = {{ .Interface.MTU }}
+{{ end }}{{ if .Interface.DNS }}DNS = {{ .Interface.DNS }}
+{{ end }}{{ if .Interface.FwMark }}FwMark = {{ .Interface.FwMark }}
+{{ end }}` + peersTemplate
+
+const peersTemplate = `{{ range .Peers }}
 
 [Peer]
 PublicKey = {{ .PublicKey }}
 AllowedIPs = {{ .AllowedIPs }}
 Endpoint = {{ .Endpoint }}
-{{ end }}`
+{{ if .PresharedKey }}PresharedKey = {{ .PresharedKey }}
+{{ end }}{{ if .PersistentKeepalive }}PersistentKeepalive = {{ .PersistentKeepalive }}
+{{ end }}{{ end }}`
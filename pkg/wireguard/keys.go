@@ -0,0 +1,29 @@
+package wireguard
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Pubkey returns the base64 public key of the base64 private key,
+// without needing the wg binary.
+func Pubkey(privateKey string) (string, error) {
+	key, err := wgtypes.ParseKey(strings.TrimSpace(privateKey))
+	if err != nil {
+		return "", fmt.Errorf("error parsing the private key: %w", err)
+	}
+	return key.PublicKey().String(), nil
+}
+
+// PubkeyFromFile returns the base64 public key of the private key stored
+// at path, in the same format written by wg genkey.
+func PubkeyFromFile(path string) (string, error) {
+	privateKey, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading the private key: %w", err)
+	}
+	return Pubkey(string(privateKey))
+}
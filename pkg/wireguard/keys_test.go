@@ -0,0 +1,35 @@
+package wireguard
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPubkey(t *testing.T) {
+	pub, err := Pubkey("iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "JKO10PBx5U8+MgjhRj8bggmsk2qT/Zt0A1u4Oqnihw8=", pub)
+
+	_, err = Pubkey("not a key")
+	assert.Error(t, err)
+}
+
+func TestPubkeyFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wireguard")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "privkey")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=\n"), 0600))
+
+	pub, err := PubkeyFromFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "JKO10PBx5U8+MgjhRj8bggmsk2qT/Zt0A1u4Oqnihw8=", pub)
+
+	_, err = PubkeyFromFile(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,196 @@
+package wireguard
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const (
+	errParseLine       = "line %d of the wireguard configuration: %s"
+	errUnknownSection  = "unknown section %s"
+	errUnknownKey      = "unknown key %s in the %s section"
+	errKeyValueFormat  = "expected a key = value pair, got: %q"
+	errOutsideSection  = "key %s outside of any section"
+	errIntValue        = "the value of %s is not a valid number: %q"
+	errMultipleIfaces  = "more than one Interface section"
+	errPeerNoPublicKey = "peer without a PublicKey ending at line %d"
+)
+
+// wgQuickKeys are the keys of the Interface section only wg-quick knows,
+// the Configuration has no place for them.
+var wgQuickKeys = map[string]bool{
+	"table":      true,
+	"preup":      true,
+	"postup":     true,
+	"predown":    true,
+	"postdown":   true,
+	"saveconfig": true,
+}
+
+// ParseConfig reads a wg-quick or wg setconf configuration, the keys are
+// case insensitive as in wg, comments start with # and the AllowedIPs
+// and Address repeated on several lines are joined. The wg-quick keys
+// with no place in the Configuration, e.g: PostUp or Table, are logged
+// and ignored.
+func ParseConfig(r io.Reader) (*Configuration, error) {
+	conf := &Configuration{Peers: []Peer{}}
+	var section string
+	var peer *Peer
+	seenInterface := false
+
+	endPeer := func(line int) error {
+		if peer == nil {
+			return nil
+		}
+		if len(peer.PublicKey) == 0 {
+			return fmt.Errorf(errPeerNoPublicKey, line)
+		}
+		conf.Peers = append(conf.Peers, *peer)
+		peer = nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := scanner.Text()
+		if c := strings.Index(line, "#"); c >= 0 {
+			line = line[:c]
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if err := endPeer(n - 1); err != nil {
+				return nil, err
+			}
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			switch section {
+			case "interface":
+				if seenInterface {
+					return nil, fmt.Errorf(errParseLine, n, errMultipleIfaces)
+				}
+				seenInterface = true
+			case "peer":
+				peer = &Peer{}
+			default:
+				return nil, fmt.Errorf(errParseLine, n, fmt.Sprintf(errUnknownSection, line))
+			}
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf(errParseLine, n, fmt.Sprintf(errKeyValueFormat, line))
+		}
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		var err error
+		switch section {
+		case "interface":
+			if wgQuickKeys[strings.ToLower(key)] {
+				log.Printf("Ignoring the wg-quick key %s at line %d of the wireguard configuration", key, n)
+				continue
+			}
+			err = parseInterfaceKey(&conf.Interface, key, value)
+		case "peer":
+			err = parsePeerKey(peer, key, value)
+		default:
+			err = fmt.Errorf(errOutsideSection, key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf(errParseLine, n, err.Error())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := endPeer(n); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func parseInterfaceKey(i *Interface, key, value string) error {
+	var err error
+	switch strings.ToLower(key) {
+	case "address":
+		i.Address = appendList(i.Address, value)
+	case "listenport":
+		i.ListenPort, err = parseInt(key, value)
+	case "privatekey":
+		i.PrivateKey = value
+	case "mtu":
+		i.MTU, err = parseInt(key, value)
+	case "dns":
+		i.DNS = appendList(i.DNS, value)
+	case "fwmark":
+		// wg accepts off and hexadecimal marks
+		if strings.ToLower(value) == "off" {
+			i.FwMark = 0
+			return nil
+		}
+		var mark uint64
+		mark, err = strconv.ParseUint(value, 0, 32)
+		if err != nil {
+			return fmt.Errorf(errIntValue, key, value)
+		}
+		i.FwMark = int(mark)
+	default:
+		return fmt.Errorf(errUnknownKey, key, "Interface")
+	}
+	return err
+}
+
+func parsePeerKey(p *Peer, key, value string) error {
+	var err error
+	switch strings.ToLower(key) {
+	case "publickey":
+		p.PublicKey = value
+	case "presharedkey":
+		p.PresharedKey = value
+	case "allowedips":
+		p.AllowedIPs = appendList(p.AllowedIPs, value)
+	case "endpoint":
+		p.Endpoint = value
+	case "persistentkeepalive":
+		if strings.ToLower(value) == "off" {
+			p.PersistentKeepalive = 0
+			return nil
+		}
+		p.PersistentKeepalive, err = parseInt(key, value)
+	default:
+		return fmt.Errorf(errUnknownKey, key, "Peer")
+	}
+	return err
+}
+
+func parseInt(key, value string) (int, error) {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf(errIntValue, key, value)
+	}
+	return v, nil
+}
+
+// appendList joins the comma separated values without the spaces
+func appendList(list, value string) string {
+	items := []string{}
+	if len(list) > 0 {
+		items = append(items, list)
+	}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			items = append(items, v)
+		}
+	}
+	return strings.Join(items, ",")
+}
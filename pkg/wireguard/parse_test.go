@@ -0,0 +1,130 @@
+package wireguard
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfig(t *testing.T) {
+	input := `# hand written config
+[Interface]
+Address = 10.0.0.2/24
+ListenPort = 49082
+privatekey = iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=
+FwMark = 0xca6c
+
+[Peer]
+PublicKey = Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=
+AllowedIPs = 10.0.0.1/32, 192.168.10.0/24
+AllowedIPs = 192.168.20.0/24 # the office
+Endpoint = 172.31.23.163:50113
+PersistentKeepalive = 25
+
+  [Peer]
+  PublicKey = JKO10PBx5U8+MgjhRj8bggmsk2qT/Zt0A1u4Oqnihw8=
+  AllowedIPs = 10.0.0.3/32
+`
+	conf, err := ParseConfig(strings.NewReader(input))
+	assert.Nil(t, err)
+
+	assert.Equal(t, Interface{
+		Address:    "10.0.0.2/24",
+		ListenPort: 49082,
+		PrivateKey: "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=",
+		FwMark:     51820,
+	}, conf.Interface)
+	assert.Equal(t, []Peer{
+		{
+			PublicKey:           "Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=",
+			AllowedIPs:          "10.0.0.1/32,192.168.10.0/24,192.168.20.0/24",
+			Endpoint:            "172.31.23.163:50113",
+			PersistentKeepalive: 25,
+		},
+		{
+			PublicKey:  "JKO10PBx5U8+MgjhRj8bggmsk2qT/Zt0A1u4Oqnihw8=",
+			AllowedIPs: "10.0.0.3/32",
+		},
+	}, conf.Peers)
+}
+
+func TestParseConfigWgQuick(t *testing.T) {
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	input := `[Interface]
+Address = 10.200.100.8/24
+DNS = 10.200.100.1
+PrivateKey = oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=
+Table = off
+SaveConfig = true
+PreUp = echo starting
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+PreDown = echo stopping
+PostDown = iptables -D FORWARD -i %i -j ACCEPT
+
+[Peer]
+PublicKey = GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU=
+PresharedKey = /UwcSPg38hW/D9Y3tcS1FOV0K1wuURMbS0sesJEP5ak=
+AllowedIPs = 0.0.0.0/0
+Endpoint = demo.wireguard.com:51820
+`
+	conf, err := ParseConfig(strings.NewReader(input))
+	assert.Nil(t, err)
+	assert.Equal(t, Interface{
+		Address:    "10.200.100.8/24",
+		DNS:        "10.200.100.1",
+		PrivateKey: "oK56DE9Ue9zK76rAc8pBl6opph+1v36lm7cXXsQKrQM=",
+	}, conf.Interface)
+	assert.Len(t, conf.Peers, 1)
+	for _, key := range []string{"Table", "SaveConfig", "PreUp", "PostUp", "PreDown", "PostDown"} {
+		assert.Contains(t, logs.String(), "Ignoring the wg-quick key "+key)
+	}
+}
+
+func TestParseConfigRoundTrip(t *testing.T) {
+	conf := &Configuration{
+		Interface: Interface{
+			ListenPort: 49082,
+			PrivateKey: "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=",
+			Address:    "10.0.0.2/24",
+			MTU:        1420,
+			DNS:        "1.1.1.1,2606:4700:4700::1111",
+		},
+		Peers: []Peer{
+			{
+				PublicKey:    "Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=",
+				AllowedIPs:   "10.0.0.1/32",
+				Endpoint:     "172.31.23.163:50113",
+				PresharedKey: "FpCyhws9cxwWoV4xELtfJvjJN+zQVRPISllRWgeopVE=",
+			},
+		},
+	}
+	rendered, err := MarshalWgQuick(*conf)
+	assert.Nil(t, err)
+
+	parsed, err := ParseConfig(strings.NewReader(string(rendered)))
+	assert.Nil(t, err)
+	assert.Equal(t, conf, parsed)
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	cases := map[string]string{
+		"[Interface]\nTables = off\n":            "line 2 of the wireguard configuration: unknown key Tables in the Interface section",
+		"[Peer]\nPublicKey\n":                    `line 2 of the wireguard configuration: expected a key = value pair, got: "PublicKey"`,
+		"[Interface]\nListenPort = abc\n":        `line 2 of the wireguard configuration: the value of ListenPort is not a valid number: "abc"`,
+		"ListenPort = 1234\n":                    "line 1 of the wireguard configuration: key ListenPort outside of any section",
+		"[Wirey]\n":                              "line 1 of the wireguard configuration: unknown section [Wirey]",
+		"[Interface]\n[Interface]\n":             "line 2 of the wireguard configuration: more than one Interface section",
+		"[Peer]\nEndpoint = 1.2.3.4:5\n[Peer]\n": "peer without a PublicKey ending at line 2",
+	}
+	for input, expected := range cases {
+		_, err := ParseConfig(strings.NewReader(input))
+		assert.EqualError(t, err, expected, input)
+	}
+}
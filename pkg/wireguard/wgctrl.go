@@ -0,0 +1,170 @@
+package wireguard
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// configureDevice applies the configuration to the device directly through
+// the kernel netlink interface (or the userspace wireguard socket).
+// When the device is not available os.ErrNotExist is returned
+func configureDevice(ifname string, conf Configuration) error {
+	c, err := wgctrl.New()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	device, err := c.Device(ifname)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := deviceConfig(conf, *device)
+	if err != nil {
+		return err
+	}
+	return c.ConfigureDevice(ifname, *cfg)
+}
+
+// PeerStats is the state the kernel holds for a peer of a device.
+type PeerStats struct {
+	// PublicKey is the base64 public key of the peer
+	PublicKey string
+	// Endpoint is the address the peer was last seen at, empty when unknown
+	Endpoint string
+	// LastHandshake is the zero time when there was no handshake yet
+	LastHandshake time.Time
+	ReceiveBytes  int64
+	TransmitBytes int64
+}
+
+// Stats reads the state of every peer of the device.
+func Stats(ifname string) ([]PeerStats, error) {
+	c, err := wgctrl.New()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	device, err := c.Device(ifname)
+	if err != nil {
+		return nil, err
+	}
+	return peerStats(device.Peers), nil
+}
+
+// DevicePublicKey returns the base64 public key of the device.
+func DevicePublicKey(ifname string) (string, error) {
+	c, err := wgctrl.New()
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	device, err := c.Device(ifname)
+	if err != nil {
+		return "", err
+	}
+	return device.PublicKey.String(), nil
+}
+
+func peerStats(peers []wgtypes.Peer) []PeerStats {
+	stats := make([]PeerStats, 0, len(peers))
+	for _, p := range peers {
+		s := PeerStats{
+			PublicKey:     p.PublicKey.String(),
+			LastHandshake: p.LastHandshakeTime,
+			ReceiveBytes:  p.ReceiveBytes,
+			TransmitBytes: p.TransmitBytes,
+		}
+		if p.Endpoint != nil {
+			s.Endpoint = p.Endpoint.String()
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// deviceConfig returns the changes to apply to the current device, the
+// peers are updated in place and the ones not in the configuration are
+// removed, so that the tunnels of the unchanged peers are not reset.
+func deviceConfig(conf Configuration, device wgtypes.Device) (*wgtypes.Config, error) {
+	privateKey, err := wgtypes.ParseKey(strings.TrimSpace(conf.Interface.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the private key: %w", err)
+	}
+
+	peers := []wgtypes.PeerConfig{}
+	for _, p := range conf.Peers {
+		publicKey, err := wgtypes.ParseKey(strings.TrimSpace(p.PublicKey))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the public key of peer %s: %w", p.PublicKey, err)
+		}
+
+		allowedIPs := []net.IPNet{}
+		for _, a := range strings.Split(p.AllowedIPs, ",") {
+			_, ipnet, err := net.ParseCIDR(strings.TrimSpace(a))
+			if err != nil {
+				return nil, fmt.Errorf("error parsing allowed ips of peer %s: %w", p.PublicKey, err)
+			}
+			allowedIPs = append(allowedIPs, *ipnet)
+		}
+
+		endpoint, err := net.ResolveUDPAddr("udp", p.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving the endpoint of peer %s: %w", p.PublicKey, err)
+		}
+
+		// the zero key clears the preshared key the peer had
+		psk := &wgtypes.Key{}
+		if len(strings.TrimSpace(p.PresharedKey)) > 0 {
+			k, err := wgtypes.ParseKey(strings.TrimSpace(p.PresharedKey))
+			if err != nil {
+				return nil, fmt.Errorf("error parsing the preshared key of peer %s: %w", p.PublicKey, err)
+			}
+			psk = &k
+		}
+
+		keepalive := time.Duration(p.PersistentKeepalive) * time.Second
+
+		peers = append(peers, wgtypes.PeerConfig{
+			PublicKey:                   publicKey,
+			Endpoint:                    endpoint,
+			PresharedKey:                psk,
+			PersistentKeepaliveInterval: &keepalive,
+			ReplaceAllowedIPs:           true,
+			AllowedIPs:                  allowedIPs,
+		})
+	}
+
+	configured := map[wgtypes.Key]bool{}
+	for _, p := range peers {
+		configured[p.PublicKey] = true
+	}
+	for _, p := range device.Peers {
+		if !configured[p.PublicKey] {
+			peers = append(peers, wgtypes.PeerConfig{
+				PublicKey: p.PublicKey,
+				Remove:    true,
+			})
+		}
+	}
+
+	listenPort := conf.Interface.ListenPort
+	cfg := &wgtypes.Config{
+		PrivateKey: &privateKey,
+		ListenPort: &listenPort,
+		Peers:      peers,
+	}
+	// a mark of 0 clears the one of a reused device
+	if conf.Interface.FwMark != device.FirewallMark {
+		cfg.FirewallMark = &conf.Interface.FwMark
+	}
+	return cfg, nil
+}
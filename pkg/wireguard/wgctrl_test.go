@@ -0,0 +1,140 @@
+package wireguard
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestDeviceConfig(t *testing.T) {
+	conf := Configuration{
+		Interface: Interface{
+			ListenPort: 49082,
+			PrivateKey: "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=\n",
+		},
+		Peers: []Peer{
+			{
+				PublicKey:           "Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=\n",
+				AllowedIPs:          "10.0.0.1/32",
+				Endpoint:            "172.31.23.163:50113",
+				PresharedKey:        "FpCyhws9cxwWoV4xELtfJvjJN+zQVRPISllRWgeopVE=",
+				PersistentKeepalive: 25,
+			},
+		},
+	}
+	cfg, err := deviceConfig(conf, wgtypes.Device{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 49082, *cfg.ListenPort)
+	assert.Nil(t, cfg.FirewallMark)
+	assert.False(t, cfg.ReplacePeers)
+	assert.Len(t, cfg.Peers, 1)
+	assert.Equal(t, "Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=", cfg.Peers[0].PublicKey.String())
+	assert.Equal(t, "10.0.0.1/32", cfg.Peers[0].AllowedIPs[0].String())
+	assert.Equal(t, "172.31.23.163:50113", cfg.Peers[0].Endpoint.String())
+	assert.Equal(t, "FpCyhws9cxwWoV4xELtfJvjJN+zQVRPISllRWgeopVE=", cfg.Peers[0].PresharedKey.String())
+	assert.Equal(t, 25*time.Second, *cfg.Peers[0].PersistentKeepaliveInterval)
+}
+
+func TestDeviceConfigFwMark(t *testing.T) {
+	conf := Configuration{
+		Interface: Interface{
+			ListenPort: 49082,
+			PrivateKey: "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=",
+			FwMark:     51820,
+		},
+	}
+	cfg, err := deviceConfig(conf, wgtypes.Device{})
+	assert.NoError(t, err)
+	assert.Equal(t, 51820, *cfg.FirewallMark)
+
+	// unchanged
+	cfg, err = deviceConfig(conf, wgtypes.Device{FirewallMark: 51820})
+	assert.NoError(t, err)
+	assert.Nil(t, cfg.FirewallMark)
+
+	// cleared on a reused device
+	conf.Interface.FwMark = 0
+	cfg, err = deviceConfig(conf, wgtypes.Device{FirewallMark: 51820})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, *cfg.FirewallMark)
+}
+
+func TestDeviceConfigInvalidKey(t *testing.T) {
+	conf := Configuration{
+		Interface: Interface{
+			ListenPort: 49082,
+			PrivateKey: "not a key",
+		},
+	}
+	_, err := deviceConfig(conf, wgtypes.Device{})
+
+	assert.Error(t, err)
+}
+
+func TestDeviceConfigUpdatesPeersInPlace(t *testing.T) {
+	kept, _ := wgtypes.ParseKey("Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=")
+	removed, _ := wgtypes.ParseKey("FpCyhws9cxwWoV4xELtfJvjJN+zQVRPISllRWgeopVE=")
+	current := []wgtypes.Peer{{PublicKey: kept}, {PublicKey: removed}}
+
+	conf := Configuration{
+		Interface: Interface{
+			ListenPort: 49082,
+			PrivateKey: "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=",
+		},
+		Peers: []Peer{
+			{
+				PublicKey:  kept.String(),
+				AllowedIPs: "10.0.0.1/32",
+				Endpoint:   "172.31.23.164:50113",
+			},
+		},
+	}
+	cfg, err := deviceConfig(conf, wgtypes.Device{Peers: current})
+	assert.NoError(t, err)
+
+	assert.False(t, cfg.ReplacePeers)
+	assert.Len(t, cfg.Peers, 2)
+	assert.Equal(t, kept, cfg.Peers[0].PublicKey)
+	assert.False(t, cfg.Peers[0].Remove)
+	assert.Equal(t, "172.31.23.164:50113", cfg.Peers[0].Endpoint.String())
+	// a preshared key the peer had is cleared
+	assert.Equal(t, wgtypes.Key{}, *cfg.Peers[0].PresharedKey)
+	assert.Equal(t, removed, cfg.Peers[1].PublicKey)
+	assert.True(t, cfg.Peers[1].Remove)
+}
+
+func TestPeerStats(t *testing.T) {
+	key, err := wgtypes.ParseKey("Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handshake := time.Unix(1500000000, 0)
+	stats := peerStats([]wgtypes.Peer{
+		{
+			PublicKey:         key,
+			Endpoint:          &net.UDPAddr{IP: net.ParseIP("172.31.23.163"), Port: 50113},
+			LastHandshakeTime: handshake,
+			ReceiveBytes:      10,
+			TransmitBytes:     20,
+		},
+		{PublicKey: key},
+	})
+
+	assert.Equal(t, []PeerStats{
+		{
+			PublicKey:     "Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=",
+			Endpoint:      "172.31.23.163:50113",
+			LastHandshake: handshake,
+			ReceiveBytes:  10,
+			TransmitBytes: 20,
+		},
+		{PublicKey: "Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4="},
+	}, stats)
+}
@@ -7,18 +7,35 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"text/template"
 )
 
 type Interface struct {
 	ListenPort int
 	PrivateKey string
+	// FwMark marks the packets sent by wireguard for policy routing, 0 means unset.
+	FwMark int
+	// Address, MTU and DNS are only rendered by MarshalWgQuick, the
+	// address is in CIDR notation, e.g: 10.0.0.1/24, the dns servers are
+	// comma separated addresses.
+	Address string
+	MTU     int
+	DNS     string
 }
 
 type Peer struct {
 	PublicKey  string
 	AllowedIPs string
 	Endpoint   string
+	// PresharedKey is the optional symmetric key added to the handshake,
+	// empty means no preshared key.
+	PresharedKey string
+	// PersistentKeepalive is the interval in seconds between keepalive
+	// packets sent to the peer, 0 disables it (wireguard default).
+	// 25 is a common value to keep NAT mappings open.
+	PersistentKeepalive int
 }
 
 type Configuration struct {
@@ -27,13 +44,69 @@ type Configuration struct {
 }
 
 const (
-	errorWiregurdNotFound = "the wireguard (wg) command is not available in your PATH"
+	errorWiregurdNotFound = "the wireguard command %q is not available, install wg or set its path in %s: %w"
+	errorSyncconfMissing  = "the wireguard command %q has no syncconf subcommand, wireguard-tools 1.0.20191226 or later is needed: %w"
 )
 
+// noPresharedKey is the all zero preshared key, the one of the peers
+// without a preshared key.
+const noPresharedKey = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+// BinaryEnv is the env variable holding the path, or the name to look up
+// in the PATH, of the wg binary, it defaults to wg.
+const BinaryEnv = "WIREY_WG"
+
+var (
+	binaryMutex sync.Mutex
+	// binaryPath is the resolved path of the wg binary, empty until resolved
+	binaryPath string
+)
+
+// SetBinary resolves the path, or the name to look up in the PATH, of the
+// wg binary, e.g: for a copy out of the PATH, and uses it from now on.
+func SetBinary(name string) error {
+	path, err := resolveBinary(name)
+	if err != nil {
+		return err
+	}
+	binaryMutex.Lock()
+	binaryPath = path
+	binaryMutex.Unlock()
+	return nil
+}
+
+func resolveBinary(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf(errorWiregurdNotFound, name, BinaryEnv, err)
+	}
+	return path, nil
+}
+
+// binary returns the wg binary set with SetBinary, otherwise the one in
+// BinaryEnv or in the PATH, resolved on the first call.
+func binary() (string, error) {
+	binaryMutex.Lock()
+	defer binaryMutex.Unlock()
+	if len(binaryPath) > 0 {
+		return binaryPath, nil
+	}
+	name := os.Getenv(BinaryEnv)
+	if len(name) == 0 {
+		name = "wg"
+	}
+	path, err := resolveBinary(name)
+	if err != nil {
+		return "", err
+	}
+	binaryPath = path
+	return path, nil
+}
+
 func wg(stdin io.Reader, arg ...string) ([]byte, error) {
-	path, err := exec.LookPath("wg")
+	path, err := binary()
 	if err != nil {
-		return nil, fmt.Errorf(errorWiregurdNotFound)
+		return nil, err
 	}
 
 	cmd := exec.Command(path, arg...)
@@ -50,24 +123,63 @@ func wg(stdin io.Reader, arg ...string) ([]byte, error) {
 
 }
 
-func Genkey() ([]byte, error) {
+// Genkey generates a new base64 private key with wg genkey,
+// without the trailing newline.
+func Genkey() (string, error) {
 	result, err := wg(nil, "genkey")
 	if err != nil {
-		return nil, fmt.Errorf("error generating the private key for wireguard: %s", err.Error())
+		return "", fmt.Errorf("error generating the private key for wireguard: %w", err)
 	}
-	return result, nil
+	return strings.TrimSpace(string(result)), nil
 }
 
+// Genpsk generates a new base64 preshared key with wg genpsk,
+// without the trailing newline.
+func Genpsk() (string, error) {
+	result, err := wg(nil, "genpsk")
+	if err != nil {
+		return "", fmt.Errorf("error generating the preshared key for wireguard: %w", err)
+	}
+	return strings.TrimSpace(string(result)), nil
+}
+
+// ExtractPubKey returns the public key of the base64 private key using wg pubkey,
+// see Pubkey to do the same without the wg binary.
 func ExtractPubKey(privateKey []byte) ([]byte, error) {
 	stdin := bytes.NewReader(privateKey)
 	result, err := wg(stdin, "pubkey")
 	if err != nil {
-		return nil, fmt.Errorf("error extracting the public key: %s", err.Error())
+		return nil, fmt.Errorf("error extracting the public key: %w", err)
 	}
 	return result, nil
 }
 
+// SetConf configures the wireguard device using wgctrl, falling back to
+// the wg binary only if wgctrl cannot find the device.
 func SetConf(ifname string, conf Configuration) ([]byte, error) {
+	err := configureDevice(ifname, conf)
+	if err == nil {
+		return nil, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error setting the configuration for wireguard: %w", err)
+	}
+	return setConfWg(ifname, conf)
+}
+
+// setConfWg applies the configuration with wg syncconf, which like
+// configureDevice only touches the peers that changed.
+func setConfWg(ifname string, conf Configuration) ([]byte, error) {
+	// syncconf keeps the preshared key of a peer configured without one
+	peers := make([]Peer, len(conf.Peers))
+	for n, p := range conf.Peers {
+		if len(strings.TrimSpace(p.PresharedKey)) == 0 {
+			p.PresharedKey = noPresharedKey
+		}
+		peers[n] = p
+	}
+	conf.Peers = peers
+
 	cfile, err := ioutil.TempFile("", "wgconfig")
 	if err != nil {
 		return nil, err
@@ -81,16 +193,31 @@ func SetConf(ifname string, conf Configuration) ([]byte, error) {
 		return nil, err
 	}
 
-	result, err := wg(nil, "setconf", "wg0", cfile.Name())
+	result, err := wg(nil, "syncconf", ifname, cfile.Name())
 
+	// the older wg only print their usage
+	if err != nil && strings.Contains(err.Error(), "Invalid subcommand") {
+		path, _ := binary()
+		return nil, fmt.Errorf(errorSyncconfMissing, path, err)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error setting the configuration for wireguard: %s", err.Error())
+		return nil, fmt.Errorf("error setting the configuration for wireguard: %w", err)
 	}
 	return result, nil
 }
 
 func RenderConfiguration(conf Configuration) ([]byte, error) {
-	t := template.Must(template.New("config").Parse(confTemplate))
+	return render(confTemplate, conf)
+}
+
+// MarshalWgQuick renders the configuration as a wg-quick .conf file,
+// e.g: to debug it with the stock tools or to stop using wirey.
+func MarshalWgQuick(conf Configuration) ([]byte, error) {
+	return render(wgQuickTemplate, conf)
+}
+
+func render(text string, conf Configuration) ([]byte, error) {
+	t := template.Must(template.New("config").Parse(text))
 	buf := &bytes.Buffer{}
 
 	err := t.Execute(buf, conf)
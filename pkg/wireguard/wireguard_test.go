@@ -1,6 +1,9 @@
 package wireguard
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -50,3 +53,157 @@ Endpoint = 172.31.23.162:43043
 
 	assert.Equal(t, expected, string(rendered))
 }
+
+func TestRenderConfigurationOptionalPeerFields(t *testing.T) {
+	conf := Configuration{
+		Interface: Interface{
+			ListenPort: 49082,
+			PrivateKey: "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=",
+			FwMark:     51820,
+		},
+		Peers: []Peer{
+			{
+				PublicKey:           "Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=",
+				AllowedIPs:          "10.0.0.1/32",
+				Endpoint:            "172.31.23.163:50113",
+				PresharedKey:        "FpCyhws9cxwWoV4xELtfJvjJN+zQVRPISllRWgeopVE=",
+				PersistentKeepalive: 25,
+			},
+		},
+	}
+	rendered, err := RenderConfiguration(conf)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected := `[Interface]
+ListenPort = 49082
+PrivateKey = iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=
+FwMark = 51820
+
+
+[Peer]
+PublicKey = Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=
+AllowedIPs = 10.0.0.1/32
+Endpoint = 172.31.23.163:50113
+PresharedKey = FpCyhws9cxwWoV4xELtfJvjJN+zQVRPISllRWgeopVE=
+PersistentKeepalive = 25
+`
+
+	assert.Equal(t, expected, string(rendered))
+}
+
+func TestMarshalWgQuick(t *testing.T) {
+	conf := Configuration{
+		Interface: Interface{
+			ListenPort: 49082,
+			PrivateKey: "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=",
+			Address:    "10.0.0.2/24",
+			MTU:        1420,
+		},
+		Peers: []Peer{
+			{
+				PublicKey:  "Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=",
+				AllowedIPs: "10.0.0.1/32",
+				Endpoint:   "172.31.23.163:50113",
+			},
+		},
+	}
+	rendered, err := MarshalWgQuick(conf)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected := `[Interface]
+Address = 10.0.0.2/24
+ListenPort = 49082
+PrivateKey = iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=
+MTU = 1420
+
+
+[Peer]
+PublicKey = Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=
+AllowedIPs = 10.0.0.1/32
+Endpoint = 172.31.23.163:50113
+`
+
+	assert.Equal(t, expected, string(rendered))
+
+	// wg setconf does not know the wg-quick fields
+	rendered, err = RenderConfiguration(conf)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(rendered), "Address")
+	assert.NotContains(t, string(rendered), "MTU")
+}
+
+func TestBinaryNotFound(t *testing.T) {
+	defer func(path string) { binaryPath = path }(binaryPath)
+
+	err := SetBinary("/nonexistent/wg")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `the wireguard command "/nonexistent/wg" is not available, install wg or set its path in WIREY_WG`)
+
+	binaryPath = ""
+	defer os.Setenv(BinaryEnv, os.Getenv(BinaryEnv))
+	os.Setenv(BinaryEnv, "wirey-missing-wg")
+	_, err = Genkey()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `the wireguard command "wirey-missing-wg" is not available`)
+	assert.Empty(t, binaryPath)
+}
+
+func TestSetBinary(t *testing.T) {
+	defer func(path string) { binaryPath = path }(binaryPath)
+
+	// any executable does, it is only resolved
+	assert.NoError(t, SetBinary("sh"))
+	path, err := binary()
+	assert.NoError(t, err)
+	assert.True(t, filepath.IsAbs(path))
+}
+
+// fakeWg uses a script printing stderr and copying the configuration it is
+// passed to conf as the wg binary.
+func fakeWg(t *testing.T, dir, stderr string) (conf string) {
+	conf = filepath.Join(dir, "conf")
+	script := filepath.Join(dir, "wg")
+	body := "#!/bin/sh\ncp \"$3\" " + conf + "\n"
+	if len(stderr) > 0 {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "stderr"), []byte(stderr), 0600))
+		body += "cat " + filepath.Join(dir, "stderr") + " >&2\nexit 1\n"
+	}
+	assert.NoError(t, ioutil.WriteFile(script, []byte(body), 0700))
+	assert.NoError(t, SetBinary(script))
+	return conf
+}
+
+func TestSetConfWg(t *testing.T) {
+	defer func(path string) { binaryPath = path }(binaryPath)
+	dir, err := ioutil.TempDir("", "wirey")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := Configuration{
+		Interface: Interface{ListenPort: 49082, PrivateKey: "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k="},
+		Peers: []Peer{{
+			PublicKey:  "Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=",
+			AllowedIPs: "10.0.0.1/32",
+			Endpoint:   "172.31.23.163:50113",
+		}},
+	}
+	written := fakeWg(t, dir, "")
+	_, err = setConfWg("wg0", conf)
+	assert.NoError(t, err)
+	rendered, err := ioutil.ReadFile(written)
+	assert.NoError(t, err)
+	// the preshared key the peer had is cleared
+	assert.Contains(t, string(rendered), "PresharedKey = "+noPresharedKey)
+	assert.Empty(t, conf.Peers[0].PresharedKey)
+
+	fakeWg(t, dir, "Invalid subcommand: `syncconf'")
+	_, err = setConfWg("wg0", conf)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "has no syncconf subcommand, wireguard-tools 1.0.20191226 or later is needed")
+}